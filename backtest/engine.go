@@ -0,0 +1,446 @@
+package backtest
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultCapital is the initial quote balance used when Engine.Capital is unset.
+	DefaultCapital = 1.0
+	// DefaultATRPeriod is used by Profile.TrailingATR when ATRPeriod is unset.
+	DefaultATRPeriod = 14
+)
+
+// Engine runs any strategy.Strategy against any trading.DataSource, opening
+// positions through Broker on signals and closing them according to Profile.
+type Engine struct {
+	Source   trading.DataSource
+	Strategy strategy.Strategy
+	Broker   trading.Broker
+	Profile  trading.Profile
+
+	Base, Quote string
+	// Markets, when set, are traded on Source ticks of their base & quote in place
+	// of Strategy, Base, Quote & Lower. They share Account according to Allocation,
+	// and must have the same quote.
+	Markets    []Market
+	Allocation Allocation
+	// Sizer sizes positions within funds allowed by Allocation, all of them if unset.
+	Sizer Sizer
+	// Shorts enables opening short positions on strategy.Sell signals, paper
+	// brokers without Margin nor Perpetuals use trading.DefaultMargin.
+	Shorts bool
+	// Reverse closes positions on entry signals of the opposite direction,
+	// opening the opposite position if allowed. Exit signals always close them.
+	Reverse bool
+	// Capital is deposited in Quote on a new Account, unless Account is set.
+	Capital float64
+	// Account holds funds of the backtest, it can be shared by several engines.
+	Account *trading.Account
+
+	// Execution sets how Profile levels are checked, Lower holds lower
+	// timeframe data for the LowerTimeframe rule.
+	Execution Execution
+	Lower     ts.OHLCVs
+
+	// Chart enables drawing of signals and, if Strategy is a
+	// strategy.Drawer, of the strategy itself on the chart package.
+	Chart bool
+
+	// broker is Broker, or a fresh copy of it when trading on paper.
+	broker trading.Broker
+}
+
+// market is the state of a Market during Run.
+type market struct {
+	Market
+	pos *trading.Position
+	// tracker applies Profile to pos, atr is only set for TrailingATR,
+	// volatility for VolatilityTarget sizing.
+	tracker    *trading.Tracker
+	atr        *indicator.ATR
+	volatility *indicator.ATR
+	last       strategy.Signal
+	lastTime   time.Time
+	// orders deferred to next bar open, pendingExit is a quantity of pos
+	pendingOpen strategy.Action
+	pendingExit float64
+}
+
+// markets returns e markets, Strategy on Base & Quote when Markets is empty.
+func (e *Engine) markets() ([]*market, error) {
+	if len(e.Markets) == 0 {
+		if e.Strategy == nil {
+			return nil, fmt.Errorf("engine: nil strategy")
+		}
+		if e.Base == "" || e.Quote == "" {
+			return nil, fmt.Errorf("engine: empty base or quote")
+		}
+		return []*market{{Market: Market{e.Base, e.Quote, e.Strategy, e.Lower}}}, nil
+	}
+	var markets []*market
+	for _, m := range e.Markets {
+		if m.Strategy == nil {
+			return nil, fmt.Errorf("engine: nil strategy for %s%s", m.Base, m.Quote)
+		}
+		if m.Base == "" || m.Quote == "" {
+			return nil, fmt.Errorf("engine: empty base or quote")
+		}
+		if m.Quote != e.Markets[0].Quote {
+			return nil, fmt.Errorf("engine: %s%s quote differs from %s", m.Base, m.Quote, e.Markets[0].Quote)
+		}
+		markets = append(markets, &market{Market: m})
+	}
+	return markets, nil
+}
+
+func (e *Engine) Run() (*Result, error) {
+	if e.Source == nil {
+		return nil, fmt.Errorf("engine: nil data source")
+	}
+	if e.Broker == nil {
+		return nil, fmt.Errorf("engine: nil broker")
+	}
+	markets, err := e.markets()
+	if err != nil {
+		return nil, err
+	}
+	if e.Chart && len(markets) == 1 {
+		if d, ok := markets[0].Strategy.(strategy.Drawer); ok {
+			d.Record(true)
+		}
+	}
+	quote := markets[0].Quote
+	byPair := make(map[string]*market)
+	for _, m := range markets {
+		byPair[m.Base+"/"+m.Quote] = m
+		if e.Profile.TrailingATR > 0 {
+			period := e.Profile.ATRPeriod
+			if period <= 0 {
+				period = DefaultATRPeriod
+			}
+			m.atr = indicator.NewATR(period)
+		}
+		if e.Sizer.Policy == VolatilityTarget {
+			period := e.Sizer.ATRPeriod
+			if period <= 0 {
+				period = DefaultATRPeriod
+			}
+			m.volatility = indicator.NewATR(period)
+		}
+	}
+
+	account := e.Account
+	if account == nil {
+		account = trading.NewAccount()
+		k := e.Capital
+		if k == 0 {
+			k = DefaultCapital
+		}
+		account.Deposit(quote, k)
+	}
+	feesRate, leverage := trading.DefaultFees, 1.0
+	instrument := trading.Spot
+	e.broker = e.Broker
+	if pb, ok := e.Broker.(*trading.PaperTrading); ok {
+		// fresh paper broker, so that orders do not pile up across runs
+		paper := &trading.PaperTrading{
+			FeesRate:   pb.FeesRate,
+			Symbols:    pb.Symbols,
+			Slippage:   e.Execution.Slippage(),
+			Account:    account,
+			Margin:     pb.Margin,
+			Perpetuals: pb.Perpetuals,
+		}
+		if pb.Perpetuals != nil {
+			instrument = trading.Perpetual
+			if pb.Perpetuals.Leverage > 0 {
+				leverage = pb.Perpetuals.Leverage
+			}
+		} else if e.Shorts && paper.Margin == nil {
+			margin := trading.DefaultMargin
+			paper.Margin = &margin
+		}
+		e.broker = paper
+		feesRate = pb.FeesRate
+	}
+
+	var result = &Result{Capital: account.Total(quote)}
+	result.From, result.To = e.Source.Bondaries()
+
+	// equity values open positions as if closed at their last tick,
+	// free excludes what buying back shorts would cost
+	equity := func() (equity, free float64, open int) {
+		equity, free = account.Total(quote), account.Free(quote)
+		for _, m := range markets {
+			if m.pos != nil && m.pos.Active() {
+				v := m.pos.Value()
+				equity += v
+				if v < 0 {
+					free += v
+				}
+				open++
+			}
+		}
+		return equity, free, open
+	}
+	// settle charts pos once it is closed, fills are already on account
+	settle := func(m *market, x trading.Tick) {
+		if m.pos.State != trading.Closed {
+			return
+		}
+		if e.Chart {
+			chart.AddSignal(x.Timestamp.T(), m.pos.Direction == trading.Short, true, m.pos.AvgExit)
+		}
+	}
+	closed := func() []*trading.Position {
+		var positions []*trading.Position
+		for _, p := range result.Positions {
+			if p.State == trading.Closed {
+				positions = append(positions, p)
+			}
+		}
+		return positions
+	}
+	open := func(m *market, x trading.Tick, price float64, direction trading.Direction) {
+		pos := trading.NewPosition(e.broker, m.Base, m.Quote, direction)
+		pos.Instrument = instrument
+		pos.SetTick(x.OHLCV)
+		e.fillAt(x.Timestamp.T(), price)
+		total, free, n := equity()
+		funds := e.Allocation.Funds(total, free, len(markets), n)
+		var atr float64
+		if m.volatility != nil {
+			atr = m.volatility.Value()
+		}
+		funds = math.Min(funds, e.Sizer.Funds(total, price, e.Profile.StopLoss, atr, leverage, closed()))
+		if funds <= 0 {
+			// no allocation left for m
+			return
+		}
+		m.pos = pos
+		fn := pos.MarketBuy
+		if direction == trading.Short {
+			fn = pos.MarketSell
+		}
+		err := fn(e.maxBuy(funds, price, leverage, feesRate))
+		if err != nil {
+			log.Printf("engine: open %s%s: %s", m.Base, m.Quote, err)
+			m.pos = nil
+			return
+		}
+		m.tracker = trading.NewTracker(e.Profile, direction, pos.AvgEntry, pos.OpenTime)
+		result.Positions = append(result.Positions, pos)
+		if e.Chart {
+			chart.AddSignal(x.Timestamp.T(), direction == trading.Long, true, price)
+		}
+	}
+
+	for x := range e.Source.Feed() {
+		m := markets[0]
+		if len(e.Markets) > 0 {
+			if m = byPair[x.Base+"/"+x.Quote]; m == nil {
+				continue
+			}
+		}
+
+		// sources mixing timeframes may feed bars older than the previous one
+		inOrder := x.Timestamp.T().After(m.lastTime)
+		if inOrder {
+			m.lastTime = x.Timestamp.T()
+		}
+
+		// set price & time on paper, older bars would match orders in the past
+		if m.pos != nil && inOrder {
+			m.pos.SetTick(x.OHLCV)
+		}
+
+		// fill deferred orders at bar open
+		if inOrder && (m.pendingOpen != strategy.None || m.pendingExit > 0) {
+			e.fillAt(x.Timestamp.T(), x.Open)
+			if m.pendingExit > 0 && m.pos.Active() {
+				e.reduce(m.pos, m.pendingExit)
+				settle(m, x)
+			}
+			if m.pendingOpen != strategy.None {
+				open(m, x, x.Open, m.pendingOpen == strategy.Buy)
+			}
+			m.pendingOpen, m.pendingExit = strategy.None, 0
+			if m.pos != nil {
+				m.pos.SetTick(x.OHLCV)
+			}
+		}
+
+		// manage position
+		if pos := m.pos; pos != nil && pos.Active() {
+			if e.Execution.IsIntrabar() {
+				// only bars after entry can touch levels
+				if inOrder && x.Timestamp.T().After(pos.OpenTime) {
+					e.exitIntrabar(m, x)
+				}
+			} else if inOrder {
+				e.exitOnClose(m, x)
+			}
+			settle(m, x)
+		}
+
+		// follow bar once exits were checked against it
+		if inOrder {
+			var atr float64
+			if m.atr != nil {
+				m.atr.Add(x.OHLCV)
+				atr = m.atr.Value()
+			}
+			if m.volatility != nil {
+				m.volatility.Add(x.OHLCV)
+			}
+			if m.pos != nil && m.pos.Active() && x.Timestamp.T().After(m.pos.OpenTime) {
+				m.tracker.Update(x.OHLCV, atr)
+			}
+		}
+
+		// feed strat
+		m.Strategy.AddTick(x)
+
+		// signal changed
+		if s := m.Strategy.Signal(); s.Action != m.last.Action {
+			m.last = s
+
+			closing := false
+			if pos := m.pos; pos != nil && pos.Active() && m.last.Action.Closes(pos.Direction, e.Reverse) {
+				closing = true
+				if e.Execution.NextOpen {
+					m.pendingExit = pos.Total - pos.Traded
+				} else {
+					e.fillAt(x.Timestamp.T(), x.Close)
+					e.close(pos)
+					settle(m, x)
+				}
+			}
+
+			if closing || m.pos == nil || m.pos.State == trading.Closed {
+				// buy signal -> open long, sell signal -> open short
+				if m.last.Action == strategy.Buy || m.last.Action == strategy.Sell && e.Shorts {
+					if e.Execution.NextOpen {
+						m.pendingOpen = m.last.Action
+					} else {
+						open(m, x, x.Close, m.last.Action == strategy.Buy)
+					}
+				}
+			}
+		}
+
+		// record mark-to-market equity
+		total, _, _ := equity()
+		result.Equity.Add(x.Timestamp.T(), total, account.Total(quote))
+	}
+
+	result.UpdateScore()
+
+	if e.Chart && len(markets) == 1 {
+		if d, ok := markets[0].Strategy.(strategy.Drawer); ok {
+			err := d.Draw()
+			if err != nil {
+				return result, fmt.Errorf("strategy draw: %s", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// exitOnClose exits m position at bar x close, or next open, when its take profit,
+// stop or max holding time is reached. Without Ladder, take profit & stop loss
+// are checked on net gains.
+func (e *Engine) exitOnClose(m *market, x trading.Tick) {
+	pos, tr := m.pos, m.tracker
+	remaining := pos.Total - pos.Traded
+	var q float64
+	if len(e.Profile.Ladder) == 0 {
+		potentialNet := pos.NetOnClose()
+		if (potentialNet > 0 && potentialNet > e.Profile.TakeProfit*pos.Cost()) ||
+			(potentialNet < 0 && potentialNet < -e.Profile.StopLoss*pos.Cost()) {
+			q = remaining
+		}
+	} else if tp, fraction := tr.Target(); tr.Reached(x.Close, tp) {
+		q = fraction * pos.Total
+		tr.Steps++
+	}
+	if tr.Stopped(x.Close) || tr.Expired(x.Timestamp.T()) {
+		q = remaining
+	}
+	if q <= 0 {
+		return
+	}
+	if e.Execution.NextOpen {
+		m.pendingExit = q
+	} else {
+		e.reduce(pos, q)
+	}
+}
+
+// exitIntrabar exits m position at the price its take profit or stop
+// level was touched by bar x, if any, or at close past max holding time.
+func (e *Engine) exitIntrabar(m *market, x trading.Tick) {
+	pos, tr := m.pos, m.tracker
+	tp, fraction := tr.Target()
+	price, ok := e.Execution.ExitPrice(pos.Direction, tp, tr.Stop, x.OHLCV, x.Timeframe.ToDuration(), m.Lower)
+	if ok {
+		e.fillAt(x.Timestamp.T(), price)
+		if tr.Stopped(price) {
+			e.close(pos)
+		} else {
+			tr.Steps++
+			e.reduce(pos, fraction*pos.Total)
+		}
+	}
+	if pos.Active() && tr.Expired(x.Timestamp.T()) {
+		if e.Execution.NextOpen {
+			m.pendingExit = pos.Total - pos.Traded
+		} else {
+			e.fillAt(x.Timestamp.T(), x.Close)
+			e.close(pos)
+		}
+	}
+}
+
+// maxBuy returns the largest quantity funds can buy at price, fees & slippage included.
+// It is also the quantity funds can sell short, as collateral of the loan. Funds are
+// the margin of leveraged positions, fees are paid on their whole notional.
+func (e *Engine) maxBuy(funds, price, leverage, feesRate float64) float64 {
+	q := funds / (price * (1/leverage + feesRate))
+	if pb, ok := e.broker.(*trading.PaperTrading); ok {
+		// slippage grows with quantity, so the fill price of q is an upper bound
+		q = funds / (pb.FillPrice(trading.Buy, q) * (1/leverage + feesRate))
+	}
+	return q
+}
+
+// fillAt sets price & time of next fills when trading on paper.
+func (e *Engine) fillAt(t time.Time, price float64) {
+	if pb, ok := e.broker.(*trading.PaperTrading); ok {
+		pb.SetPrice(t, price)
+	}
+}
+
+// reduce closes q of pos, all of it if q covers what is left.
+func (e *Engine) reduce(pos *trading.Position, q float64) {
+	err := pos.Reduce(q)
+	if err != nil {
+		log.Printf("engine: reduce %s%s: %s", pos.Base, pos.Quote, err)
+	}
+}
+
+func (e *Engine) close(pos *trading.Position) {
+	err := pos.Close()
+	if err != nil {
+		log.Printf("engine: close %s%s: %s", pos.Base, pos.Quote, err)
+	}
+}
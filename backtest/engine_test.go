@@ -0,0 +1,368 @@
+package backtest
+
+import (
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"testing"
+	"time"
+)
+
+// scripted emits a fixed action at given tick indexes.
+type scripted struct {
+	actions map[int]strategy.Action
+	i       int
+	last    strategy.Signal
+}
+
+func (s *scripted) AddTick(x trading.Tick) {
+	if a, ok := s.actions[s.i]; ok {
+		s.last = strategy.Signal{Action: a, Time: x.Timestamp.T(), Strength: 1}
+	}
+	s.i++
+}
+
+func (s *scripted) Signal() strategy.Signal {
+	return s.last
+}
+
+func testHistorical(closes ...float64) *Historical {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &Historical{
+		Source: Source{
+			Base: "ABC", Quote: "BTC",
+			Timeframe: ts.Timeframe{N: 1, Unit: ts.TfDay},
+			From:      t0,
+			To:        t0.Add(time.Hour * 24 * time.Duration(len(closes)-1)),
+		},
+	}
+	for i, c := range closes {
+		h.Data = append(h.Data, ts.OHLCV{
+			Timestamp: util.JSONTime(t0.Add(time.Hour * 24 * time.Duration(i))),
+			Open:      c, High: c, Low: c, Close: c, Volume: 1,
+		})
+	}
+	return h
+}
+
+func almostEq(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEngine_Run(t *testing.T) {
+	e := Engine{
+		Source: testHistorical(10, 10, 10, 11, 12, 10, 10, 9.5),
+		Strategy: &scripted{actions: map[int]strategy.Action{
+			1: strategy.Buy, 2: strategy.None, 5: strategy.Buy,
+		}},
+		Broker:  &trading.PaperTrading{},
+		Profile: trading.Profile{TakeProfit: 0.15, StopLoss: 0.025},
+		Base:    "ABC", Quote: "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 2 {
+		t.Fatalf("expected 2 positions, got %d", len(res.Positions))
+	}
+	p0, p1 := res.Positions[0], res.Positions[1]
+	if p0.State != trading.Closed || !almostEq(p0.AvgEntry, 10) || !almostEq(p0.AvgExit, 12) {
+		t.Errorf("unexpected first position: %s", p0)
+	}
+	if p1.State != trading.Closed || !almostEq(p1.AvgEntry, 10) || !almostEq(p1.AvgExit, 9.5) {
+		t.Errorf("unexpected second position: %s", p1)
+	}
+	// k0 = 1, first trade +20% (1.2), second reinvests 1.2 and loses 5%
+	if expected := 0.2 - 1.2*0.05; !almostEq(res.Score, expected) {
+		t.Errorf("expected score %f, got %f", expected, res.Score)
+	}
+
+	if len(res.Equity) != 8 {
+		t.Fatalf("expected 8 equity points, got %d", len(res.Equity))
+	}
+	// long opened at 10 on tick 1, marked at 11 on tick 3
+	if pt := res.Equity[3]; !almostEq(pt.Equity, 1.1) || !almostEq(pt.Cash, 0) {
+		t.Errorf("unexpected equity point: %+v", pt)
+	}
+	if pt := res.Equity[7]; !almostEq(pt.Equity, 1+res.Score) || !almostEq(pt.Cash, pt.Equity) {
+		t.Errorf("unexpected last equity point: %+v", pt)
+	}
+}
+
+func TestEngine_RunNextOpen(t *testing.T) {
+	h := testHistorical(10, 10, 11, 12, 12)
+	h.Data[4].Open = 13
+	e := Engine{
+		Source:    h,
+		Strategy:  &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:    &trading.PaperTrading{},
+		Profile:   trading.Profile{TakeProfit: 0.05, StopLoss: 0.05},
+		Execution: Execution{NextOpen: true},
+		Base:      "ABC",
+		Quote:     "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	// signal on 2nd bar close, filled at 3rd bar open, take profit on
+	// 4th bar close filled at 5th bar open
+	if p := res.Positions[0]; !almostEq(p.AvgEntry, 11) || !almostEq(p.AvgExit, 13) || p.State != trading.Closed {
+		t.Errorf("expected 11 -> 13 position, got %s", p)
+	}
+}
+
+func TestEngine_RunRejectedOpen(t *testing.T) {
+	e := Engine{
+		Source:   testHistorical(10, 10, 10, 10, 12),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy, 2: strategy.None, 3: strategy.Buy}},
+		Broker: &trading.PaperTrading{
+			// capital of 1 is below min notional, both signals are rejected
+			Symbols: trading.Symbols{"ABCBTC": {Symbol: "ABCBTC", MinNotional: 1.5}},
+		},
+		Profile: trading.Profile{TakeProfit: 0.1, StopLoss: 0.1},
+		Base:    "ABC", Quote: "BTC",
+		Capital: 1,
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 0 {
+		t.Fatalf("expected rejected orders not to open positions, got %d", len(res.Positions))
+	}
+	e.Capital = 2
+	e.Strategy = &scripted{actions: map[int]strategy.Action{1: strategy.Buy}}
+	if res, _ = e.Run(); len(res.Positions) != 1 || res.Positions[0].State != trading.Closed {
+		t.Errorf("expected one closed position with enough capital")
+	}
+}
+
+func TestEngine_RunAccount(t *testing.T) {
+	account := trading.NewAccount()
+	account.Deposit("BTC", 2)
+	e := Engine{
+		Source:   testHistorical(10, 10, 10, 11, 12, 10, 10, 9.5),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy, 2: strategy.None, 5: strategy.Buy}},
+		Broker:   &trading.PaperTrading{FeesRate: trading.DefaultFees, Slippage: trading.FixedBps(10)},
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.025},
+		Base:     "ABC",
+		Quote:    "BTC",
+		Account:  account,
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 2 || res.Capital != 2 {
+		t.Fatalf("expected 2 positions on a capital of 2, got %d on %f", len(res.Positions), res.Capital)
+	}
+	if !almostEq(account.Total("BTC"), 2+res.Score) || account.Total("ABC") > 1e-9 {
+		t.Errorf("expected account to hold capital + score, got %s", account)
+	}
+}
+
+func TestEngine_RunShort(t *testing.T) {
+	e := Engine{
+		Source:   testHistorical(10, 10, 9, 8, 8),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Sell}},
+		Broker:   &trading.PaperTrading{},
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+		Base:     "ABC",
+		Quote:    "BTC",
+	}
+	if res, _ := e.Run(); len(res.Positions) != 0 {
+		t.Fatalf("expected sell signals to be ignored without shorts")
+	}
+	e.Shorts = true
+	e.Strategy = &scripted{actions: map[int]strategy.Action{1: strategy.Sell}}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	// default margin interest on 0.1 borrowed, marked at 9 then 8
+	interest := 0.1 * (9 + 8) * trading.DefaultMargin.InterestRate
+	if p := res.Positions[0]; p.Direction != trading.Short || p.State != trading.Closed ||
+		!almostEq(p.AvgExit, 8) || !almostEq(p.Net(), 0.2-interest) {
+		t.Errorf("expected short closed at 8, got %s", p)
+	}
+	if pt := res.Equity[len(res.Equity)-1]; !almostEq(pt.Equity, 1+res.Score) {
+		t.Errorf("unexpected last equity point: %+v", pt)
+	}
+}
+
+func TestEngine_RunPerpetual(t *testing.T) {
+	e := Engine{
+		Source:   testHistorical(10, 10, 11, 12, 12),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   &trading.PaperTrading{Perpetuals: &trading.Perpetuals{Leverage: 2}},
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+		Base:     "ABC",
+		Quote:    "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	// capital of 1 as margin of a 2 notional, +20% at 12
+	if p := res.Positions[0]; p.Instrument != trading.Perpetual || !almostEq(p.Total, 0.2) ||
+		p.State != trading.Closed || !almostEq(p.Net(), 0.4) {
+		t.Errorf("expected 2x position closed at 12, got %s", p)
+	}
+	if pt := res.Equity[2]; !almostEq(pt.Equity, 1.2) || !almostEq(pt.Cash, 1) {
+		t.Errorf("unexpected equity point: %+v", pt)
+	}
+}
+
+func TestEngine_RunExits(t *testing.T) {
+	e := Engine{
+		Source:   testHistorical(10, 10, 11, 12, 12, 12),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   &trading.PaperTrading{},
+		Profile: trading.Profile{
+			StopLoss: 0.1,
+			Ladder:   trading.Ladder{{Gain: 0.05, Fraction: 0.5}, {Gain: 0.15, Fraction: 0.5}},
+		},
+		Base: "ABC", Quote: "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	// half closed at 11, the rest at 12
+	if p := res.Positions[0]; p.State != trading.Closed || !almostEq(p.AvgExit, 11.5) || len(p.Orders) != 3 {
+		t.Errorf("expected 2 exits averaging 11.5, got %s", p)
+	}
+	// after the first exit, half is in cash and half is held at 11
+	if pt := res.Equity[2]; !almostEq(pt.Equity, 1.1) || !almostEq(pt.Cash, 0.55) {
+		t.Errorf("unexpected equity point after partial exit: %+v", pt)
+	}
+	if pt := res.Equity[3]; !almostEq(pt.Equity, 1.15) || !almostEq(pt.Cash, 1.15) {
+		t.Errorf("unexpected equity point after last exit: %+v", pt)
+	}
+
+	e.Strategy = &scripted{actions: map[int]strategy.Action{1: strategy.Buy}}
+	e.Profile = trading.Profile{TakeProfit: 1, StopLoss: 1, MaxHolding: 48 * time.Hour}
+	if res, err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if p := res.Positions[0]; p.State != trading.Closed || !almostEq(p.AvgExit, 12) {
+		t.Errorf("expected position closed at 12 after 2 days, got %s", p)
+	}
+}
+
+func TestEngine_RunOlderBars(t *testing.T) {
+	h := testHistorical(10, 10, 11, 11)
+	// a bar of a slower timeframe fed late, crashed below the stop
+	stale := h.Data[0]
+	stale.Open, stale.High, stale.Low, stale.Close = 5, 5, 5, 5
+	h.Data = append(h.Data, stale)
+	e := Engine{
+		Source:   h,
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   &trading.PaperTrading{},
+		Profile:  trading.Profile{TakeProfit: 1, StopLoss: 0.1},
+		Base:     "ABC", Quote: "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := res.Positions[0]; p.State == trading.Closed {
+		t.Errorf("expected position not stopped by an older bar, got %s", p)
+	}
+	if pb := e.broker.(*trading.PaperTrading); pb.Price != 11 || !pb.Time.Equal(h.Data[3].Timestamp.T()) {
+		t.Errorf("expected paper price & time of the last bar, got %f at %s", pb.Price, pb.Time)
+	}
+}
+
+func TestEngine_RunSignalExits(t *testing.T) {
+	e := Engine{
+		Source: testHistorical(10, 10, 11, 12, 11, 10),
+		Strategy: &scripted{actions: map[int]strategy.Action{
+			0: strategy.ExitLong, 1: strategy.Buy, 3: strategy.ExitShort, 4: strategy.ExitLong,
+		}},
+		Broker:  &trading.PaperTrading{},
+		Profile: trading.Profile{TakeProfit: 1, StopLoss: 1},
+		Base:    "ABC", Quote: "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exit long without position and exit short are ignored
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	if p := res.Positions[0]; p.State != trading.Closed || !almostEq(p.AvgEntry, 10) || !almostEq(p.AvgExit, 11) {
+		t.Errorf("expected 10 -> 11 position closed on exit signal, got %s", p)
+	}
+}
+
+func TestEngine_RunReverse(t *testing.T) {
+	newEngine := func() Engine {
+		return Engine{
+			Source:   testHistorical(10, 10, 12, 12, 8, 8),
+			Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy, 3: strategy.Sell}},
+			Broker:   &trading.PaperTrading{},
+			Profile:  trading.Profile{TakeProfit: 0.25, StopLoss: 0.25},
+			Base:     "ABC", Quote: "BTC",
+			Shorts: true,
+		}
+	}
+	e := newEngine()
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 || !res.Positions[0].Active() {
+		t.Fatalf("expected sell signal not to close the long without reverse")
+	}
+
+	e = newEngine()
+	e.Reverse = true
+	if res, err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 2 {
+		t.Fatalf("expected 2 positions, got %d", len(res.Positions))
+	}
+	long, short := res.Positions[0], res.Positions[1]
+	if long.Direction != trading.Long || long.State != trading.Closed || !almostEq(long.AvgExit, 12) {
+		t.Errorf("expected long closed at 12, got %s", long)
+	}
+	// short from 12 takes profit at 8
+	if short.Direction != trading.Short || short.State != trading.Closed ||
+		!almostEq(short.AvgEntry, 12) || !almostEq(short.AvgExit, 8) {
+		t.Errorf("expected 12 -> 8 short, got %s", short)
+	}
+
+	// reversing at next open, without shorts
+	e = newEngine()
+	e.Reverse, e.Shorts = true, false
+	e.Execution.NextOpen = true
+	if res, err = e.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected only the long, got %d positions", len(res.Positions))
+	}
+	if p := res.Positions[0]; p.State != trading.Closed || !almostEq(p.AvgEntry, 12) || !almostEq(p.AvgExit, 8) {
+		t.Errorf("expected 12 -> 8 long closed at open after the sell signal, got %s", p)
+	}
+}
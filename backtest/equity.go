@@ -0,0 +1,89 @@
+package backtest
+
+import (
+	"github.com/montanaflynn/stats"
+	"time"
+)
+
+// EquityPoint is the mark-to-market state of a backtest at a given time.
+// Equity values open positions as if they were closed at Time, Cash
+// is the part of capital not engaged in positions.
+type EquityPoint struct {
+	Time   time.Time
+	Equity float64
+	Cash   float64
+}
+
+type (
+	// Equity is a per-tick equity curve, it implements plotter.XYer on Equity values.
+	Equity []EquityPoint
+	// Cash implements plotter.XYer on Cash values of an Equity curve.
+	Cash Equity
+)
+
+// Add appends a point to e, unless t is before the last recorded point.
+// A point at the same time replaces the last one, e.g. once every market
+// of a portfolio got its tick.
+func (e *Equity) Add(t time.Time, equity, cash float64) {
+	if sz := len(*e); sz > 0 && !t.After((*e)[sz-1].Time) {
+		if t.Equal((*e)[sz-1].Time) {
+			(*e)[sz-1] = EquityPoint{t, equity, cash}
+		}
+		return
+	}
+	*e = append(*e, EquityPoint{t, equity, cash})
+}
+
+func (e Equity) Values() (val []float64) {
+	val = make([]float64, len(e))
+	for i, v := range e {
+		val[i] = v.Equity
+	}
+	return val
+}
+
+func (e Equity) XY(i int) (float64, float64) {
+	return float64(e[i].Time.Unix()), e[i].Equity
+}
+
+func (e Equity) Len() int { return len(e) }
+
+func (e Equity) Range() (float64, float64, float64, float64) {
+	if sz := len(e); sz > 0 {
+		y0, _ := stats.Min(e.Values())
+		yN, _ := stats.Max(e.Values())
+		return float64(e[0].Time.Unix()), float64(e[sz-1].Time.Unix()), y0, yN
+	}
+	return 0, 0, 0, 0
+}
+
+func (c Cash) Values() (val []float64) {
+	val = make([]float64, len(c))
+	for i, v := range c {
+		val[i] = v.Cash
+	}
+	return val
+}
+
+func (c Cash) XY(i int) (float64, float64) {
+	return float64(c[i].Time.Unix()), c[i].Cash
+}
+
+func (c Cash) Len() int { return len(c) }
+
+func (c Cash) Range() (float64, float64, float64, float64) {
+	if sz := len(c); sz > 0 {
+		y0, _ := stats.Min(c.Values())
+		yN, _ := stats.Max(c.Values())
+		return float64(c[0].Time.Unix()), float64(c[sz-1].Time.Unix()), y0, yN
+	}
+	return 0, 0, 0, 0
+}
+
+func (e Equity) points() []equityPoint {
+	pts := make([]equityPoint, len(e))
+	for i, v := range e {
+		pts[i] = equityPoint{v.Time, v.Equity}
+	}
+	return pts
+}
@@ -0,0 +1,134 @@
+package backtest
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"time"
+)
+
+// Intrabar selects how take profit & stop loss levels are checked against a bar.
+type Intrabar string
+
+const (
+	// OnClose checks levels against the bar close and fills at close.
+	OnClose = Intrabar("close")
+	// Pessimistic detects touches on bar High/Low and fills at the level.
+	// When both levels are touched by the same bar, stop loss is assumed first.
+	Pessimistic = Intrabar("pessimistic")
+	// Optimistic is like Pessimistic but take profit is assumed first.
+	Optimistic = Intrabar("optimistic")
+	// LowerTimeframe is like Pessimistic but ambiguous bars are replayed on
+	// lower timeframe data to find which level was touched first.
+	LowerTimeframe = Intrabar("lowertf")
+)
+
+var Intrabars = []Intrabar{OnClose, Pessimistic, Optimistic, LowerTimeframe}
+
+func ParseIntrabar(s string) (Intrabar, error) {
+	if s == "" {
+		return OnClose, nil
+	}
+	for _, v := range Intrabars {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid intrabar rule \"%s\", expected one of %v", s, Intrabars)
+}
+
+// Execution is the execution model used by Engine to fill orders.
+type Execution struct {
+	Intrabar Intrabar
+	// Lower is the timeframe of data used by LowerTimeframe rule.
+	Lower ts.Timeframe
+
+	// NextOpen defers market orders decided on a bar close to the next bar open.
+	NextOpen bool
+	// SlippageBps, Spread & Impact configure trading.PaperTrading slippage models,
+	// see trading.FixedBps, trading.HalfSpread & trading.VolumeParticipation.
+	SlippageBps float64
+	Spread      float64
+	Impact      float64
+}
+
+func (ex Execution) IsIntrabar() bool {
+	return ex.Intrabar != "" && ex.Intrabar != OnClose
+}
+
+// Slippage returns the paper slippage model of ex, nil if none is set.
+func (ex Execution) Slippage() trading.Slippage {
+	var ss trading.Slippages
+	if ex.Spread != 0 {
+		ss = append(ss, trading.HalfSpread(ex.Spread))
+	}
+	if ex.SlippageBps != 0 {
+		ss = append(ss, trading.FixedBps(ex.SlippageBps))
+	}
+	if ex.Impact != 0 {
+		ss = append(ss, trading.VolumeParticipation(ex.Impact))
+	}
+	if len(ss) == 0 {
+		return nil
+	}
+	return ss
+}
+
+func (ex Execution) String() string {
+	s := string(ex.Intrabar)
+	if ex.Intrabar == "" {
+		s = string(OnClose)
+	} else if ex.Intrabar == LowerTimeframe {
+		s = fmt.Sprintf("%s(%s)", ex.Intrabar, ex.Lower)
+	}
+	if ex.NextOpen {
+		s += " next-open"
+	}
+	if ex.SlippageBps != 0 || ex.Spread != 0 || ex.Impact != 0 {
+		s += fmt.Sprintf(" slip(%.0fbps, spread %.2f%%, impact %.2f%%)",
+			ex.SlippageBps, 100*ex.Spread, 100*ex.Impact)
+	}
+	return s
+}
+
+// ExitPrice returns the price at which a position in direction dir, with tp & sl
+// levels, exits during bar o of duration tf. Bars opening beyond a level fill at Open.
+// lower is only used by LowerTimeframe rule, to resolve bars touching both levels.
+func (ex Execution) ExitPrice(dir trading.Direction, tp, sl float64,
+	o ts.OHLCV, tf time.Duration, lower ts.OHLCVs) (price float64, ok bool) {
+	// favorable & adverse moves of bar, relative to direction
+	hitTP, hitSL := o.High >= tp, o.Low <= sl
+	gapTP, gapSL := o.Open >= tp, o.Open <= sl
+	if dir == trading.Short {
+		hitTP, hitSL = o.Low <= tp, o.High >= sl
+		gapTP, gapSL = o.Open <= tp, o.Open >= sl
+	}
+
+	switch {
+	case gapTP || gapSL:
+		return o.Open, true
+	case hitTP && hitSL:
+		switch ex.Intrabar {
+		case Optimistic:
+			return tp, true
+		case LowerTimeframe:
+			t0 := o.Timestamp.T()
+			for _, lo := range lower {
+				if t := lo.Timestamp.T(); t.Before(t0) || !t.Before(t0.Add(tf)) {
+					continue
+				}
+				// lower bars resolving ambiguity themselves are pessimistic
+				price, ok := Execution{Intrabar: Pessimistic}.ExitPrice(dir, tp, sl, lo, 0, nil)
+				if ok {
+					return price, true
+				}
+			}
+		}
+		return sl, true
+	case hitTP:
+		return tp, true
+	case hitSL:
+		return sl, true
+	}
+	return 0, false
+}
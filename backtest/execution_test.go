@@ -0,0 +1,79 @@
+package backtest
+
+import (
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"testing"
+	"time"
+)
+
+func bar(t time.Time, o, h, l, c float64) ts.OHLCV {
+	return ts.OHLCV{Timestamp: util.JSONTime(t), Open: o, High: h, Low: l, Close: c, Volume: 1}
+}
+
+func TestExecution_ExitPrice(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	both := bar(t0, 100, 111, 94, 100)
+	lower := ts.OHLCVs{
+		bar(t0.Add(-time.Hour), 100, 120, 80, 100), // before bar, ignored
+		bar(t0, 100, 104, 99, 103),
+		bar(t0.Add(time.Hour), 103, 112, 102, 110), // tp first
+		bar(t0.Add(time.Hour*2), 110, 110, 90, 95),
+	}
+
+	for _, tc := range []struct {
+		name  string
+		ex    Execution
+		dir   trading.Direction
+		o     ts.OHLCV
+		price float64
+		ok    bool
+	}{
+		{"no touch", Execution{Intrabar: Pessimistic}, trading.Long, bar(t0, 100, 105, 96, 101), 0, false},
+		{"tp touch", Execution{Intrabar: Pessimistic}, trading.Long, bar(t0, 100, 115, 96, 101), 110, true},
+		{"sl wick", Execution{Intrabar: Pessimistic}, trading.Long, bar(t0, 100, 105, 90, 101), 95, true},
+		{"sl gap", Execution{Intrabar: Pessimistic}, trading.Long, bar(t0, 92, 105, 90, 101), 92, true},
+		{"tp gap", Execution{Intrabar: Pessimistic}, trading.Long, bar(t0, 112, 115, 111, 113), 112, true},
+		{"both pessimistic", Execution{Intrabar: Pessimistic}, trading.Long, both, 95, true},
+		{"both optimistic", Execution{Intrabar: Optimistic}, trading.Long, both, 110, true},
+		{"both lower tf", Execution{Intrabar: LowerTimeframe}, trading.Long, both, 110, true},
+		{"short sl wick", Execution{Intrabar: Pessimistic}, trading.Short, bar(t0, 100, 106, 96, 101), 105, true},
+		{"short tp touch", Execution{Intrabar: Pessimistic}, trading.Short, bar(t0, 100, 101, 85, 99), 90, true},
+	} {
+		tp, sl := 110.0, 95.0
+		if tc.dir == trading.Short {
+			tp, sl = 90, 105
+		}
+		price, ok := tc.ex.ExitPrice(tc.dir, tp, sl, tc.o, time.Hour*24, lower)
+		if ok != tc.ok || price != tc.price {
+			t.Errorf("%s: expected (%f, %v), got (%f, %v)", tc.name, tc.price, tc.ok, price, ok)
+		}
+	}
+}
+
+func TestEngine_RunIntrabar(t *testing.T) {
+	h := testHistorical(10, 10, 10, 10)
+	// wick through stop loss without closing below it
+	h.Data[2].Low = 9
+	e := Engine{
+		Source:    h,
+		Strategy:  &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:    &trading.PaperTrading{},
+		Profile:   trading.Profile{TakeProfit: 0.1, StopLoss: 0.05},
+		Execution: Execution{Intrabar: Pessimistic},
+		Base:      "ABC",
+		Quote:     "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 || res.Positions[0].State != trading.Closed {
+		t.Fatalf("expected one closed position")
+	}
+	if p := res.Positions[0]; !almostEq(p.AvgExit, 9.5) || !p.CloseTime.Equal(h.Data[2].Timestamp.T()) {
+		t.Errorf("expected stop fill at 9.5 on 3rd bar, got %s", p)
+	}
+}
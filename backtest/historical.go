@@ -31,9 +31,10 @@ func (h Historical) Feed() <-chan trading.Tick {
 	go func() {
 		for _, ohlcv := range h.Data {
 			ch <- trading.Tick{
-				Timeframe: h.Timeframe, OHLCV: ohlcv,
+				Timeframe: h.Timeframe, Base: h.Base, Quote: h.Quote, OHLCV: ohlcv,
 			}
 		}
+		close(ch)
 	}()
 	return ch
 }
@@ -141,12 +142,12 @@ func (h HistoricalPair) Feed() <-chan trading.Tick {
 		}
 		for _, fast := range h.Fast.Data {
 			ch <- trading.Tick{
-				Timeframe: h.Fast.Timeframe, OHLCV: fast,
+				Timeframe: h.Fast.Timeframe, Base: h.Fast.Base, Quote: h.Fast.Quote, OHLCV: fast,
 			}
 
 			if nextSlow != nil && fast.Timestamp.T().After(nextSlow.Timestamp.T()) {
 				ch <- trading.Tick{
-					Timeframe: h.Slow.Timeframe, OHLCV: *nextSlow,
+					Timeframe: h.Slow.Timeframe, Base: h.Slow.Base, Quote: h.Slow.Quote, OHLCV: *nextSlow,
 				}
 				if len(h.Slow.Data) > j+1 {
 					j = j + 1
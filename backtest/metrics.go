@@ -0,0 +1,258 @@
+package backtest
+
+import (
+	"fmt"
+	"github.com/montanaflynn/stats"
+	"github.com/rkjdid/gocx/trading"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	day = time.Hour * 24
+	// crypto markets never close, returns are annualized over 365 days.
+	daysPerYear = 365
+)
+
+// Metrics holds performance statistics of a backtest, computed by Result.UpdateScore.
+// Ratios are expressed as fractions, e.g. MaxDrawdown = 0.2 is a 20% drawdown.
+type Metrics struct {
+	Sharpe              float64
+	Sortino             float64
+	MaxDrawdown         float64
+	MaxDrawdownDuration time.Duration
+	Calmar              float64
+	// ProfitFactor is gross profit over gross loss, 0 if there is no losing
+	// position, which the profitfactor metric ranks as infinite when there are
+	// profits.
+	ProfitFactor float64
+	// Expectancy is the average net per position.
+	Expectancy float64
+	AvgHolding time.Duration
+	// Exposure is the fraction of time spent with an open position, positions
+	// overlapping in time are counted once.
+	Exposure float64
+	CAGR     float64
+}
+
+// Metric describes a sortable Metrics field.
+type Metric struct {
+	Name          string
+	Value         func(Metrics) float64
+	LowerIsBetter bool
+}
+
+var MetricsList = []Metric{
+	{"sharpe", func(m Metrics) float64 { return m.Sharpe }, false},
+	{"sortino", func(m Metrics) float64 { return m.Sortino }, false},
+	{"mdd", func(m Metrics) float64 { return m.MaxDrawdown }, true},
+	{"mddduration", func(m Metrics) float64 { return float64(m.MaxDrawdownDuration) }, true},
+	{"calmar", func(m Metrics) float64 { return m.Calmar }, false},
+	{"profitfactor", profitFactor, false},
+	{"expectancy", func(m Metrics) float64 { return m.Expectancy }, false},
+	{"holding", func(m Metrics) float64 { return float64(m.AvgHolding) }, false},
+	{"exposure", func(m Metrics) float64 { return m.Exposure }, false},
+	{"cagr", func(m Metrics) float64 { return m.CAGR }, false},
+}
+
+// profitFactor is ProfitFactor, infinite with profits and no loss, which
+// is 0 to keep metrics encodable as JSON.
+func profitFactor(m Metrics) float64 {
+	if m.ProfitFactor == 0 && m.Expectancy > 0 {
+		return math.Inf(1)
+	}
+	return m.ProfitFactor
+}
+
+func MetricByName(name string) (Metric, error) {
+	var names []string
+	for _, m := range MetricsList {
+		if m.Name == name {
+			return m, nil
+		}
+		names = append(names, m.Name)
+	}
+	return Metric{}, fmt.Errorf("unknown metric \"%s\", expected one of %v", name, names)
+}
+
+// Better returns true if a scores better than b on m.
+func (m Metric) Better(a, b Metrics) bool {
+	if m.LowerIsBetter {
+		return m.Value(a) < m.Value(b)
+	}
+	return m.Value(a) > m.Value(b)
+}
+
+func (m Metrics) String() string {
+	return fmt.Sprintf("sharpe: %.2f, sortino: %.2f, mdd: %.1f%% (%s), calmar: %.2f, "+
+		"pf: %.2f, exp: %.4f, hold: %s, exposure: %.1f%%, cagr: %.1f%%",
+		m.Sharpe, m.Sortino, 100*m.MaxDrawdown, fmtDays(m.MaxDrawdownDuration), m.Calmar,
+		m.ProfitFactor, m.Expectancy, fmtDays(m.AvgHolding), 100*m.Exposure, 100*m.CAGR)
+}
+
+func fmtDays(d time.Duration) string {
+	return fmt.Sprintf("%.1fd", d.Hours()/24)
+}
+
+// equityPoint is the equity value at a given time.
+type equityPoint struct {
+	t time.Time
+	v float64
+}
+
+// closedEquity returns the equity curve made of r capital updated
+// at each position close.
+func (r *Result) closedEquity() []equityPoint {
+	curve := []equityPoint{{r.From, r.capital()}}
+	var closed []equityPoint
+	for _, p := range r.Positions {
+		if p.CloseTime.IsZero() {
+			continue
+		}
+		closed = append(closed, equityPoint{p.CloseTime, p.Net()})
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		return closed[i].t.Before(closed[j].t)
+	})
+	k := r.capital()
+	for _, c := range closed {
+		k += c.v
+		curve = append(curve, equityPoint{c.t, k})
+	}
+	return curve
+}
+
+// sampleDaily returns last known value of curve for each day from curve start to r.To.
+func (r *Result) sampleDaily(curve []equityPoint) []float64 {
+	var daily []float64
+	j := 0
+	for t := curve[0].t; !t.After(r.To); t = t.Add(day) {
+		for j+1 < len(curve) && !curve[j+1].t.After(t) {
+			j++
+		}
+		daily = append(daily, curve[j].v)
+	}
+	return daily
+}
+
+func (r *Result) updateMetrics() {
+	var m Metrics
+	// prefer mark-to-market curve when recorded
+	curve := r.Equity.points()
+	if len(curve) == 0 {
+		curve = r.closedEquity()
+	}
+	k0, kN := r.capital(), curve[len(curve)-1].v
+	nbDays := r.To.Sub(r.From).Hours() / 24
+
+	// daily returns
+	daily := r.sampleDaily(curve)
+	var returns, downside []float64
+	for i := 1; i < len(daily); i++ {
+		if daily[i-1] == 0 {
+			continue
+		}
+		ret := daily[i]/daily[i-1] - 1
+		returns = append(returns, ret)
+		downside = append(downside, math.Min(ret, 0))
+	}
+	if len(returns) > 1 {
+		mean, _ := stats.Mean(returns)
+		if std, _ := stats.StandardDeviationSample(returns); std > 0 {
+			m.Sharpe = mean / std * math.Sqrt(daysPerYear)
+		}
+		var sumSq float64
+		for _, d := range downside {
+			sumSq += d * d
+		}
+		if dd := math.Sqrt(sumSq / float64(len(downside))); dd > 0 {
+			m.Sortino = mean / dd * math.Sqrt(daysPerYear)
+		}
+	}
+
+	// drawdown
+	peak, peakTime := curve[0].v, curve[0].t
+	underwater := false
+	for _, pt := range curve {
+		if pt.v >= peak {
+			// recovered, duration is from previous peak to now
+			if d := pt.t.Sub(peakTime); underwater && d > m.MaxDrawdownDuration {
+				m.MaxDrawdownDuration = d
+			}
+			peak, peakTime, underwater = pt.v, pt.t, false
+			continue
+		}
+		underwater = true
+		if dd := 1 - pt.v/peak; dd > m.MaxDrawdown {
+			m.MaxDrawdown = dd
+		}
+	}
+	// still under water at the end
+	if underwater {
+		if d := r.To.Sub(peakTime); d > m.MaxDrawdownDuration {
+			m.MaxDrawdownDuration = d
+		}
+	}
+
+	if nbDays > 0 && k0 > 0 && kN > 0 {
+		m.CAGR = math.Pow(kN/k0, daysPerYear/nbDays) - 1
+	}
+	if m.MaxDrawdown > 0 {
+		m.Calmar = m.CAGR / m.MaxDrawdown
+	}
+
+	// positions
+	var grossProfit, grossLoss, sumNet float64
+	var holding time.Duration
+	var nbClosed int
+	for _, p := range r.Positions {
+		if p.CloseTime.IsZero() {
+			continue
+		}
+		nbClosed++
+		net := p.Net()
+		sumNet += net
+		if net > 0 {
+			grossProfit += net
+		} else {
+			grossLoss -= net
+		}
+		holding += p.CloseTime.Sub(p.OpenTime)
+	}
+	if grossLoss > 0 {
+		m.ProfitFactor = grossProfit / grossLoss
+	}
+	if nbClosed > 0 {
+		m.Expectancy = sumNet / float64(nbClosed)
+		m.AvgHolding = holding / time.Duration(nbClosed)
+	}
+	if total := r.To.Sub(r.From); total > 0 {
+		m.Exposure = float64(exposed(r.Positions)) / float64(total)
+	}
+	r.Metrics = m
+}
+
+// exposed returns the time spent with at least one of closed positions open.
+func exposed(positions []*trading.Position) time.Duration {
+	var closed []*trading.Position
+	for _, p := range positions {
+		if !p.CloseTime.IsZero() {
+			closed = append(closed, p)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		return closed[i].OpenTime.Before(closed[j].OpenTime)
+	})
+	var total time.Duration
+	var from, to time.Time
+	for _, p := range closed {
+		if p.OpenTime.After(to) {
+			total += to.Sub(from)
+			from, to = p.OpenTime, p.CloseTime
+		} else if p.CloseTime.After(to) {
+			to = p.CloseTime
+		}
+	}
+	return total + to.Sub(from)
+}
@@ -0,0 +1,95 @@
+package backtest
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"testing"
+	"time"
+)
+
+func testPosition(open, close time.Time, entry, exit float64) *trading.Position {
+	return &trading.Position{
+		State: trading.Closed, Direction: trading.Long,
+		Total: 1, Traded: 1, AvgEntry: entry, AvgExit: exit,
+		OpenTime: open, CloseTime: close,
+	}
+}
+
+func TestResult_UpdateScore_Metrics(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := func(n int) time.Time { return t0.Add(day * time.Duration(n)) }
+	r := Result{
+		From: t0, To: d(10),
+		Positions: []*trading.Position{
+			testPosition(d(1), d(2), 1, 1.5), // +0.5 -> 1.5
+			testPosition(d(3), d(5), 1, 0.7), // -0.3 -> 1.2
+			testPosition(d(6), d(8), 1, 1.4), // +0.4 -> 1.6
+		},
+	}
+	r.UpdateScore()
+
+	if !almostEq(r.Score, 0.6) {
+		t.Errorf("expected score 0.6, got %f", r.Score)
+	}
+	if !almostEq(r.MaxDrawdown, 0.2) {
+		t.Errorf("expected max drawdown 0.2, got %f", r.MaxDrawdown)
+	}
+	if r.MaxDrawdownDuration != day*6 {
+		t.Errorf("expected drawdown duration 6d, got %s", r.MaxDrawdownDuration)
+	}
+	if !almostEq(r.ProfitFactor, 3) {
+		t.Errorf("expected profit factor 3, got %f", r.ProfitFactor)
+	}
+	if !almostEq(r.Expectancy, 0.2) {
+		t.Errorf("expected expectancy 0.2, got %f", r.Expectancy)
+	}
+	if r.AvgHolding != time.Duration(5*float64(day)/3) {
+		t.Errorf("unexpected average holding %s", r.AvgHolding)
+	}
+	if !almostEq(r.Exposure, 0.5) {
+		t.Errorf("expected exposure 0.5, got %f", r.Exposure)
+	}
+	if r.Sharpe <= 0 || r.Sortino <= 0 || r.CAGR <= 0 || r.Calmar <= 0 {
+		t.Errorf("expected positive sharpe, sortino, cagr & calmar: %s", r.Metrics)
+	}
+}
+
+func TestMetric_Better_ProfitFactor(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := func(n int) time.Time { return t0.Add(day * time.Duration(n)) }
+	results := make([]Result, 3)
+	for i, exits := range [][]float64{{1.5, 0.7}, {1.2, 1.1}, {0.9}} {
+		results[i] = Result{From: t0, To: d(10)}
+		for j, exit := range exits {
+			results[i].Positions = append(results[i].Positions, testPosition(d(2*j), d(2*j+1), 1, exit))
+		}
+		results[i].UpdateScore()
+	}
+	pf, err := MetricByName("profitfactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no losing position ranks first, no winning one last
+	if !pf.Better(results[1].Metrics, results[0].Metrics) || !pf.Better(results[0].Metrics, results[2].Metrics) {
+		t.Errorf("unexpected profit factor ranking: %f, %f, %f",
+			results[0].ProfitFactor, results[1].ProfitFactor, results[2].ProfitFactor)
+	}
+}
+
+func TestResult_UpdateScore_ExposureOverlap(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := func(n int) time.Time { return t0.Add(day * time.Duration(n)) }
+	r := Result{
+		From: t0, To: d(10),
+		Positions: []*trading.Position{
+			testPosition(d(1), d(4), 1, 1.1),
+			testPosition(d(2), d(3), 1, 1.1), // within the first
+			testPosition(d(3), d(5), 1, 1.1), // overlaps the first
+			testPosition(d(7), d(8), 1, 1.1),
+		},
+	}
+	r.UpdateScore()
+	// open from day 1 to 5, then 7 to 8
+	if !almostEq(r.Exposure, 0.5) {
+		t.Errorf("expected exposure 0.5, got %f", r.Exposure)
+	}
+}
@@ -0,0 +1,100 @@
+package backtest
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"time"
+)
+
+// Market is a base/quote pair traded by its own Strategy in a portfolio Engine.
+type Market struct {
+	Base, Quote string
+	Strategy    strategy.Strategy
+	// Lower holds lower timeframe data for the LowerTimeframe rule.
+	Lower ts.OHLCVs
+}
+
+// Allocation sets how portfolio capital is split between positions.
+// Zero values disable a rule.
+type Allocation struct {
+	// EqualWeight sizes positions to an equal share of equity, one per market,
+	// or per MaxPositions if lower. Otherwise positions use all free funds.
+	EqualWeight bool
+	// MaxPositions is the number of positions open at the same time.
+	MaxPositions int
+	// MaxPerAsset caps a position to a fraction of equity.
+	MaxPerAsset float64
+}
+
+// Funds returns the quote amount of a new position, given portfolio equity,
+// free funds, the number of markets & of positions already open.
+func (a Allocation) Funds(equity, free float64, markets, open int) float64 {
+	if a.MaxPositions > 0 && open >= a.MaxPositions {
+		return 0
+	}
+	funds := free
+	if a.EqualWeight {
+		slots := markets
+		if a.MaxPositions > 0 && a.MaxPositions < slots {
+			slots = a.MaxPositions
+		}
+		funds = equity / float64(slots)
+	}
+	if a.MaxPerAsset > 0 {
+		funds = math.Min(funds, a.MaxPerAsset*equity)
+	}
+	return math.Max(0, math.Min(funds, free))
+}
+
+// Merged feeds ticks of several sources in timestamp order, ticks
+// of a source keep their relative order.
+type Merged []trading.DataSource
+
+func (m Merged) Feed() <-chan trading.Tick {
+	ch := make(chan trading.Tick)
+	go func() {
+		feeds := make([]<-chan trading.Tick, len(m))
+		heads := make([]*trading.Tick, len(m))
+		next := func(i int) {
+			heads[i] = nil
+			if x, ok := <-feeds[i]; ok {
+				heads[i] = &x
+			}
+		}
+		for i, s := range m {
+			feeds[i] = s.Feed()
+			next(i)
+		}
+		for {
+			first := -1
+			for i, x := range heads {
+				if x != nil && (first < 0 || x.Timestamp.T().Before(heads[first].Timestamp.T())) {
+					first = i
+				}
+			}
+			if first < 0 {
+				break
+			}
+			ch <- *heads[first]
+			next(first)
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+// Bondaries spans boundaries of all m sources.
+func (m Merged) Bondaries() (from, to time.Time) {
+	for _, s := range m {
+		f, t := s.Bondaries()
+		if from.IsZero() || f.Before(from) {
+			from = f
+		}
+		if t.After(to) {
+			to = t
+		}
+	}
+	return from, to
+}
@@ -0,0 +1,88 @@
+package backtest
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"testing"
+)
+
+func TestAllocation_Funds(t *testing.T) {
+	for _, test := range []struct {
+		a             Allocation
+		equity, free  float64
+		markets, open int
+		expected      float64
+	}{
+		{Allocation{}, 2, 1, 4, 1, 1},
+		{Allocation{EqualWeight: true}, 2, 1, 4, 1, 0.5},
+		{Allocation{EqualWeight: true, MaxPositions: 2}, 2, 1.5, 4, 1, 1},
+		{Allocation{EqualWeight: true, MaxPositions: 2}, 2, 1, 4, 2, 0},
+		{Allocation{MaxPerAsset: 0.25}, 2, 1, 4, 1, 0.5},
+		{Allocation{EqualWeight: true}, 2, 0.2, 4, 3, 0.2},
+	} {
+		if f := test.a.Funds(test.equity, test.free, test.markets, test.open); !almostEq(f, test.expected) {
+			t.Errorf("%+v: expected %f, got %f", test, test.expected, f)
+		}
+	}
+}
+
+func TestMerged_Feed(t *testing.T) {
+	h1, h2 := testHistorical(1, 2, 3), testHistorical(10, 20)
+	h2.Base = "DEF"
+	h2.Data = h2.Data[1:]
+	var bases []string
+	for x := range (Merged{h1, h2}).Feed() {
+		bases = append(bases, x.Base)
+	}
+	if len(bases) != 4 || bases[0] != "ABC" || bases[1] != "ABC" || bases[2] != "DEF" || bases[3] != "ABC" {
+		t.Errorf("expected ticks in timestamp order, got %v", bases)
+	}
+}
+
+func TestEngine_RunPortfolio(t *testing.T) {
+	abc, def := testHistorical(10, 10, 12), testHistorical(20, 20, 20)
+	def.Base = "DEF"
+	buy := func() strategy.Strategy {
+		return &scripted{actions: map[int]strategy.Action{0: strategy.Buy}}
+	}
+	e := Engine{
+		Source: Merged{abc, def},
+		Markets: []Market{
+			{Base: "ABC", Quote: "BTC", Strategy: buy()},
+			{Base: "DEF", Quote: "BTC", Strategy: buy()},
+		},
+		Allocation: Allocation{EqualWeight: true},
+		Broker:     &trading.PaperTrading{},
+		Profile:    trading.Profile{TakeProfit: 1, StopLoss: 1},
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 2 {
+		t.Fatalf("expected a position per market, got %d", len(res.Positions))
+	}
+	for _, p := range res.Positions {
+		if !almostEq(p.Cost(), 0.5) {
+			t.Errorf("expected half of capital on %s%s, got %f", p.Base, p.Quote, p.Cost())
+		}
+	}
+	if len(res.Equity) != 3 {
+		t.Fatalf("expected one equity point per timestamp, got %d", len(res.Equity))
+	}
+	// abc +20% on half of capital
+	if pt := res.Equity[2]; !almostEq(pt.Equity, 1.1) || !almostEq(pt.Cash, 0) {
+		t.Errorf("unexpected portfolio equity: %+v", pt)
+	}
+
+	e.Allocation = Allocation{MaxPositions: 1}
+	e.Markets[0].Strategy, e.Markets[1].Strategy = buy(), buy()
+	if res, _ = e.Run(); len(res.Positions) != 1 || res.Positions[0].Base != "ABC" {
+		t.Errorf("expected max positions to limit to 1 position")
+	}
+
+	e.Markets[1].Quote = "ETH"
+	if _, err = e.Run(); err == nil {
+		t.Errorf("expected markets of different quotes to fail")
+	}
+}
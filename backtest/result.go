@@ -8,9 +8,12 @@ import (
 
 type Result struct {
 	Positions []*trading.Position
+	Equity    Equity
 	From, To  time.Time
+	Capital   float64
 	Score     float64
 	Z         float64
+	Metrics
 }
 
 // ZScore implements db.ZScorer. It returns r.Score / day.
@@ -30,9 +33,17 @@ func (r *Result) UpdateScore() {
 	}
 	nbDays := r.To.Sub(r.From).Hours() / 24
 	r.Z = r.Score / nbDays
+	r.updateMetrics()
 	return
 }
 
+func (r *Result) capital() float64 {
+	if r.Capital == 0 {
+		return DefaultCapital
+	}
+	return r.Capital
+}
+
 func (r Result) String() string {
 	var wins, loses int
 	for _, p := range r.Positions {
@@ -42,5 +53,14 @@ func (r Result) String() string {
 			wins++
 		}
 	}
-	return fmt.Sprintf("zscore: %5.3f, total: %.1f%%, +pos: %2d, -pos: %2d", 100*r.ZScore(), 100*r.Score, wins, loses)
+	return fmt.Sprintf("zscore: %5.3f, total: %.1f%%, +pos: %2d, -pos: %2d, sharpe: %.2f, mdd: %.1f%%",
+		100*r.ZScore(), 100*r.Score, wins, loses, r.Sharpe, 100*r.MaxDrawdown)
+}
+
+func (r Result) Details() string {
+	s := ""
+	for _, p := range r.Positions {
+		s += fmt.Sprintln(p)
+	}
+	return s + fmt.Sprintln(r) + fmt.Sprintln(r.Metrics)
 }
@@ -0,0 +1,69 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/backtest/scraper"
+	"github.com/rkjdid/gocx/trading"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	FuturesAPI      = "https://fapi.binance.com"
+	FundingEndpoint = "/fapi/v1/fundingRate"
+
+	// fundingLimit is the max number of rates per request.
+	fundingLimit = 1000
+)
+
+type FundingRate struct {
+	Symbol      string `json:"symbol"`
+	FundingTime int64  `json:"fundingTime"`
+	FundingRate string `json:"fundingRate"`
+	MarkPrice   string `json:"markPrice"`
+}
+
+// FetchFundingRates returns funding rates history of perpetual sym between from & to.
+func FetchFundingRates(sym string, from, to time.Time) (trading.FundingRates, error) {
+	client := scraper.CacheClient(time.Hour * 12)
+	var rates trading.FundingRates
+	for {
+		q := url.Values{}
+		q.Set("symbol", sym)
+		q.Set("startTime", fmt.Sprint(from.UnixNano()/int64(time.Millisecond)))
+		q.Set("endTime", fmt.Sprint(to.UnixNano()/int64(time.Millisecond)))
+		q.Set("limit", fmt.Sprint(fundingLimit))
+		u := FuturesAPI + FundingEndpoint + "?" + q.Encode()
+		if scraper.Debug {
+			log.Printf("GET %s", u)
+		}
+		resp, err := client.Get(u)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't retreive http data: %s", err)
+		}
+		var page []FundingRate
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode body (%d): %s", resp.StatusCode, err)
+		}
+		for _, v := range page {
+			fr := trading.FundingRate{
+				Time: time.Unix(0, v.FundingTime*int64(time.Millisecond)).UTC(),
+			}
+			if fr.Rate, err = strconv.ParseFloat(v.FundingRate, 64); err != nil {
+				return nil, fmt.Errorf("funding rate %s: %s", v.FundingRate, err)
+			}
+			// mark price is missing from old records
+			fr.MarkPrice, _ = strconv.ParseFloat(v.MarkPrice, 64)
+			rates = append(rates, fr)
+		}
+		if len(page) < fundingLimit {
+			return rates, nil
+		}
+		from = rates[len(rates)-1].Time.Add(time.Millisecond)
+	}
+}
@@ -0,0 +1,109 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/backtest/scraper"
+	"github.com/rkjdid/gocx/ts"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SpotAPI        = "https://api.binance.com"
+	KlinesEndpoint = "/api/v3/klines"
+
+	// KlinesLimit is the max number of klines per request.
+	KlinesLimit = 1000
+)
+
+// Kline is a bar of binance klines, OHLCV Timestamp is its open time.
+type Kline struct {
+	ts.OHLCV
+	CloseTime time.Time
+}
+
+// Interval returns the binance kline interval of tf, e.g. 15m, 4h or 1d.
+func Interval(tf ts.Timeframe) string {
+	if tf.Unit == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d%s", tf.N, tf.Unit[:1])
+}
+
+// FetchKlines returns up to limit klines of sym on tf opened from from, requested
+// with client on api, SpotAPI if empty. The last kline may not be closed yet.
+func FetchKlines(client *http.Client, api, sym string, tf ts.Timeframe, from time.Time, limit int) ([]Kline, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if api == "" {
+		api = SpotAPI
+	}
+	q := url.Values{}
+	q.Set("symbol", sym)
+	q.Set("interval", Interval(tf))
+	q.Set("startTime", fmt.Sprint(from.UnixNano()/int64(time.Millisecond)))
+	q.Set("limit", fmt.Sprint(limit))
+	u := api + KlinesEndpoint + "?" + q.Encode()
+	if scraper.Debug {
+		log.Printf("GET %s", u)
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retreive http data: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("klines %s: http status %d", sym, resp.StatusCode)
+	}
+	// klines are arrays of open time, open, high, low, close, volume, close time...
+	var rows [][]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("couldn't decode body: %s", err)
+	}
+	klines := make([]Kline, 0, len(rows))
+	for _, row := range rows {
+		k, err := parseKline(row)
+		if err != nil {
+			return nil, fmt.Errorf("kline %v: %s", row, err)
+		}
+		klines = append(klines, k)
+	}
+	return klines, nil
+}
+
+func parseKline(row []interface{}) (Kline, error) {
+	var k Kline
+	if len(row) < 7 {
+		return k, fmt.Errorf("expected at least 7 fields")
+	}
+	var values [5]float64
+	for i := range values {
+		s, ok := row[i+1].(string)
+		if !ok {
+			return k, fmt.Errorf("field %d is not a string", i+1)
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return k, err
+		}
+		values[i] = v
+	}
+	open, ok := row[0].(float64)
+	if !ok {
+		return k, fmt.Errorf("invalid open time")
+	}
+	closeTime, ok := row[6].(float64)
+	if !ok {
+		return k, fmt.Errorf("invalid close time")
+	}
+	k.Timestamp = util.JSONTime(time.Unix(0, int64(open)*int64(time.Millisecond)).UTC())
+	k.Open, k.High, k.Low, k.Close, k.Volume = values[0], values[1], values[2], values[3], values[4]
+	k.CloseTime = time.Unix(0, int64(closeTime)*int64(time.Millisecond)).UTC()
+	return k, nil
+}
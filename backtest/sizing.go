@@ -0,0 +1,136 @@
+package backtest
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/trading"
+	"math"
+)
+
+// Sizing selects how Sizer sizes new positions.
+type Sizing string
+
+const (
+	// AllIn commits all funds available to a position.
+	AllIn = Sizing("all-in")
+	// FixedFraction commits Fraction of equity.
+	FixedFraction = Sizing("fraction")
+	// FixedRisk sizes positions so that hitting the stop loss loses Risk of equity.
+	FixedRisk = Sizing("risk")
+	// VolatilityTarget sizes positions so that a move of one ATR changes
+	// equity by Risk.
+	VolatilityTarget = Sizing("volatility")
+	// Kelly commits a fraction of the Kelly criterion computed from closed positions,
+	// positions are not opened while it is negative.
+	Kelly = Sizing("kelly")
+)
+
+var Sizings = []Sizing{AllIn, FixedFraction, FixedRisk, VolatilityTarget, Kelly}
+
+func ParseSizing(s string) (Sizing, error) {
+	if s == "" {
+		return AllIn, nil
+	}
+	for _, v := range Sizings {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid sizing \"%s\", expected one of %v", s, Sizings)
+}
+
+// DefaultKellyTrades is the number of closed positions Kelly sizing waits for
+// when Sizer.MinTrades is unset.
+const DefaultKellyTrades = 20
+
+// Sizer sizes new positions of Engine, within funds left by Allocation.
+type Sizer struct {
+	Policy Sizing
+	// Fraction of equity of FixedFraction, also used by Kelly until MinTrades
+	// positions are closed, DefaultKellyTrades if unset.
+	Fraction  float64
+	MinTrades int
+	// Risk is the fraction of equity risked by FixedRisk & VolatilityTarget.
+	Risk float64
+	// ATRPeriod of VolatilityTarget, DefaultATRPeriod if unset.
+	ATRPeriod int
+	// Kelly is the fraction of the Kelly criterion committed, e.g. 0.5 for half Kelly.
+	Kelly float64
+}
+
+// Funds returns the quote amount committed to a new position given equity, entry
+// price, stop loss as a fraction of price, market atr, leverage & closed positions.
+func (s Sizer) Funds(equity, price, stopLoss, atr, leverage float64, closed []*trading.Position) float64 {
+	var notional float64
+	switch s.Policy {
+	case FixedFraction:
+		return s.Fraction * equity
+	case FixedRisk:
+		if stopLoss <= 0 {
+			return 0
+		}
+		notional = s.Risk * equity / stopLoss
+	case VolatilityTarget:
+		if atr <= 0 {
+			// atr is not computed yet
+			return 0
+		}
+		notional = s.Risk * equity * price / atr
+	case Kelly:
+		min := s.MinTrades
+		if min <= 0 {
+			min = DefaultKellyTrades
+		}
+		if len(closed) < min {
+			return s.Fraction * equity
+		}
+		return s.Kelly * KellyCriterion(closed) * equity
+	default:
+		return equity
+	}
+	return notional / leverage
+}
+
+// KellyCriterion returns the fraction of capital maximizing growth given returns
+// of positions: W - (1-W)/R, with W the win rate and R the ratio of average
+// win to average loss. It is 0 when there is no edge, 1 without losses.
+func KellyCriterion(positions []*trading.Position) float64 {
+	var wins, losses int
+	var won, lost float64
+	for _, p := range positions {
+		cost := p.Cost()
+		if cost == 0 {
+			continue
+		}
+		r := p.Net() / cost
+		if r > 0 {
+			wins++
+			won += r
+		} else {
+			losses++
+			lost -= r
+		}
+	}
+	if wins == 0 {
+		return 0
+	}
+	if losses == 0 || lost == 0 {
+		return 1
+	}
+	w := float64(wins) / float64(wins+losses)
+	ratio := (won / float64(wins)) / (lost / float64(losses))
+	return math.Max(0, math.Min(1, w-(1-w)/ratio))
+}
+
+func (s Sizer) String() string {
+	switch s.Policy {
+	case FixedFraction:
+		return fmt.Sprintf("%s %.1f%%", s.Policy, s.Fraction*100)
+	case FixedRisk:
+		return fmt.Sprintf("%s %.1f%%", s.Policy, s.Risk*100)
+	case VolatilityTarget:
+		return fmt.Sprintf("%s %.1f%% atr(%d)", s.Policy, s.Risk*100, s.ATRPeriod)
+	case Kelly:
+		return fmt.Sprintf("%s x%.2f", s.Policy, s.Kelly)
+	}
+	return string(AllIn)
+}
@@ -0,0 +1,87 @@
+package backtest
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"testing"
+)
+
+func testClosed(returns ...float64) []*trading.Position {
+	var positions []*trading.Position
+	for _, r := range returns {
+		positions = append(positions, &trading.Position{
+			Direction: trading.Long, Total: 1, Traded: 1, AvgEntry: 10, AvgExit: 10 * (1 + r), State: trading.Closed,
+		})
+	}
+	return positions
+}
+
+func TestSizer_Funds(t *testing.T) {
+	kelly := Sizer{Policy: Kelly, Fraction: 0.1, Kelly: 0.5, MinTrades: 4}
+	for _, test := range []struct {
+		s               Sizer
+		price, atr, lev float64
+		closed          []*trading.Position
+		expected        float64
+	}{
+		{Sizer{}, 10, 0, 1, nil, 2},
+		{Sizer{Policy: FixedFraction, Fraction: 0.1}, 10, 0, 1, nil, 0.2},
+		// 10% stop loses 2% of equity
+		{Sizer{Policy: FixedRisk, Risk: 0.01}, 10, 0, 1, nil, 0.2},
+		{Sizer{Policy: FixedRisk, Risk: 0.01}, 10, 0, 2, nil, 0.1},
+		// 0.5 atr on a 10 price moves equity by 1%
+		{Sizer{Policy: VolatilityTarget, Risk: 0.01}, 10, 0.5, 1, nil, 0.4},
+		{Sizer{Policy: VolatilityTarget, Risk: 0.01}, 10, 0, 1, nil, 0},
+		{kelly, 10, 0, 1, testClosed(0.1, 0.1, -0.1), 0.2},
+		// kelly of 0.5, halved
+		{kelly, 10, 0, 1, testClosed(0.1, 0.1, 0.1, -0.1), 0.5},
+		{kelly, 10, 0, 1, testClosed(0.1, -0.1, -0.1, -0.1), 0},
+	} {
+		if f := test.s.Funds(2, test.price, 0.1, test.atr, test.lev, test.closed); !almostEq(f, test.expected) {
+			t.Errorf("%s: expected %f, got %f", test.s, test.expected, f)
+		}
+	}
+}
+
+func TestKellyCriterion(t *testing.T) {
+	for _, test := range []struct {
+		closed   []*trading.Position
+		expected float64
+	}{
+		{nil, 0},
+		{testClosed(0.1, 0.2), 1},
+		{testClosed(-0.1, -0.2), 0},
+		// W = 0.5, R = 2
+		{testClosed(0.2, -0.1), 0.25},
+	} {
+		if k := KellyCriterion(test.closed); !almostEq(k, test.expected) {
+			t.Errorf("expected kelly %f, got %f", test.expected, k)
+		}
+	}
+}
+
+func TestEngine_RunSizer(t *testing.T) {
+	e := Engine{
+		Source:   testHistorical(10, 10, 11, 12, 12),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   &trading.PaperTrading{},
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+		Sizer:    Sizer{Policy: FixedRisk, Risk: 0.02},
+		Base:     "ABC",
+		Quote:    "BTC",
+	}
+	res, err := e.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Positions) != 1 {
+		t.Fatalf("expected one position, got %d", len(res.Positions))
+	}
+	// 2% of capital lost at a 10% stop
+	if p := res.Positions[0]; !almostEq(p.Cost(), 0.2) || !almostEq(p.Net(), 0.04) {
+		t.Errorf("expected 0.2 position, got %s", p)
+	}
+	if pt := res.Equity[2]; !almostEq(pt.Equity, 1.02) || !almostEq(pt.Cash, 0.8) {
+		t.Errorf("unexpected equity point: %+v", pt)
+	}
+}
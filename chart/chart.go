@@ -21,11 +21,18 @@ var (
 )
 
 func init() {
+	Reset()
+}
+
+// Reset discards the current plot and everything added to it.
+func Reset() {
 	var err error
 	p, err = plot.New()
 	if err != nil {
 		panic(err)
 	}
+	plotters = nil
+	signals = nil
 }
 
 func Plot() *plot.Plot {
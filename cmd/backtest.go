@@ -3,16 +3,20 @@ package cmd
 import (
 	"fmt"
 	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/backtest/scraper/binance"
 	_db "github.com/rkjdid/gocx/db"
 	"github.com/rkjdid/gocx/trading"
 	"github.com/rkjdid/gocx/ts"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"log"
-	"strings"
+	"os"
 	"time"
 )
 
+// perpMaintenanceRate is binance lowest tier maintenance margin rate of perpetuals.
+const perpMaintenanceRate = 0.004
+
 var (
 	chartFlag  bool
 	saveFlag   bool
@@ -24,6 +28,22 @@ var (
 	tp, sl     float64
 	cfgHash    string
 	source     backtest.Source
+	intrabar   string
+	lowerTf    string
+	execution  backtest.Execution
+	filters    bool
+	portfolio  bool
+	short      bool
+	reverse    bool
+	sigExits   bool
+	perp       bool
+	leverage   float64
+	funding    string
+	exits      trading.Profile
+	ladder     string
+	sizing     string
+	sizer      backtest.Sizer
+	allocation backtest.Allocation
 
 	tformat = "02-01-2006"
 )
@@ -72,7 +92,39 @@ backtest is used to run again existing results from db.`,
 			To:        tto,
 			Timeframe: ttf,
 		}
+		execution.Intrabar, err = backtest.ParseIntrabar(intrabar)
+		if err != nil {
+			return fmt.Errorf("parsing -intrabar: %s\n", err)
+		}
+		if execution.Intrabar == backtest.LowerTimeframe {
+			execution.Lower, err = ts.ParseTf(lowerTf)
+			if err != nil {
+				return fmt.Errorf("parsing -lowertf: %s\n", err)
+			}
+		}
+		exits.Ladder, err = trading.ParseLadder(ladder)
+		if err != nil {
+			return fmt.Errorf("parsing -ladder: %s\n", err)
+		}
+		sizer.Policy, err = backtest.ParseSizing(sizing)
+		if err != nil {
+			return fmt.Errorf("parsing -sizing: %s\n", err)
+		}
 		forcePaperBroker()
+		if filters {
+			symbols, err := loadSymbols()
+			if err != nil {
+				return fmt.Errorf("loading symbols rules: %s\n", err)
+			}
+			broker.(*trading.PaperTrading).Symbols = symbols
+		}
+		if perp {
+			broker.(*trading.PaperTrading).Perpetuals = &trading.Perpetuals{
+				Leverage:        leverage,
+				MaintenanceRate: perpMaintenanceRate,
+				Funding:         make(map[string]trading.FundingRates),
+			}
+		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -129,6 +181,59 @@ func addSaveFlag(set *pflag.FlagSet) {
 	set.BoolVar(&saveFlag, "save", false, "save results to redis")
 }
 
+// setExits sets exit rules of p other than take profit & stop loss from flags,
+// only explicitly set ones unless all is true.
+func setExits(cmd *cobra.Command, p *trading.Profile, all bool) {
+	changed := func(name string) bool {
+		return all || cmd.Flags().Changed(name)
+	}
+	if changed("trailing") {
+		p.TrailingStop = exits.TrailingStop
+	}
+	if changed("trailing-atr") || changed("atr-period") {
+		p.TrailingATR, p.ATRPeriod = exits.TrailingATR, exits.ATRPeriod
+	}
+	if changed("ladder") {
+		p.Ladder = exits.Ladder
+	}
+	if changed("break-even") {
+		p.BreakEven = exits.BreakEven
+	}
+	if changed("max-holding") {
+		p.MaxHolding = exits.MaxHolding
+	}
+}
+
+// addFunding loads funding rates of base/quote perpetual between from & to,
+// when trading perpetuals on paper.
+func addFunding(base, quote string, from, to time.Time) error {
+	pb, ok := broker.(*trading.PaperTrading)
+	if !ok || pb.Perpetuals == nil {
+		return nil
+	}
+	sym := pb.Symbol(base, quote)
+	if _, ok := pb.Perpetuals.Funding[sym]; ok {
+		return nil
+	}
+	var rates trading.FundingRates
+	var err error
+	if funding != "" {
+		var f *os.File
+		if f, err = os.Open(funding); err != nil {
+			return err
+		}
+		defer f.Close()
+		rates, err = trading.ReadFundingRates(f)
+	} else {
+		rates, err = binance.FetchFundingRates(sym, from, to)
+	}
+	if err != nil {
+		return fmt.Errorf("funding rates of %s: %s", sym, err)
+	}
+	pb.Perpetuals.Funding[sym] = rates
+	return nil
+}
+
 func forcePaperBroker() {
 	if _, ok := broker.(*trading.PaperTrading); !ok {
 		log.Println("forcing PaperTrading broker")
@@ -148,51 +253,76 @@ func init() {
 	backtestCmd.PersistentFlags().StringVar(&tf, "tf", ts.TfDay, tfFlagHelper())
 	backtestCmd.PersistentFlags().Float64Var(&tp, "tp", 0.1, "take profit")
 	backtestCmd.PersistentFlags().Float64Var(&sl, "sl", 0.025, "stop loss")
+	backtestCmd.PersistentFlags().StringVar(&intrabar, "intrabar", string(backtest.OnClose),
+		fmt.Sprintf("take profit & stop loss execution, one of %v", backtest.Intrabars))
+	backtestCmd.PersistentFlags().StringVar(&lowerTf, "lowertf", "1h",
+		"lower timeframe used to resolve ambiguous bars with -intrabar lowertf")
+	backtestCmd.PersistentFlags().BoolVar(&execution.NextOpen, "next-open", false,
+		"fill market orders at next bar open instead of current close")
+	backtestCmd.PersistentFlags().Float64Var(&execution.SlippageBps, "slippage", 0, "fixed slippage in bps")
+	backtestCmd.PersistentFlags().Float64Var(&execution.Spread, "spread", 0,
+		"bid/ask spread as a fraction of price, market orders pay half of it")
+	backtestCmd.PersistentFlags().Float64Var(&execution.Impact, "impact", 0,
+		"volume participation slippage, orders slip by impact*sqrt(quantity/volume)")
+	backtestCmd.PersistentFlags().BoolVar(&filters, "filters", false,
+		"enforce exchange lot size, tick size & min notional rules on orders")
+	backtestCmd.PersistentFlags().Float64Var(&exits.TrailingStop, "trailing", 0,
+		"trailing stop, as a fraction of the best price since entry")
+	backtestCmd.PersistentFlags().Float64Var(&exits.TrailingATR, "trailing-atr", 0,
+		"trailing stop, as a multiple of ATR from the best price since entry")
+	backtestCmd.PersistentFlags().IntVar(&exits.ATRPeriod, "atr-period", backtest.DefaultATRPeriod,
+		"ATR period of -trailing-atr")
+	backtestCmd.PersistentFlags().StringVar(&ladder, "ladder", "",
+		"take profits in steps of gain:fraction, e.g. 0.05:0.5,0.1:0.5, in place of -tp")
+	backtestCmd.PersistentFlags().Float64Var(&exits.BreakEven, "break-even", 0,
+		"move stop loss to entry once gain reaches this fraction")
+	backtestCmd.PersistentFlags().DurationVar(&exits.MaxHolding, "max-holding", 0,
+		"close positions held longer than this duration")
+	backtestCmd.PersistentFlags().StringVar(&sizing, "sizing", "",
+		fmt.Sprintf("position sizing policy, one of %v", backtest.Sizings))
+	backtestCmd.PersistentFlags().Float64Var(&sizer.Fraction, "fraction", 0.1,
+		"fraction of equity per position of -sizing fraction, and of kelly until -kelly-trades")
+	backtestCmd.PersistentFlags().Float64Var(&sizer.Risk, "risk", 0.01,
+		"fraction of equity risked per position of -sizing risk & volatility")
+	backtestCmd.PersistentFlags().IntVar(&sizer.ATRPeriod, "sizing-atr", backtest.DefaultATRPeriod,
+		"ATR period of -sizing volatility")
+	backtestCmd.PersistentFlags().Float64Var(&sizer.Kelly, "kelly", 0.5,
+		"fraction of the kelly criterion of -sizing kelly")
+	backtestCmd.PersistentFlags().IntVar(&sizer.MinTrades, "kelly-trades", backtest.DefaultKellyTrades,
+		"closed positions before -sizing kelly applies")
+	backtestCmd.PersistentFlags().BoolVar(&perp, "perp", false,
+		"trade perpetual futures, paying funding rates")
+	backtestCmd.PersistentFlags().Float64Var(&leverage, "leverage", 1, "leverage of perpetual positions")
+	backtestCmd.PersistentFlags().StringVar(&funding, "funding", "",
+		"csv file of funding rates (time,rate[,mark price]) of a single market, fetched from binance futures if empty")
+	backtestCmd.PersistentFlags().BoolVar(&reverse, "reverse", false,
+		"close positions on opposite entry signals, opening the opposite position if allowed")
 	backtestCmd.PersistentFlags().StringVar(&cfgHash, "cfg", "",
 		"load config values from provided <hash> and use if as default, explicit flags will overwrite default from cfg")
 
-	backtestCmd.AddCommand(newaveCmd)
+	backtestCmd.AddCommand(newaveCmd, strategyCmd)
 }
 
 type Details interface {
 	Details() string
 }
 
+// rerun runs again the result of a registered strategy at key, with the
+// config of -cfg if set.
 func rerun(key string) (_db.ZScorer, error) {
-	var prefix string
-	fields := strings.FieldsFunc(key, func(r rune) bool {
-		return r == ':'
-	})
-	if len(fields) > 0 {
-		prefix = fields[0]
-	}
-	switch prefix {
-	case NewavePrefix:
-		return rerunNewave(key)
-	}
-	return nil, fmt.Errorf("unsupported hash prefix: %s", prefix)
-}
-
-func rerunNewave(key string) (*NewaveResult, error) {
-	var nwr NewaveResult
-	err := db.LoadJSON(key, &nwr)
+	res, err := loadResult(key)
 	if err != nil {
-		return nil, fmt.Errorf("LoadJSON: %s", err)
+		return nil, err
 	}
-	cfg := nwr.Config
+	cfg := res.Config
 	if cfgHash != "" {
-		var res NewaveResult
-		err := db.LoadJSON(cfgHash, &res)
+		cfgRes, err := loadResult(cfgHash)
 		if err != nil {
 			log.Fatalf("error loading config: %s", err)
 		}
-		cfg = res.Config
+		cfg = cfgRes.Config
 	}
-	return rerunNewaveWith(nwr, cfg)
-}
-
-func rerunNewaveWith(nwr NewaveResult, cfg NewaveConfig) (*NewaveResult, error) {
-	cfg.Source = nwr.Config.Source
+	cfg.Source = res.Config.Source
 	return cfg.Backtest()
 }
 
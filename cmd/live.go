@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/live"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/brokers"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var (
+	liveTf       string
+	liveTf2      string
+	liveTp       float64
+	liveSl       float64
+	liveShort    bool
+	liveSigExits bool
+	liveReverse  bool
+	liveCfgHash  string
+	liveFunds    float64
+	liveCapital  float64
+	livePoll     time.Duration
+	liveWarmup   int
+	liveKey      string
+	liveStrategy string
+	liveStream   bool
+	streamURL    string
+	userStream   bool
+
+	liveCmd = TraverseRunHooks(&cobra.Command{
+		Use:   "live <base> <quote>",
+		Short: "Trade a strategy live on a market",
+		Long: `Live runs newave, or the strategy of --strategy, on exchange klines of base & quote,
+through the configured broker.
+
+State is saved to redis after every bar, and loaded on start so that a run can be
+resumed. Use --broker paper for forward testing.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			base, quote := args[0], args[1]
+			cfg, err := liveConfig(cmd)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.Base, cfg.Quote = base, quote
+
+			b := broker
+			if bb, ok := b.(*brokers.Binance); ok && userStream {
+				bb.Stream = brokers.NewUserStream(*bb)
+				bb.Stream.URL = streamURL
+				go bb.Stream.Run()
+				defer bb.Stream.Stop()
+			}
+			var account *trading.Account
+			if pb, ok := b.(*trading.PaperTrading); ok {
+				if pb.Account == nil {
+					pb.Account = trading.NewAccount()
+					pb.Account.Deposit(quote, liveCapital)
+				}
+				account = pb.Account
+			} else if riskLimits != (trading.RiskLimits{}) {
+				if account, err = snapshotAccount(b); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if riskLimits != (trading.RiskLimits{}) {
+				b = newRiskManager(b, account, quote)
+			}
+
+			tfs := cfg.timeframes()
+			slowest := tfs[len(tfs)-1]
+			from := time.Now().Add(-slowest.ToDuration() * time.Duration(liveWarmup)).Truncate(slowest.ToDuration())
+			var source interface {
+				trading.DataSource
+				Stop()
+			}
+			if liveStream {
+				markets := []live.Market{{Base: base, Quote: quote, Symbol: b.Symbol(base, quote)}}
+				stream := live.NewStream(markets, from, tfs...)
+				stream.URL, stream.API = streamURL, apiURL
+				source = stream
+			} else {
+				klines := live.NewKlines(base, quote, b.Symbol(base, quote), from, tfs...)
+				klines.API, klines.Poll = apiURL, livePoll
+				source = klines
+			}
+
+			key := liveKey
+			if key == "" {
+				key = fmt.Sprintf("live:%s:%s%s", b.Name(), base, quote)
+			}
+			runner := live.Runner{
+				Source:   source,
+				Strategy: cfg.Strategy.New(),
+				Broker:   b,
+				Profile:  cfg.Profile,
+				Base:     base,
+				Quote:    quote,
+				Funds:    liveFunds,
+				Shorts:   cfg.Shorts,
+				Reverse:  cfg.Reverse,
+				Account:  account,
+				Store:    db,
+				Key:      key,
+			}
+
+			// stop on interrupt, state is saved after every bar
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				<-interrupt
+				log.Println("live: stopping")
+				source.Stop()
+			}()
+
+			log.Printf("live: %s on %s%s through %s, state in %s", cfg, base, quote, b.Name(), key)
+			if err = runner.Run(); err != nil {
+				log.Fatal(err)
+			}
+		},
+	})
+)
+
+func init() {
+	liveCmd.Flags().StringVar(&liveTf, "tf", ts.TfHour, tfFlagHelper())
+	liveCmd.Flags().StringVar(&liveTf2, "tf2", "", tfFlagHelper()+" (defaults to 4 x tf)")
+	liveCmd.Flags().Float64Var(&liveTp, "tp", 0.1, "take profit")
+	liveCmd.Flags().Float64Var(&liveSl, "sl", 0.025, "stop loss")
+	liveCmd.Flags().BoolVar(&liveShort, "short", false, "open short positions when both macds are red")
+	liveCmd.Flags().BoolVar(&liveSigExits, "signal-exits", false, "close positions once macds stop agreeing with them")
+	liveCmd.Flags().BoolVar(&liveReverse, "reverse", false,
+		"close positions on opposite entry signals, opening the opposite position if allowed")
+	liveCmd.Flags().StringVar(&liveStrategy, "strategy", "",
+		"registered strategy name or config file to run in place of newave, of -tf when not set by its options")
+	liveCmd.Flags().StringVar(&liveCfgHash, "cfg", "", "run config of a saved strategy result, flags above are ignored")
+	liveCmd.Flags().Float64Var(&liveFunds, "funds", 0.01, "quote amount of each position")
+	liveCmd.Flags().Float64Var(&liveCapital, "capital", 1, "initial quote balance of --broker paper")
+	liveCmd.Flags().DurationVar(&livePoll, "poll", live.DefaultPoll, "delay between klines requests")
+	liveCmd.Flags().BoolVar(&liveStream, "stream", false, "stream klines over websocket instead of polling")
+	liveCmd.Flags().StringVar(&streamURL, "stream-url", "",
+		"binance websocket base url, e.g. of a local fake exchange (default "+live.StreamURL+")")
+	liveCmd.Flags().BoolVar(&userStream, "user-stream", true,
+		"track orders & balances of --broker binance over its user data stream")
+	liveCmd.Flags().IntVar(&liveWarmup, "warmup", 200, "bars of the slowest timeframe fed before trading")
+	liveCmd.Flags().StringVar(&liveKey, "state", "", "redis key of the run state (default live:<broker>:<base><quote>)")
+	addRiskFlags(liveCmd.Flags())
+}
+
+// liveConfig returns the strategy config of --cfg, or of flags.
+func liveConfig(cmd *cobra.Command) (StrategyConfig, error) {
+	if liveCfgHash != "" {
+		res, err := loadResult(liveCfgHash)
+		if err != nil {
+			return StrategyConfig{}, fmt.Errorf("error loading config: %s", err)
+		}
+		return res.Config, nil
+	}
+	fast, err := ts.ParseTf(liveTf)
+	if err != nil {
+		return StrategyConfig{}, fmt.Errorf("parsing -tf: %s", err)
+	}
+	source := backtest.Source{Exchange: "binance", Timeframe: fast}
+	if liveStrategy != "" {
+		stratCfg, err := loadStrategyConfig(liveStrategy)
+		if err != nil {
+			return StrategyConfig{}, err
+		}
+		return StrategyConfig{
+			Source:   source,
+			Profile:  trading.Profile{TakeProfit: liveTp, StopLoss: liveSl},
+			Strategy: stratCfg,
+			Shorts:   liveShort,
+			Reverse:  liveReverse,
+		}, nil
+	}
+	slow := ts.Timeframe{Unit: fast.Unit, N: fast.N * 4}
+	if liveTf2 != "" {
+		if slow, err = ts.ParseTf(liveTf2); err != nil {
+			return StrategyConfig{}, fmt.Errorf("parsing -tf2: %s", err)
+		}
+	}
+	macdFast, macdSlow := defaultMACD, defaultMACD
+	macdFast.Timeframe, macdSlow.Timeframe = fast, slow
+	cfg := Newave(source, macdFast, macdSlow, liveTp, liveSl)
+	cfg.Reverse = liveReverse
+	setShort(&cfg, liveShort)
+	setSignalExits(&cfg, liveSigExits)
+	return cfg, nil
+}
+
+// snapshotAccount returns an account holding balances of b.
+func snapshotAccount(b trading.Broker) (*trading.Account, error) {
+	s, err := b.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("account snapshot: %s", err)
+	}
+	account := trading.NewAccount()
+	for asset, bal := range s.Balances {
+		account.Funds[asset] = trading.Funds{Free: bal.Free, Locked: bal.Total - bal.Free}
+	}
+	return account, nil
+}
@@ -2,12 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/rkjdid/gocx/trading/strategy"
 	"github.com/rkjdid/gocx/util"
 	"github.com/spf13/cobra"
 	"log"
 	"math"
 	"math/rand"
-	"strings"
 	"time"
 )
 
@@ -44,26 +44,23 @@ there. It can run for a while depending on optimizer config and strat.Backtest..
 					log.Fatalf("db.ZREVRANGE: %s", err)
 				}
 				for _, key := range keys {
-					if strings.Index(key, NewavePrefix) == 0 {
-						t0 := time.Now()
-						log.Printf("optimize %s", key)
-						err = OptimizeNewave(key)
-						if err != nil {
-							log.Printf("optimize %s: %s", key, err)
-						} else {
-							log.Printf("done %s", time.Since(t0))
-						}
+					if _, err = strategy.LookupPrefix(key); err != nil {
+						log.Fatal(err)
+					}
+					t0 := time.Now()
+					log.Printf("optimize %s", key)
+					err = Optimize(key)
+					if err != nil {
+						log.Printf("optimize %s: %s", key, err)
 					} else {
-						log.Fatalf("unsupported hash prefix: %s", key)
+						log.Printf("done %s", time.Since(t0))
 					}
 				}
-			} else if strings.Index(hash, NewavePrefix) == 0 {
-				err := OptimizeNewave(hash)
+			} else {
+				err := Optimize(hash)
 				if err != nil {
 					log.Fatalf("optimize %s: %s", hash, err)
 				}
-			} else {
-				log.Fatalf("unsupported hash prefix: %s", hash)
 			}
 		},
 	})
@@ -146,22 +143,21 @@ type AnnealingState interface {
 	Energy() float64
 }
 
-// OptimizeNewave is the main optimization func for a given NewaveResult hash
-func OptimizeNewave(hash string) error {
-	var nwr NewaveResult
-	err := db.LoadJSON(hash, &nwr)
+// Optimize is the main optimization func for a given StrategyResult hash
+func Optimize(hash string) error {
+	sr, err := loadResult(hash)
 	if err != nil {
 		return fmt.Errorf("couldn't load %s: %s", hash, err)
 	}
 	rank0, _ := db.ZRANK(zkey, hash)
-	log.Printf("initial state: %s", nwr.String())
+	log.Printf("initial state: %s", sr.String())
 	log.Printf("rank %d", rank0)
 
 	sa := saConfig
-	cfg := nwr.Config
-	res, err := sa.Optimize(&NewaveResult{Config: cfg})
+	cfg := sr.Config
+	res, err := sa.Optimize(&StrategyResult{Config: cfg})
 	if res != nil {
-		best := res.(*NewaveResult)
+		best := res.(*StrategyResult)
 		hash, _, err := best.Digest()
 		if err != nil {
 			return fmt.Errorf("couldn't digest result: %s", err)
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"github.com/spf13/pflag"
+)
+
+var riskLimits trading.RiskLimits
+
+func addRiskFlags(set *pflag.FlagSet) {
+	set.Float64Var(&riskLimits.MaxDailyLoss, "max-daily-loss", 0,
+		"stop trading past this loss, as a fraction of equity at the start of the day")
+	set.Float64Var(&riskLimits.MaxDrawdown, "max-drawdown", 0,
+		"stop trading past this loss, as a fraction of peak equity")
+	set.Float64Var(&riskLimits.MaxAssetExposure, "max-asset-exposure", 0,
+		"maximum value held of an asset, as a fraction of equity")
+	set.Float64Var(&riskLimits.MaxExposure, "max-exposure", 0,
+		"maximum value held of all assets, as a fraction of equity")
+	set.Float64Var(&riskLimits.MaxOrderSize, "max-order-size", 0,
+		"maximum value of an order, in quote")
+	set.IntVar(&riskLimits.MaxOrdersPerMinute, "max-orders-per-minute", 0,
+		"maximum number of orders per minute")
+	set.BoolVar(&riskLimits.Flatten, "flatten", false,
+		"close positions when -max-daily-loss or -max-drawdown is reached")
+}
+
+// newRiskManager wraps b with riskLimits, breaches are exported to prometheus.
+func newRiskManager(b trading.Broker, account *trading.Account, quote string) *trading.RiskManager {
+	r := trading.NewRiskManager(b, riskLimits, account, quote)
+	r.OnBreach = func(breach trading.Breach) {
+		riskBreaches.WithLabelValues(breach.Limit).Inc()
+		if breach.Limit == trading.LimitDailyLoss || breach.Limit == trading.LimitDrawdown {
+			killSwitch.Set(1)
+		}
+	}
+	return r
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +13,13 @@ import (
 	"github.com/spf13/cobra"
 	"log"
 	"net/http"
+	"time"
+)
+
+const (
+	// symbolsKey caches binance symbols rules in redis for symbolsTTL.
+	symbolsKey = "symbols:binance"
+	symbolsTTL = time.Hour * 24
 )
 
 var (
@@ -29,6 +37,7 @@ var (
 	brokerName string
 	apiKey     string
 	apiSec     string
+	apiURL     string
 
 	rootCmd = &cobra.Command{
 		Use:   "gocx",
@@ -45,7 +54,7 @@ var (
 			db = &_db.RedisDriver{Pool: p}
 
 			// init prometheus
-			prometheus.MustRegister(signals, trades)
+			prometheus.MustRegister(signals, trades, riskBreaches, killSwitch)
 			if promServer {
 				http.Handle(promHandle, promhttp.Handler())
 				fmt.Printf("%s%s\n", promBind, promHandle)
@@ -59,6 +68,14 @@ var (
 			case "binance":
 				b := brokers.NewBinanceBroker(accName, apiKey, apiSec)
 				b.Account = accName
+				if apiURL != "" {
+					b.BaseURL = apiURL
+				}
+				symbols, err := loadSymbols()
+				if err != nil {
+					log.Println("symbols rules disabled:", err)
+				}
+				b.Symbols = symbols
 				broker = b
 			default:
 				broker = &trading.PaperTrading{
@@ -82,6 +99,14 @@ var (
 	trades = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "trade", Help: "trades",
 	}, []string{"direction", "quantity", "price"})
+
+	riskBreaches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "risk_breach", Help: "risk limits breaches",
+	}, []string{"limit"})
+
+	killSwitch = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "risk_kill_switch", Help: "1 once risk kill switch tripped",
+	})
 )
 
 func Execute() {
@@ -101,8 +126,10 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&accName, "accountName", "", "account name, used for labels and db storing")
 	rootCmd.PersistentFlags().StringVar(&apiKey, "apiKey", "", "api key")
 	rootCmd.PersistentFlags().StringVar(&apiSec, "apiSec", "", "api secret")
+	rootCmd.PersistentFlags().StringVar(&apiURL, "api", "",
+		"binance api base url, e.g. of a local fake exchange (default https://api.binance.com)")
 
-	rootCmd.AddCommand(backtestCmd, topCmd, showCmd, optimizeCmd, snapshotCmd, chartCmd)
+	rootCmd.AddCommand(backtestCmd, topCmd, showCmd, optimizeCmd, snapshotCmd, chartCmd, liveCmd)
 	rootCmd.AddCommand(&cobra.Command{
 		Use: "flushdb",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -114,6 +141,34 @@ func init() {
 	})
 }
 
+// loadSymbols returns binance symbols rules, cached in redis.
+func loadSymbols() (trading.Symbols, error) {
+	var symbols trading.Symbols
+	if err := db.LoadJSON(symbolsKey, &symbols); err == nil && len(symbols) > 0 {
+		return symbols, nil
+	}
+	// exchangeInfo is public, no need for api keys
+	b := brokers.NewBinanceBroker("", "", "")
+	if apiURL != "" {
+		b.BaseURL = apiURL
+	}
+	symbols, err := b.LoadSymbols()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(symbols)
+	if err == nil {
+		err = db.SET(symbolsKey, data)
+	}
+	if err == nil {
+		err = db.EXPIRE(symbolsKey, symbolsTTL)
+	}
+	if err != nil {
+		log.Println("symbols cache:", err)
+	}
+	return symbols, nil
+}
+
 // TraverseRunHooks modifies c's PersistentPreRun* and PersistentPostRun*
 // functions (when present) so that they will search c's command chain and
 // invoke the corresponding hook of the first parent that provides a hook.
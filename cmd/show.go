@@ -3,9 +3,9 @@ package cmd
 import (
 	"fmt"
 	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/trading/strategy"
 	"github.com/spf13/cobra"
 	"log"
-	"strings"
 )
 
 var (
@@ -17,13 +17,12 @@ var (
 		Run: func(cmd *cobra.Command, args []string) {
 			var err error
 			var id = args[0]
-			if strings.Index(id, NewavePrefix) == 0 {
-				var nwr NewaveResult
-				err = db.LoadJSON(id, &nwr)
+			if _, err = strategy.LookupPrefix(id); err == nil {
+				res, err := loadResult(id)
 				if err != nil {
-					log.Fatalf("db.LoadJSON: %s", err)
+					log.Fatal(err)
 				}
-				fmt.Println(nwr.Details())
+				fmt.Println(res.Details())
 			} else {
 				// generic backtest.Result
 				var r backtest.Result
@@ -31,7 +30,7 @@ var (
 				if err != nil {
 					log.Fatalf("db.LoadJSON: %s", err)
 				}
-				fmt.Println(r)
+				fmt.Println(r.Details())
 			}
 		},
 	})
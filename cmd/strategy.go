@@ -0,0 +1,519 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/backtest/scraper/binance"
+	"github.com/rkjdid/gocx/chart"
+	_db "github.com/rkjdid/gocx/db"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+	"github.com/spf13/cobra"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StrategyConfig is the backtest config of a registered strategy.
+type StrategyConfig struct {
+	backtest.Source
+	trading.Profile
+	Strategy  strategy.Config
+	Execution backtest.Execution
+	Sizer     backtest.Sizer
+	// Shorts opens short positions on sell signals.
+	Shorts bool
+	// Reverse closes positions on opposite entry signals.
+	Reverse bool
+}
+
+var strategyCmd = TraverseRunHooks(&cobra.Command{
+	Use:   "strategy <name|config> [<base> <quote>]",
+	Short: "Backtest any registered strategy",
+	Long: fmt.Sprintf(`Strategy backtests a registered strategy of default options, or of a
+YAML/JSON config file naming the strategy and its options, e.g.:
+
+  name: composite
+  options:
+    mode: vote
+    children:
+      - name: rsi
+        options: {period: 14, oversold: 30, overbought: 70}
+      - name: supertrend
+        options: {period: 10, multiplier: 3, timeframe: 4h}
+
+Zero timeframes of options stand for -tf. Registered strategies: %s`,
+		strings.Join(strategy.Registered(), ", ")),
+	Args: func(cmd *cobra.Command, args []string) error {
+		switch len(args) {
+		case 1, 3:
+			return nil
+		default:
+			return fmt.Errorf("expected <name|config> or <name|config> <base> <quote>")
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		stratCfg, err := loadStrategyConfig(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := StrategyConfig{
+			Source:    source,
+			Profile:   trading.Profile{TakeProfit: tp, StopLoss: sl},
+			Strategy:  stratCfg,
+			Execution: execution,
+			Sizer:     sizer,
+			Shorts:    short,
+			Reverse:   reverse,
+		}
+		setExits(cmd, &cfg.Profile, true)
+		runStrategy(cfg, args[1:])
+	},
+})
+
+func init() {
+	strategyCmd.Flags().BoolVar(&short, "short", false, "open short positions on sell signals")
+	addMarketsFlags(strategyCmd)
+}
+
+// loadStrategyConfig returns the config of registered strategy arg with
+// default options, or loads it from config file arg.
+func loadStrategyConfig(arg string) (strategy.Config, error) {
+	cfg, err := strategy.NewConfig(arg)
+	if err == nil {
+		if err = cfg.Options.Validate(); err != nil {
+			return cfg, fmt.Errorf("%s: %s", arg, err)
+		}
+		return cfg, nil
+	}
+	if _, statErr := os.Stat(arg); statErr != nil {
+		return cfg, err
+	}
+	return strategy.LoadConfig(arg)
+}
+
+// addMarketsFlags adds flags of running a strategy on top markets.
+func addMarketsFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&portfolio, "portfolio", false,
+		"backtest top n markets together, sharing capital")
+	cmd.Flags().BoolVar(&allocation.EqualWeight, "equal-weight", false,
+		"with -portfolio, size positions to an equal share of equity")
+	cmd.Flags().IntVar(&allocation.MaxPositions, "max-positions", 0,
+		"with -portfolio, maximum number of open positions (0 for no limit)")
+	cmd.Flags().Float64Var(&allocation.MaxPerAsset, "max-per-asset", 0,
+		"with -portfolio, maximum fraction of equity per position (0 for no limit)")
+}
+
+// runStrategy runs cfg on market <base> <quote> of args, or on top n
+// markets if args is empty.
+func runStrategy(cfg StrategyConfig, args []string) {
+	if len(args) == 0 && funding != "" {
+		// every market would pay rates of the file
+		log.Fatalln("-funding holds rates of a single market, run on <base> <quote>")
+	}
+	if len(args) == 0 && portfolio {
+		_, _ = StrategyPortfolio(cfg, n, allocation)
+	} else if len(args) == 0 {
+		StrategyTop(cfg, n)
+	} else if len(args) == 2 {
+		_, _ = RunStrategyFor(cfg, args[0], args[1])
+	}
+}
+
+func StrategyTop(cfg StrategyConfig, n int) {
+	tickers, err := binance.FetchTopTickers("", "BTC")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n <= 0 {
+		n = len(tickers)
+	}
+	for _, v := range tickers[:n] {
+		_, _ = RunStrategyFor(cfg, v.Base, v.Quote)
+	}
+}
+
+// StrategyPortfolio backtests cfg on top n markets in a single engine, sharing
+// capital according to alloc.
+func StrategyPortfolio(cfg StrategyConfig, n int, alloc backtest.Allocation) (*backtest.Result, error) {
+	tickers, err := binance.FetchTopTickers("", "BTC")
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		n = len(tickers)
+	}
+
+	engine := backtest.Engine{
+		Broker:     broker,
+		Profile:    cfg.Profile,
+		Allocation: alloc,
+		Sizer:      cfg.Sizer,
+		Execution:  cfg.Execution,
+		Shorts:     cfg.Shorts,
+		Reverse:    cfg.Reverse,
+	}
+	var sources backtest.Merged
+	for _, v := range tickers[:n] {
+		c := cfg
+		c.Base, c.Quote = v.Base, v.Quote
+		source, _, lower, err := c.load()
+		if err == nil {
+			from, to := source.Bondaries()
+			err = addFunding(c.Base, c.Quote, from, to)
+		}
+		if err != nil {
+			log.Printf("%s %s:%s%s - %s", cfg.Strategy.Name, x, c.Base, c.Quote, err)
+			continue
+		}
+		sources = append(sources, source)
+		engine.Markets = append(engine.Markets, backtest.Market{
+			Base: c.Base, Quote: c.Quote, Strategy: c.Strategy.New(), Lower: lower,
+		})
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no market to backtest")
+	}
+	engine.Source = sources
+
+	res, err := engine.Run()
+	if err != nil {
+		log.Printf("%s portfolio - %s", cfg.Strategy.Name, err)
+		return nil, err
+	}
+	for _, p := range res.Positions {
+		fmt.Printf("%8s%s\n", p.Base+p.Quote, p)
+	}
+	fmt.Println(res)
+	fmt.Println(res.Metrics)
+
+	if chartFlag {
+		cname := fmt.Sprintf("img/%s_portfolio_equity.png", cfg.Strategy.Name)
+		width := math.Max(float64(len(res.Equity)), 1200)
+		chart.Reset()
+		chart.SetTitles(fmt.Sprintf("%s portfolio equity, %d markets", cfg.Exchange, len(engine.Markets)), "", "")
+		chart.AddLine(res.Equity, "equity")
+		chart.AddLine(backtest.Cash(res.Equity), "cash")
+		if err = chart.Save(width, width/1.77, false, cname); err != nil {
+			return res, err
+		}
+		log.Printf("saved \"%s\"", cname)
+		chart.Reset()
+	}
+	return res, nil
+}
+
+func RunStrategy(cfg StrategyConfig) (*StrategyResult, error) {
+	res, err := cfg.Backtest()
+	if err != nil {
+		log.Printf("%s %s:%s%s - %s", cfg.Strategy.Name, x, cfg.Base, cfg.Quote, err)
+		return nil, err
+	}
+	fmt.Println(res.Details())
+
+	if saveFlag {
+		_, err = db.SaveZScorer(res, zkey)
+		if err != nil {
+			log.Println("db: error saving backtest result:", err)
+		}
+	}
+	return res, err
+}
+
+func RunStrategyFor(cfg StrategyConfig, bcur, qcur string) (*StrategyResult, error) {
+	cfg.Base = bcur
+	cfg.Quote = qcur
+	return RunStrategy(cfg)
+}
+
+func (c StrategyConfig) Backtest() (*StrategyResult, error) {
+	source, hists, lower, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	// set actual bondaries after LoadHistorical
+	c.From, c.To = source.Bondaries()
+	if err = addFunding(c.Base, c.Quote, c.From, c.To); err != nil {
+		return nil, err
+	}
+
+	engine := backtest.Engine{
+		Source:    source,
+		Strategy:  c.Strategy.New(),
+		Broker:    broker,
+		Profile:   c.Profile,
+		Base:      c.Base,
+		Quote:     c.Quote,
+		Execution: c.Execution,
+		Sizer:     c.Sizer,
+		Lower:     lower,
+		Shorts:    c.Shorts,
+		Reverse:   c.Reverse,
+		Chart:     chartFlag,
+	}
+
+	// init chart on the slowest timeframe
+	if chartFlag {
+		chart.SetTitles(fmt.Sprintf("%s:%s%s", c.Exchange, c.Base, c.Quote), "", "")
+		chart.AddOHLCVs(hists[len(hists)-1].Data)
+	}
+
+	res, err := engine.Run()
+	if err != nil {
+		return nil, err
+	}
+	var result = StrategyResult{
+		Config: c,
+		Result: *res,
+	}
+
+	// draw chart
+	if chartFlag {
+		cname := fmt.Sprintf("img/%s_%s%s.png", c.Strategy.Name, c.Base, c.Quote)
+		width := math.Max(float64(len(hists[0].Data)), 1200)
+		height := width / 1.77
+
+		err = chart.Save(width, height, false, cname)
+		if err != nil {
+			return &result, err
+		}
+		log.Printf("saved \"%s\"", cname)
+
+		// equity curve on its own chart
+		chart.Reset()
+		chart.SetTitles(fmt.Sprintf("%s:%s%s equity", c.Exchange, c.Base, c.Quote), "", "")
+		chart.AddLine(result.Equity, "equity")
+		chart.AddLine(backtest.Cash(result.Equity), "cash")
+		cname = fmt.Sprintf("img/%s_%s%s_equity.png", c.Strategy.Name, c.Base, c.Quote)
+		err = chart.Save(width, height, false, cname)
+		if err != nil {
+			return &result, err
+		}
+		log.Printf("saved \"%s\"", cname)
+		chart.Reset()
+	}
+
+	return &result, nil
+}
+
+// timeframes returns distinct timeframes the strategy of c is fed with,
+// fastest first. Zero timeframes stand for c.Timeframe.
+func (c StrategyConfig) timeframes() []ts.Timeframe {
+	var tfs []ts.Timeframe
+next:
+	for _, tf := range c.Strategy.Options.Timeframes() {
+		if tf == (ts.Timeframe{}) {
+			tf = c.Timeframe
+		}
+		for _, seen := range tfs {
+			if tf.Equals(seen) {
+				continue next
+			}
+		}
+		tfs = append(tfs, tf)
+	}
+	sort.Slice(tfs, func(i, j int) bool {
+		return tfs[i].Lt(tfs[j])
+	})
+	return tfs
+}
+
+// load returns historical data of c market, fastest timeframe first, with
+// the data source feeding them, and lower timeframe data if c.Execution
+// needs it.
+func (c StrategyConfig) load() (trading.DataSource, []*backtest.Historical, ts.OHLCVs, error) {
+	tfs := c.timeframes()
+	if len(tfs) == 0 || len(tfs) > 2 {
+		return nil, nil, nil, fmt.Errorf("%s: expected 1 or 2 timeframes, got %v", c.Strategy.Name, tfs)
+	}
+	var hists []*backtest.Historical
+	for _, tf := range tfs {
+		if !tf.IsValid() {
+			return nil, nil, nil, fmt.Errorf("invalid tf: %s", tf)
+		}
+		hist, err := backtest.LoadHistorical(db, c.Exchange, c.Base, c.Quote, tf, c.From, c.To)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		hists = append(hists, hist)
+	}
+	var source trading.DataSource = hists[0]
+	if len(hists) == 2 {
+		source = backtest.NewHistoricalPair(hists[0], hists[1])
+	}
+	if c.Execution.Intrabar != backtest.LowerTimeframe {
+		return source, hists, nil, nil
+	}
+	if !c.Execution.Lower.Lt(tfs[0]) {
+		return nil, nil, nil, fmt.Errorf("lower tf %s must be lower than %s", c.Execution.Lower, tfs[0])
+	}
+	from, to := source.Bondaries()
+	histLower, err := backtest.LoadHistorical(db, c.Exchange, c.Base, c.Quote, c.Execution.Lower, from, to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return source, hists, histLower.Data, nil
+}
+
+func (c StrategyConfig) String() string {
+	s := fmt.Sprintf("%s - tp %.1f%% sl %.1f%% - %s",
+		c.Strategy, c.TakeProfit*100, -c.StopLoss*100, c.Execution,
+	)
+	if rules := c.Rules(); rules != "" {
+		s += " - " + rules
+	}
+	if c.Sizer.Policy != "" && c.Sizer.Policy != backtest.AllIn {
+		s += " - " + c.Sizer.String()
+	}
+	if c.Shorts {
+		s += " - short"
+	}
+	if c.Reverse {
+		s += " - reverse"
+	}
+	return s
+}
+
+func (c *StrategyConfig) UnmarshalJSON(data []byte) error {
+	type fields StrategyConfig
+	if err := json.Unmarshal(data, (*fields)(c)); err != nil {
+		return err
+	}
+	if c.Strategy.Name != "" {
+		return nil
+	}
+	// newave results saved before strategies were registered hold its
+	// options inline
+	var legacy strategy.NewaveOpts
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if err := legacy.Validate(); err != nil {
+		return fmt.Errorf("newave options: %s", err)
+	}
+	c.Strategy = strategy.Config{Name: "newave", Options: &legacy}
+	c.Shorts = legacy.Short
+	return nil
+}
+
+type StrategyResult struct {
+	Config StrategyConfig
+	backtest.Result
+	Id string
+}
+
+// loadResult loads the result of a registered strategy at key.
+func loadResult(key string) (*StrategyResult, error) {
+	if _, err := strategy.LookupPrefix(key); err != nil {
+		return nil, err
+	}
+	var res StrategyResult
+	if err := db.LoadJSON(key, &res); err != nil {
+		return nil, fmt.Errorf("LoadJSON: %s", err)
+	}
+	return &res, nil
+}
+
+// Digest is db.Digester implementation with json data and a id-hash.
+func (sr *StrategyResult) Digest() (id string, data []byte, err error) {
+	prefix := sr.Config.Strategy.Name
+	if r, err := strategy.Lookup(prefix); err == nil {
+		prefix = r.Prefix
+	}
+	id, data, err = _db.JSONDigest(
+		fmt.Sprintf("%s:%s%s", prefix, sr.Config.Base, sr.Config.Quote),
+		sr,
+	)
+	sr.Id = id
+	return
+}
+
+func (sr StrategyResult) String() string {
+	if sr.Id == "" {
+		_, _, _ = sr.Digest()
+	}
+	return fmt.Sprintf("%s | %s | %s | %s", sr.Id, sr.Config.Source, sr.Config, sr.Result)
+}
+
+func (sr StrategyResult) Details() string {
+	s := ""
+	for _, p := range sr.Positions {
+		s += fmt.Sprintln(p)
+	}
+	hash, _, _ := sr.Digest()
+	return s + fmt.Sprintln(sr) + fmt.Sprintln(sr.Metrics) + fmt.Sprintln("id:", hash)
+}
+
+// SA implementation
+
+func (sr *StrategyResult) Move() AnnealingState {
+	next := *sr
+
+	// todo explore timeframes space also ?
+
+	// risk parameters search space
+	next.Config.StopLoss += util.RandRangeF(-0.01, 0.01)
+	next.Config.TakeProfit += util.RandRangeF(-0.01, 0.01)
+	util.FixRangeLinearF(&next.Config.StopLoss, 0.01, .618)
+	util.FixRangeLinearF(&next.Config.TakeProfit, 0.05, .618)
+
+	// other exit rules are only searched when enabled
+	exits := &next.Config.Profile
+	if exits.TrailingStop > 0 {
+		exits.TrailingStop += util.RandRangeF(-0.01, 0.01)
+		util.FixRangeLinearF(&exits.TrailingStop, 0.005, .382)
+	}
+	if exits.TrailingATR > 0 {
+		exits.TrailingATR += util.RandRangeF(-0.25, 0.25)
+		exits.ATRPeriod += util.RandRange(-1, 1)
+		util.FixRangeLinearF(&exits.TrailingATR, 0.5, 8)
+		util.FixRangeLinear(&exits.ATRPeriod, 2, 55)
+	}
+	if exits.BreakEven > 0 {
+		exits.BreakEven += util.RandRangeF(-0.01, 0.01)
+		util.FixRangeLinearF(&exits.BreakEven, 0.005, .382)
+	}
+	if exits.MaxHolding > 0 {
+		tf := next.Config.timeframes()[0].ToDuration()
+		exits.MaxHolding += time.Duration(util.RandRange(-1, 1)) * tf
+		if exits.MaxHolding < tf {
+			exits.MaxHolding = tf
+		}
+	}
+	if len(exits.Ladder) > 0 {
+		// steps are shared with sr
+		exits.Ladder = append(trading.Ladder(nil), exits.Ladder...)
+		for i := range exits.Ladder {
+			step := &exits.Ladder[i]
+			step.Gain += util.RandRangeF(-0.01, 0.01)
+			step.Fraction += util.RandRangeF(-0.05, 0.05)
+			util.FixRangeLinearF(&step.Gain, 0.005, .618)
+			util.FixRangeLinearF(&step.Fraction, 0.05, 1)
+			if i > 0 && step.Gain <= exits.Ladder[i-1].Gain {
+				step.Gain = exits.Ladder[i-1].Gain + 0.005
+			}
+		}
+	}
+
+	// strategy parameters search space
+	next.Config.Strategy = next.Config.Strategy.Move()
+
+	return &next
+}
+
+func (sr *StrategyResult) Energy() float64 {
+	res, err := sr.Config.Backtest()
+	if err != nil {
+		log.Println("sr.Backtest():", err)
+		return 0
+	}
+	*sr = *res
+	// in annealing sim, the lesser the energy the better
+	return -sr.ZScore()
+}
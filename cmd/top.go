@@ -4,13 +4,23 @@ import (
 	"fmt"
 	"github.com/rkjdid/gocx/backtest"
 	"github.com/rkjdid/gocx/backtest/scraper/binance"
+	"github.com/rkjdid/gocx/trading/strategy"
 	"github.com/spf13/cobra"
 	"log"
-	"strings"
+	"sort"
 )
 
+// rankedResult is a backtest result loaded from db, displayed through
+// display and sorted on res.Metrics.
+type rankedResult struct {
+	key     string
+	display fmt.Stringer
+	res     *backtest.Result
+}
+
 var (
 	script bool
+	sortBy string
 
 	topCmd = TraverseRunHooks(&cobra.Command{
 		Use:   "top",
@@ -24,25 +34,38 @@ var (
 		Short: "Display best scoring backtest executions",
 		Long:  `ZREVRANGE on the sorted set holding strat backtest and display corresponding results`,
 		Run: func(cmd *cobra.Command, args []string) {
-			keys, err := db.ZREVRANGE(zkey, 0, n)
+			var metric backtest.Metric
+			if sortBy != "" {
+				var err error
+				metric, err = backtest.MetricByName(sortBy)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			// all results are ranked by metric, top n only by z score
+			last := n
+			if sortBy != "" {
+				last = -1
+			}
+			keys, err := db.ZREVRANGE(zkey, 0, last)
 			if err != nil {
 				log.Fatalf("db.ZREVRANGE: %s", err)
 			}
-			for i, key := range keys {
-				if script {
+			var results []rankedResult
+			for _, key := range keys {
+				if script && sortBy == "" {
 					fmt.Println(key)
 					continue
 				}
 
-				var result interface{}
-				if strings.Index(key, NewavePrefix) == 0 {
-					var nwr NewaveResult
-					err = db.LoadJSON(key, &nwr)
+				var result rankedResult
+				if _, err = strategy.LookupPrefix(key); err == nil {
+					res, err := loadResult(key)
 					if err != nil {
-						log.Println("LoadJSON:", err)
+						log.Println(err)
 						continue
 					}
-					result = nwr
+					result = rankedResult{key, res, &res.Result}
 				} else {
 					// generic backtest.Result
 					var r backtest.Result
@@ -51,9 +74,30 @@ var (
 						log.Println("LoadJSON:", err)
 						continue
 					}
-					result = r
+					result = rankedResult{key, &r, &r}
 				}
-				fmt.Printf("%3d/ %s %s\n", i+1, key, result)
+				// results saved before metrics were introduced
+				if result.res.Metrics == (backtest.Metrics{}) {
+					result.res.UpdateScore()
+				}
+				results = append(results, result)
+			}
+
+			if sortBy != "" {
+				sort.SliceStable(results, func(i, j int) bool {
+					return metric.Better(results[i].res.Metrics, results[j].res.Metrics)
+				})
+				// as many as ZREVRANGE 0 n holds
+				if n >= 0 && len(results) > n+1 {
+					results = results[:n+1]
+				}
+			}
+			for i, r := range results {
+				if script {
+					fmt.Println(r.key)
+					continue
+				}
+				fmt.Printf("%3d/ %s %s\n", i+1, r.key, r.display)
 			}
 		},
 	})
@@ -80,7 +124,16 @@ var (
 func init() {
 	topCmd.PersistentFlags().IntVarP(&n, "n", "n", 10, "top n markets/executions")
 	topCmd.PersistentFlags().BoolVarP(&script, "script", "s", false, "print in a script friendly manner, if possible")
+	strategiesCmd.Flags().StringVar(&sortBy, "sort", "", metricFlagHelper())
 	marketsCmd.LocalFlags().StringVarP(&x, "exchange", "x", "binance", "exchange to fetch markets from")
 
 	topCmd.AddCommand(strategiesCmd, marketsCmd)
 }
+
+func metricFlagHelper() string {
+	var names []string
+	for _, m := range backtest.MetricsList {
+		names = append(names, m.Name)
+	}
+	return fmt.Sprintf("sort all results by metric, one of %v, and keep the top n", names)
+}
@@ -0,0 +1,92 @@
+package live
+
+import (
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/brokers"
+	"github.com/rkjdid/gocx/trading/brokers/fakebinance"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// replay feeds ticks of Klines after setting their close as price on exchange,
+// once the previous tick state is saved. It stops Klines after n ticks.
+type replay struct {
+	*Klines
+	exchange *fakebinance.Exchange
+	saved    chan struct{}
+	n        int
+}
+
+func (r replay) Feed() <-chan trading.Tick {
+	ch := make(chan trading.Tick)
+	go func() {
+		defer close(ch)
+		i := 0
+		for x := range r.Klines.Feed() {
+			r.exchange.SetPrice(r.Symbol, x.Timestamp.T().Add(x.Timeframe.ToDuration()), x.Close)
+			ch <- x
+			<-r.saved
+			if i++; i == r.n {
+				r.Stop()
+			}
+		}
+	}()
+	return ch
+}
+
+// signalStore signals on saved every time state is saved.
+type signalStore struct {
+	memStore
+	saved chan struct{}
+}
+
+func (s signalStore) SET(key string, v interface{}) error {
+	defer func() { s.saved <- struct{}{} }()
+	return s.memStore.SET(key, v)
+}
+
+func TestRunner_Binance(t *testing.T) {
+	symbols := trading.Symbols{"ABCBTC": {Symbol: "ABCBTC", Base: "ABC", Quote: "BTC", StepSize: 0.001}}
+	e := fakebinance.NewExchange("key", "secret", symbols)
+	e.Deposit("BTC", 10)
+	e.AddKlines("ABCBTC", ts.Timeframe{N: 1, Unit: ts.TfHour}, hourly(10, 10, 11, 12, 12).Data...)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	b := brokers.NewBinanceBroker("test", "key", "secret")
+	b.BaseURL = srv.URL
+	klines := NewKlines("ABC", "BTC", "ABCBTC", t0, ts.Timeframe{N: 1, Unit: ts.TfHour})
+	klines.API, klines.Poll = srv.URL, time.Millisecond
+	account := trading.NewAccount()
+	account.Deposit("BTC", 10)
+	saved := make(chan struct{})
+	r := Runner{
+		Source:   replay{klines, e, saved, 5},
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   b,
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+		Base:     "ABC", Quote: "BTC",
+		Funds:   1,
+		Account: account,
+		Store:   signalStore{memStore{}, saved},
+		Key:     "state",
+		Start:   t0,
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p := r.State.Position
+	if p == nil || p.State != trading.Closed || !almostEq(p.AvgEntry, 10) || !almostEq(p.AvgExit, 12) {
+		t.Fatalf("expected 10 -> 12 position, got %v", p)
+	}
+	bal := e.Balances()
+	if !almostEq(bal["BTC"].Free, 10.2) || !almostEq(bal["ABC"].Total(), 0) {
+		t.Errorf("unexpected exchange balances %v", bal)
+	}
+	if !almostEq(account.Free("BTC"), bal["BTC"].Free) || !almostEq(account.Total("ABC"), 0) {
+		t.Errorf("account %s differs from exchange balances %v", account, bal)
+	}
+}
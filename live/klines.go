@@ -0,0 +1,145 @@
+package live
+
+import (
+	"github.com/rkjdid/gocx/backtest/scraper/binance"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DefaultPoll is the delay between two Klines requests when Poll is unset.
+const DefaultPoll = time.Second * 10
+
+// Klines is a live trading.DataSource polling binance klines of a market. It feeds
+// closed bars only, of all Timeframes in close time order, faster ones first.
+type Klines struct {
+	// API is the exchange base url, binance.SpotAPI if empty, Client
+	// is http.DefaultClient if nil.
+	API    string
+	Client *http.Client
+
+	Base, Quote string
+	Symbol      string
+	Timeframes  []ts.Timeframe
+	// From is the open time of the first bars fed, history is fed first.
+	From time.Time
+	Poll time.Duration
+	// Done stops Feed when closed.
+	Done chan struct{}
+
+	// now is time.Now & limit binance.KlinesLimit, replaced in tests.
+	now   func() time.Time
+	limit int
+}
+
+func NewKlines(base, quote, sym string, from time.Time, tfs ...ts.Timeframe) *Klines {
+	return &Klines{
+		Base: base, Quote: quote, Symbol: sym, Timeframes: tfs, From: from,
+		Done: make(chan struct{}),
+	}
+}
+
+// Stop stops Feed, which closes its channel.
+func (k *Klines) Stop() {
+	close(k.Done)
+}
+
+func (k *Klines) Feed() <-chan trading.Tick {
+	ch := make(chan trading.Tick)
+	go func() {
+		defer close(ch)
+		poll := k.Poll
+		if poll <= 0 {
+			poll = DefaultPoll
+		}
+		next := make([]time.Time, len(k.Timeframes))
+		for i := range next {
+			next[i] = k.From
+		}
+		for {
+			ticks, more := k.fetch(next)
+			for _, x := range ticks {
+				select {
+				case ch <- x:
+				case <-k.Done:
+					return
+				}
+			}
+			if more {
+				// catching up on history
+				continue
+			}
+			select {
+			case <-time.After(poll):
+			case <-k.Done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// fetch returns bars closed since next open times by timeframe, and advances them.
+// Bars closing after the end of a full page of another timeframe are left for the
+// next call, so that timeframes stay in order, more is true if there are some.
+func (k *Klines) fetch(next []time.Time) (ticks []trading.Tick, more bool) {
+	now := time.Now
+	if k.now != nil {
+		now = k.now
+	}
+	limit := k.limit
+	if limit <= 0 {
+		limit = binance.KlinesLimit
+	}
+	t := now()
+	horizon := t
+	type bar struct {
+		i int
+		binance.Kline
+	}
+	var bars []bar
+	for i, tf := range k.Timeframes {
+		klines, err := binance.FetchKlines(k.Client, k.API, k.Symbol, tf, next[i], limit)
+		if err != nil {
+			log.Printf("klines %s %s: %s", k.Symbol, tf, err)
+			// try again on next poll, without feeding other timeframes past this one
+			if next[i].Before(horizon) {
+				horizon = next[i]
+			}
+			continue
+		}
+		if len(klines) == limit {
+			more = true
+			if last := klines[len(klines)-1].CloseTime; last.Before(horizon) {
+				horizon = last
+			}
+		}
+		for _, kl := range klines {
+			bars = append(bars, bar{i, kl})
+		}
+	}
+	sort.SliceStable(bars, func(a, b int) bool {
+		if !bars[a].CloseTime.Equal(bars[b].CloseTime) {
+			return bars[a].CloseTime.Before(bars[b].CloseTime)
+		}
+		return k.Timeframes[bars[a].i].Lt(k.Timeframes[bars[b].i])
+	})
+	for _, b := range bars {
+		if b.CloseTime.After(horizon) || !b.CloseTime.Before(t) {
+			continue
+		}
+		ticks = append(ticks, trading.Tick{
+			Timeframe: k.Timeframes[b.i], Base: k.Base, Quote: k.Quote, OHLCV: b.OHLCV,
+		})
+		next[b.i] = b.Timestamp.T().Add(k.Timeframes[b.i].ToDuration())
+	}
+	return ticks, more
+}
+
+// Bondaries returns From, and a zero end time as live data has no end.
+func (k *Klines) Bondaries() (from, to time.Time) {
+	return k.From, time.Time{}
+}
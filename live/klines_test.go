@@ -0,0 +1,64 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// klinesServer serves 1h & 2h klines of hourly closes from t0.
+func klinesServer(t *testing.T, closes []float64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tf := time.Hour
+		if r.URL.Query().Get("interval") == "2h" {
+			tf = 2 * time.Hour
+		}
+		start, err := strconv.ParseInt(r.URL.Query().Get("startTime"), 10, 64)
+		if err != nil {
+			t.Errorf("invalid startTime: %s", err)
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var rows [][]interface{}
+		for i := 0; i*int(tf/time.Hour) < len(closes) && len(rows) < limit; i++ {
+			open := t0.Add(tf * time.Duration(i))
+			ms := open.UnixNano() / int64(time.Millisecond)
+			if ms < start {
+				continue
+			}
+			c := fmt.Sprint(closes[i*int(tf/time.Hour)])
+			rows = append(rows, []interface{}{
+				ms, c, c, c, c, "1", ms + int64(tf/time.Millisecond) - 1, "0", 1, "0", "0", "0",
+			})
+		}
+		_ = json.NewEncoder(w).Encode(rows)
+	}))
+}
+
+func TestKlines_Feed(t *testing.T) {
+	s := klinesServer(t, []float64{1, 2, 3, 4, 5})
+	defer s.Close()
+	hour := ts.Timeframe{N: 1, Unit: ts.TfHour}
+	k := NewKlines("ABC", "BTC", "ABCBTC", t0, hour, ts.Timeframe{N: 2, Unit: ts.TfHour})
+	k.API, k.Poll, k.limit = s.URL, time.Millisecond, 2
+	// the 5th hour is not closed yet
+	k.now = func() time.Time { return t0.Add(4*time.Hour + time.Minute) }
+
+	var got []string
+	for x := range k.Feed() {
+		got = append(got, fmt.Sprintf("%dh@%d", x.Timeframe.N, x.Timestamp.T().Hour()))
+		if len(got) == 6 {
+			k.Stop()
+		}
+	}
+	expected := fmt.Sprint([]string{"1h@0", "1h@1", "2h@0", "1h@2", "1h@3", "2h@2"})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s, got %v", expected, got)
+	}
+}
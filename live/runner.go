@@ -0,0 +1,277 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"log"
+	"time"
+)
+
+// Store persists Runner state, it is implemented by db.RedisDriver.
+type Store interface {
+	LoadJSON(key string, v interface{}) error
+	SET(key string, v interface{}) error
+}
+
+// State of a Runner, saved after every live bar.
+type State struct {
+	// Position is the current, or last, position.
+	Position *trading.Position
+	Tracker  *trading.Tracker
+	// Last is the last strategy action, LastTime the time of the last bar traded.
+	Last     strategy.Action
+	LastTime time.Time
+}
+
+// Runner trades Strategy on a live Source through Broker, one position at a time,
+// closing positions according to Profile on bars close.
+type Runner struct {
+	Source      trading.DataSource
+	Strategy    strategy.Strategy
+	Broker      trading.Broker
+	Profile     trading.Profile
+	Base, Quote string
+	// Funds is the quote amount of each position.
+	Funds float64
+	// Shorts enables opening short positions on strategy.Sell signals.
+	Shorts bool
+	// Reverse closes positions on entry signals of the opposite direction,
+	// opening the opposite position if allowed. Exit signals always close them.
+	Reverse bool
+	// Account, when set, is kept up to date with fills of brokers other than
+	// trading.PaperTrading, which applies them itself.
+	Account *trading.Account
+
+	// Store, when set, persists State under Key, it is loaded on Run.
+	Store Store
+	Key   string
+	// Start is when trading starts, bars closed before it only feed Strategy.
+	// It is the time of Run if unset.
+	Start time.Time
+
+	State State
+	atr   *indicator.ATR
+	// applied is the number of Position transactions applied to Account.
+	applied int
+}
+
+// Run trades until Source feed is closed.
+func (r *Runner) Run() error {
+	if r.Source == nil || r.Strategy == nil || r.Broker == nil {
+		return fmt.Errorf("live: nil source, strategy or broker")
+	}
+	if r.Base == "" || r.Quote == "" {
+		return fmt.Errorf("live: empty base or quote")
+	}
+	if r.Funds <= 0 {
+		return fmt.Errorf("live: funds must be positive")
+	}
+	if r.Start.IsZero() {
+		r.Start = time.Now()
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	if r.Profile.TrailingATR > 0 {
+		period := r.Profile.ATRPeriod
+		if period <= 0 {
+			period = backtest.DefaultATRPeriod
+		}
+		r.atr = indicator.NewATR(period)
+	}
+	sym := r.Broker.Symbol(r.Base, r.Quote)
+
+	// liveTime is the close time of the last bar traded by this run, bars of
+	// other timeframes closing at the same time are traded too
+	var lastTime, liveTime time.Time
+	for x := range r.Source.Feed() {
+		// a slower timeframe bar may be older than the previous one
+		inOrder := x.Timestamp.T().After(lastTime)
+		if inOrder {
+			lastTime = x.Timestamp.T()
+		}
+		closeTime := x.Timestamp.T().Add(x.Timeframe.ToDuration())
+		live := !closeTime.Before(r.Start) &&
+			(closeTime.After(r.State.LastTime) || closeTime.Equal(liveTime))
+
+		if live && inOrder {
+			r.update(sym, x)
+			if pos := r.State.Position; pos != nil && pos.Active() {
+				r.exit(x)
+			}
+		}
+		if inOrder && r.atr != nil {
+			r.atr.Add(x.OHLCV)
+		}
+		if pos := r.State.Position; live && inOrder && pos != nil && pos.Active() {
+			var atr float64
+			if r.atr != nil {
+				atr = r.atr.Value()
+			}
+			r.State.Tracker.Update(x.OHLCV, atr)
+		}
+
+		r.Strategy.AddTick(x)
+		if s := r.Strategy.Signal(); s.Action != r.State.Last {
+			r.State.Last = s.Action
+			if pos := r.State.Position; live && pos != nil && pos.Active() && s.Action.Closes(pos.Direction, r.Reverse) {
+				r.close()
+			}
+			if live && s.Action != strategy.None {
+				r.open(x, s.Action)
+			}
+		}
+
+		if live {
+			r.State.LastTime, liveTime = closeTime, closeTime
+			r.sync()
+			if err := r.save(); err != nil {
+				log.Printf("live: saving state: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// update sets price of bar x on paper & risk manager brokers.
+func (r *Runner) update(sym string, x trading.Tick) {
+	b := r.Broker
+	if rm, ok := b.(*trading.RiskManager); ok {
+		rm.Update(x.Timestamp.T().Add(x.Timeframe.ToDuration()), r.Base, x.Close)
+		b = rm.Broker
+	}
+	if pb, ok := b.(*trading.PaperTrading); ok {
+		pb.UpdateSymbol(sym, x.OHLCV)
+	}
+	if pos := r.State.Position; pos != nil {
+		pos.SetTick(x.OHLCV)
+	}
+}
+
+// open opens a position on action, unless one is active.
+func (r *Runner) open(x trading.Tick, action strategy.Action) {
+	if pos := r.State.Position; pos != nil && pos.Active() {
+		return
+	}
+	if action != strategy.Buy && !(action == strategy.Sell && r.Shorts) {
+		return
+	}
+	direction := trading.Direction(action == strategy.Buy)
+	pos := trading.NewPosition(r.Broker, r.Base, r.Quote, direction)
+	pos.SetTick(x.OHLCV)
+	fn := pos.MarketBuy
+	if direction == trading.Short {
+		fn = pos.MarketSell
+	}
+	if err := fn(r.Funds / x.Close); err != nil {
+		log.Printf("live: open %s%s: %s", r.Base, r.Quote, err)
+		return
+	}
+	r.State.Position, r.applied = pos, 0
+	r.sync()
+	r.State.Tracker = trading.NewTracker(r.Profile, direction, pos.AvgEntry, pos.OpenTime)
+	if rm, ok := r.Broker.(*trading.RiskManager); ok {
+		rm.Track(pos)
+	}
+	log.Printf("live: opened %s%s %s", r.Base, r.Quote, pos)
+}
+
+// exit reduces or closes the position when take profit, stop or
+// max holding time are reached on bar x close.
+func (r *Runner) exit(x trading.Tick) {
+	pos, tr := r.State.Position, r.State.Tracker
+	var q float64
+	if tr.TakeProfit > 0 || len(tr.Ladder) > 0 {
+		if tp, fraction := tr.Target(); tr.Reached(x.Close, tp) {
+			q = fraction * pos.Total
+			tr.Steps++
+		}
+	}
+	if tr.Stopped(x.Close) || tr.Expired(x.Timestamp.T()) {
+		q = pos.Total - pos.Traded
+	}
+	if q <= 0 {
+		return
+	}
+	if err := pos.Reduce(q); err != nil {
+		log.Printf("live: exit %s%s: %s", r.Base, r.Quote, err)
+		return
+	}
+	if !pos.Active() {
+		log.Printf("live: closed %s%s %s", r.Base, r.Quote, pos)
+	}
+}
+
+// close closes the position on a signal.
+func (r *Runner) close() {
+	pos := r.State.Position
+	if err := pos.Close(); err != nil {
+		log.Printf("live: close %s%s: %s", r.Base, r.Quote, err)
+		return
+	}
+	r.sync()
+	log.Printf("live: closed %s%s on signal %s", r.Base, r.Quote, pos)
+}
+
+// sync applies new fills of the position to Account, unless trading on paper.
+func (r *Runner) sync() {
+	b := r.Broker
+	if rm, ok := b.(*trading.RiskManager); ok {
+		b = rm.Broker
+	}
+	if _, ok := b.(*trading.PaperTrading); ok || r.Account == nil || r.State.Position == nil {
+		return
+	}
+	pos := r.State.Position
+	for _, t := range pos.Transactions[r.applied:] {
+		if err := r.Account.Apply(t, pos.Base, pos.Quote); err != nil {
+			log.Printf("live: account: %s", err)
+		}
+	}
+	r.applied = len(pos.Transactions)
+}
+
+// load restores State from Store, the position is bound to Broker.
+func (r *Runner) load() error {
+	if r.Store == nil {
+		return nil
+	}
+	var state State
+	if err := r.Store.LoadJSON(r.Key, &state); err != nil {
+		log.Printf("live: no state loaded from %s: %s", r.Key, err)
+		return nil
+	}
+	if pos := state.Position; pos != nil {
+		if pos.Base != r.Base || pos.Quote != r.Quote {
+			return fmt.Errorf("live: state %s holds a %s%s position", r.Key, pos.Base, pos.Quote)
+		}
+		pos.Broker = r.Broker
+		r.applied = len(pos.Transactions)
+		if pos.Active() {
+			if state.Tracker == nil {
+				state.Tracker = trading.NewTracker(r.Profile, pos.Direction, pos.AvgEntry, pos.OpenTime)
+			}
+			if rm, ok := r.Broker.(*trading.RiskManager); ok {
+				rm.Track(pos)
+			}
+			log.Printf("live: resuming %s%s %s", r.Base, r.Quote, pos)
+		}
+	}
+	r.State = state
+	return nil
+}
+
+func (r *Runner) save() error {
+	if r.Store == nil {
+		return nil
+	}
+	data, err := json.Marshal(r.State)
+	if err != nil {
+		return err
+	}
+	return r.Store.SET(r.Key, data)
+}
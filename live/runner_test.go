@@ -0,0 +1,173 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/backtest"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/strategy"
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"testing"
+	"time"
+)
+
+// memStore is an in memory Store.
+type memStore map[string][]byte
+
+func (m memStore) LoadJSON(key string, v interface{}) error {
+	data, ok := m[key]
+	if !ok {
+		return fmt.Errorf("%s not found", key)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m memStore) SET(key string, v interface{}) error {
+	m[key] = v.([]byte)
+	return nil
+}
+
+// scripted emits a fixed action at given tick indexes.
+type scripted struct {
+	actions map[int]strategy.Action
+	n       int
+	last    strategy.Signal
+}
+
+func (s *scripted) AddTick(x trading.Tick) {
+	if a, ok := s.actions[s.n]; ok {
+		s.last = strategy.Signal{Time: x.Timestamp.T(), Action: a}
+	}
+	s.n++
+}
+
+func (s *scripted) Signal() strategy.Signal {
+	return s.last
+}
+
+func hourly(closes ...float64) *backtest.Historical {
+	h := &backtest.Historical{
+		Source: backtest.Source{Base: "ABC", Quote: "BTC", Timeframe: ts.Timeframe{N: 1, Unit: ts.TfHour}},
+	}
+	for i, c := range closes {
+		h.Data = append(h.Data, ts.OHLCV{
+			Timestamp: util.JSONTime(t0.Add(time.Hour * time.Duration(i))),
+			Open:      c, High: c, Low: c, Close: c, Volume: 1,
+		})
+	}
+	return h
+}
+
+func almostEq(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestRunner_Run(t *testing.T) {
+	store := memStore{}
+	r := Runner{
+		Source:   hourly(10, 10, 11, 12, 12),
+		Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+		Broker:   &trading.PaperTrading{},
+		Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+		Base:     "ABC", Quote: "BTC",
+		Funds: 1,
+		Store: store, Key: "state",
+		Start: t0,
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p := r.State.Position
+	if p == nil || p.State != trading.Closed || !almostEq(p.AvgEntry, 10) || !almostEq(p.AvgExit, 12) {
+		t.Fatalf("expected 10 -> 12 position, got %v", p)
+	}
+	var saved State
+	if err := store.LoadJSON("state", &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.LastTime.Equal(t0.Add(5*time.Hour)) || saved.Position.State != trading.Closed {
+		t.Errorf("unexpected saved state: %+v", saved)
+	}
+}
+
+func TestRunner_Resume(t *testing.T) {
+	store := memStore{}
+	run := func(start time.Time, closes ...float64) *Runner {
+		r := &Runner{
+			Source:   hourly(closes...),
+			Strategy: &scripted{actions: map[int]strategy.Action{1: strategy.Buy}},
+			Broker:   &trading.PaperTrading{},
+			Profile:  trading.Profile{TakeProfit: 0.15, StopLoss: 0.1},
+			Base:     "ABC", Quote: "BTC",
+			Funds: 1,
+			Store: store, Key: "state",
+			Start: start,
+		}
+		if err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	r := run(t0, 10, 10, 11)
+	if p := r.State.Position; p == nil || !p.Active() {
+		t.Fatalf("expected an open position, got %v", p)
+	}
+
+	// restart later, missed bars are not traded, the position is resumed
+	r = run(t0.Add(5*time.Hour), 10, 10, 11, 13, 12, 12)
+	p := r.State.Position
+	if p.State != trading.Closed || !almostEq(p.AvgEntry, 10) || !almostEq(p.AvgExit, 12) || len(p.Orders) != 2 {
+		t.Errorf("expected resumed 10 -> 12 position, got %s", p)
+	}
+}
+
+func TestRunner_RunReverse(t *testing.T) {
+	margin := trading.DefaultMargin
+	var closed []*trading.Position
+	r := Runner{
+		Source: hourly(10, 10, 12, 12, 11, 11),
+		Strategy: &scripted{actions: map[int]strategy.Action{
+			1: strategy.Buy, 3: strategy.Sell, 4: strategy.ExitShort,
+		}},
+		Broker:  &trading.PaperTrading{Margin: &margin},
+		Profile: trading.Profile{TakeProfit: 1, StopLoss: 1},
+		Base:    "ABC", Quote: "BTC",
+		Funds:   1,
+		Shorts:  true,
+		Reverse: true,
+		Start:   t0,
+	}
+	r.Strategy = &spy{r.Strategy, &r, &closed}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 2 {
+		t.Fatalf("expected 2 positions, got %d", len(closed))
+	}
+	long, short := closed[0], closed[1]
+	if long.Direction != trading.Long || long.State != trading.Closed || !almostEq(long.AvgExit, 12) {
+		t.Errorf("expected long closed at 12 on sell signal, got %s", long)
+	}
+	if short.Direction != trading.Short || short.State != trading.Closed ||
+		!almostEq(short.AvgEntry, 12) || !almostEq(short.AvgExit, 11) {
+		t.Errorf("expected 12 -> 11 short closed on exit signal, got %s", short)
+	}
+}
+
+// spy records positions of r as they are replaced.
+type spy struct {
+	strategy.Strategy
+	r         *Runner
+	positions *[]*trading.Position
+}
+
+func (s *spy) AddTick(x trading.Tick) {
+	s.Strategy.AddTick(x)
+	if pos := s.r.State.Position; pos != nil {
+		if n := len(*s.positions); n == 0 || (*s.positions)[n-1] != pos {
+			*s.positions = append(*s.positions, pos)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/gorilla/websocket"
+	"github.com/rkjdid/gocx/backtest/scraper/binance"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// StreamURL is binance websocket endpoint.
+	StreamURL = "wss://stream.binance.com:9443"
+
+	// MinBackoff & MaxBackoff bound delays between reconnections, doubled after each failure.
+	MinBackoff = time.Second
+	MaxBackoff = time.Minute
+
+	// streamTimeout closes connections idle for longer, binance pings every 3 minutes.
+	streamTimeout = time.Minute * 5
+)
+
+// Market of a Stream.
+type Market struct {
+	Base, Quote string
+	Symbol      string
+}
+
+// Stream is a live trading.DataSource of binance kline streams of several markets &
+// timeframes. It feeds closed bars only, reconnects with backoff and backfills bars
+// missed while disconnected over REST.
+type Stream struct {
+	// URL is the websocket endpoint, StreamURL if empty. API & Client are used
+	// to backfill, like Klines.
+	URL    string
+	API    string
+	Client *http.Client
+
+	Markets    []Market
+	Timeframes []ts.Timeframe
+	// From is the open time of the first bars fed, history is backfilled on
+	// connection. Only bars closed after connection are fed if zero.
+	From time.Time
+	// Done stops Feed when closed.
+	Done chan struct{}
+
+	// next open time of bars, by symbol & interval
+	next map[string]time.Time
+	// minBackoff is MinBackoff, replaced in tests.
+	minBackoff time.Duration
+}
+
+func NewStream(markets []Market, from time.Time, tfs ...ts.Timeframe) *Stream {
+	return &Stream{Markets: markets, Timeframes: tfs, From: from, Done: make(chan struct{})}
+}
+
+// Stop stops Feed, which closes its channel.
+func (s *Stream) Stop() {
+	close(s.Done)
+}
+
+// Bondaries returns From, and a zero end time as live data has no end.
+func (s *Stream) Bondaries() (from, to time.Time) {
+	return s.From, time.Time{}
+}
+
+// streamKline is the payload of combined kline streams.
+type streamKline struct {
+	Stream string `json:"stream"`
+	Data   struct {
+		Event  string `json:"e"`
+		Symbol string `json:"s"`
+		Kline  struct {
+			OpenTime  int64  `json:"t"`
+			CloseTime int64  `json:"T"`
+			Interval  string `json:"i"`
+			Open      string `json:"o"`
+			Close     string `json:"c"`
+			High      string `json:"h"`
+			Low       string `json:"l"`
+			Volume    string `json:"v"`
+			Closed    bool   `json:"x"`
+		} `json:"k"`
+	} `json:"data"`
+}
+
+func (s *Stream) Feed() <-chan trading.Tick {
+	ch := make(chan trading.Tick)
+	s.next = make(map[string]time.Time)
+	for _, m := range s.Markets {
+		for _, tf := range s.Timeframes {
+			s.next[key(m.Symbol, binance.Interval(tf))] = s.From
+		}
+	}
+	go func() {
+		defer close(ch)
+		min := s.minBackoff
+		if min <= 0 {
+			min = MinBackoff
+		}
+		backoff := min
+		for {
+			received, err := s.serve(ch)
+			if err == nil {
+				// done
+				return
+			}
+			log.Printf("stream: %s", err)
+			if received {
+				backoff = min
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.Done:
+				return
+			}
+			if backoff *= 2; backoff > MaxBackoff {
+				backoff = MaxBackoff
+			}
+		}
+	}()
+	return ch
+}
+
+// url returns the combined streams url of s markets & timeframes.
+func (s *Stream) url() string {
+	base := s.URL
+	if base == "" {
+		base = StreamURL
+	}
+	var streams []string
+	for _, m := range s.Markets {
+		for _, tf := range s.Timeframes {
+			streams = append(streams, strings.ToLower(m.Symbol)+"@kline_"+binance.Interval(tf))
+		}
+	}
+	return base + "/stream?streams=" + strings.Join(streams, "/")
+}
+
+// serve connects, backfills, then feeds ch until the connection fails, or Done is
+// closed in which case err is nil. received is true if a kline was received.
+func (s *Stream) serve(ch chan<- trading.Tick) (received bool, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(s.url(), nil)
+	if err != nil {
+		return false, fmt.Errorf("dial: %s", err)
+	}
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-s.Done:
+		case <-closed:
+		}
+		_ = conn.Close()
+	}()
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(streamTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second*10))
+	})
+
+	// bars closed while disconnected
+	if !s.backfill(ch, s.Markets, s.Timeframes, time.Now()) {
+		return received, nil
+	}
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(streamTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-s.Done:
+				return received, nil
+			default:
+				return received, fmt.Errorf("read: %s", err)
+			}
+		}
+		var msg streamKline
+		if err = json.Unmarshal(data, &msg); err != nil {
+			log.Printf("stream: invalid message %s: %s", data, err)
+			continue
+		}
+		received = true
+		k := msg.Data.Kline
+		if msg.Data.Event != "kline" || !k.Closed {
+			continue
+		}
+		m, tf, ok := s.lookup(msg.Data.Symbol, k.Interval)
+		if !ok {
+			continue
+		}
+		open := time.Unix(0, k.OpenTime*int64(time.Millisecond)).UTC()
+		next := s.next[key(m.Symbol, k.Interval)]
+		if !next.IsZero() && open.After(next) {
+			// missed bars, e.g. of a stalled connection
+			if !s.backfill(ch, []Market{m}, []ts.Timeframe{tf}, open) {
+				return received, nil
+			}
+		}
+		if !next.IsZero() && open.Before(s.next[key(m.Symbol, k.Interval)]) {
+			// already fed
+			continue
+		}
+		var values [5]float64
+		for i, v := range []string{k.Open, k.High, k.Low, k.Close, k.Volume} {
+			if values[i], err = strconv.ParseFloat(v, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("stream: invalid kline %s: %s", data, err)
+			continue
+		}
+		o := ts.OHLCV{
+			Timestamp: util.JSONTime(open),
+			Open:      values[0], High: values[1], Low: values[2], Close: values[3], Volume: values[4],
+		}
+		if !s.send(ch, trading.Tick{Timeframe: tf, Base: m.Base, Quote: m.Quote, OHLCV: o}) {
+			return received, nil
+		}
+	}
+}
+
+// backfill feeds bars of markets on tfs fetched over REST, from the next ones
+// expected to those closed before until, in order of close time like Klines.
+// Bars closing after those a request failed to fetch are left for the next
+// backfill. It returns false if Done was closed.
+func (s *Stream) backfill(ch chan<- trading.Tick, markets []Market, tfs []ts.Timeframe, until time.Time) bool {
+	horizon := until
+	var ticks []trading.Tick
+	for _, m := range markets {
+		for _, tf := range tfs {
+			missed, ok := s.missed(m, tf, until)
+			ticks = append(ticks, missed...)
+			if ok {
+				continue
+			}
+			// open time of the first bar not fetched
+			next := s.next[key(m.Symbol, binance.Interval(tf))]
+			if len(missed) > 0 {
+				next = missed[len(missed)-1].Timestamp.T().Add(tf.ToDuration())
+			}
+			if next.Before(horizon) {
+				horizon = next
+			}
+		}
+	}
+	closeTime := func(x trading.Tick) time.Time {
+		return x.Timestamp.T().Add(x.Timeframe.ToDuration())
+	}
+	sort.SliceStable(ticks, func(a, b int) bool {
+		if ca, cb := closeTime(ticks[a]), closeTime(ticks[b]); !ca.Equal(cb) {
+			return ca.Before(cb)
+		}
+		return ticks[a].Timeframe.Lt(ticks[b].Timeframe)
+	})
+	for _, x := range ticks {
+		if closeTime(x).After(horizon) {
+			continue
+		}
+		if !s.send(ch, x) {
+			return false
+		}
+	}
+	return true
+}
+
+// missed returns bars of m on tf fetched over REST, from the next one expected
+// to those closed before until. ok is false if a request failed.
+func (s *Stream) missed(m Market, tf ts.Timeframe, until time.Time) (ticks []trading.Tick, ok bool) {
+	next := s.next[key(m.Symbol, binance.Interval(tf))]
+	for !next.IsZero() && next.Before(until) {
+		klines, err := binance.FetchKlines(s.Client, s.API, m.Symbol, tf, next, binance.KlinesLimit)
+		if err != nil {
+			log.Printf("stream: backfill %s %s: %s", m.Symbol, tf, err)
+			return ticks, false
+		}
+		fed := 0
+		for _, kl := range klines {
+			if !kl.CloseTime.Before(until) || !kl.CloseTime.Before(time.Now()) {
+				break
+			}
+			ticks = append(ticks, trading.Tick{Timeframe: tf, Base: m.Base, Quote: m.Quote, OHLCV: kl.OHLCV})
+			next = kl.Timestamp.T().Add(tf.ToDuration())
+			fed++
+		}
+		if fed < binance.KlinesLimit {
+			return ticks, true
+		}
+	}
+	return ticks, true
+}
+
+// send feeds x and sets the next bar expected, it returns false if Done was closed.
+func (s *Stream) send(ch chan<- trading.Tick, x trading.Tick) bool {
+	select {
+	case ch <- x:
+	case <-s.Done:
+		return false
+	}
+	s.next[key(s.symbol(x.Base, x.Quote), binance.Interval(x.Timeframe))] = x.Timestamp.T().Add(x.Timeframe.ToDuration())
+	return true
+}
+
+// lookup returns the market & timeframe of a kline of sym on interval.
+func (s *Stream) lookup(sym, interval string) (Market, ts.Timeframe, bool) {
+	for _, m := range s.Markets {
+		if m.Symbol != sym {
+			continue
+		}
+		for _, tf := range s.Timeframes {
+			if binance.Interval(tf) == interval {
+				return m, tf, true
+			}
+		}
+	}
+	return Market{}, ts.Timeframe{}, false
+}
+
+func (s *Stream) symbol(base, quote string) string {
+	for _, m := range s.Markets {
+		if m.Base == base && m.Quote == quote {
+			return m.Symbol
+		}
+	}
+	return ""
+}
+
+func key(sym, interval string) string {
+	return sym + "@" + interval
+}
@@ -0,0 +1,104 @@
+package live
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/rkjdid/gocx/ts"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// klineMessage returns a combined stream message of ABCBTC 1h kline opened at hour h.
+func klineMessage(h int, price float64, closed bool) string {
+	open := t0.Add(time.Hour*time.Duration(h)).UnixNano() / int64(time.Millisecond)
+	return fmt.Sprintf(`{"stream":"abcbtc@kline_1h","data":{"e":"kline","s":"ABCBTC","k":`+
+		`{"t":%d,"T":%d,"i":"1h","o":"%[3]g","c":"%[3]g","h":"%[3]g","l":"%[3]g","v":"1","x":%[4]t}}}`,
+		open, open+int64(time.Hour/time.Millisecond)-1, price, closed)
+}
+
+// wsServer sends messages of a connection and closes it, for each connection,
+// to clients of streams.
+func wsServer(t *testing.T, streams string, connections ...[]string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	var n int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("streams") != streams {
+			t.Errorf("unexpected streams: %s", r.URL.RawQuery)
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		i := int(atomic.AddInt32(&n, 1)) - 1
+		if i >= len(connections) {
+			// keep the last connection open
+			_, _, _ = conn.ReadMessage()
+			return
+		}
+		for _, msg := range connections[i] {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Error(err)
+			}
+		}
+	}))
+}
+
+func TestStream_Feed(t *testing.T) {
+	rest := klinesServer(t, []float64{1, 2, 3, 4, 5})
+	defer rest.Close()
+	ws := wsServer(t, "abcbtc@kline_1h",
+		[]string{klineMessage(0, 1, true), klineMessage(1, 2, false)},
+		// hours 1 to 4 are backfilled on reconnection
+		[]string{klineMessage(3, 4, true), klineMessage(5, 6, true)},
+	)
+	defer ws.Close()
+
+	s := NewStream([]Market{{"ABC", "BTC", "ABCBTC"}}, time.Time{}, ts.Timeframe{N: 1, Unit: ts.TfHour})
+	s.URL, s.API, s.minBackoff = "ws"+strings.TrimPrefix(ws.URL, "http"), rest.URL, time.Millisecond
+
+	var got []string
+	for x := range s.Feed() {
+		if x.Base != "ABC" || x.Quote != "BTC" {
+			t.Errorf("unexpected market %s%s", x.Base, x.Quote)
+		}
+		got = append(got, fmt.Sprintf("%d:%g", x.Timestamp.T().Hour(), x.Close))
+		if len(got) == 6 {
+			s.Stop()
+		}
+	}
+	expected := fmt.Sprint([]string{"0:1", "1:2", "2:3", "3:4", "4:5", "5:6"})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s, got %v", expected, got)
+	}
+}
+
+func TestStream_FeedBackfillOrder(t *testing.T) {
+	rest := klinesServer(t, []float64{1, 2, 3, 4, 5})
+	defer rest.Close()
+	// no kline streamed, history is backfilled on connection
+	ws := wsServer(t, "abcbtc@kline_1h/abcbtc@kline_2h", nil)
+	defer ws.Close()
+
+	hour, h2 := ts.Timeframe{N: 1, Unit: ts.TfHour}, ts.Timeframe{N: 2, Unit: ts.TfHour}
+	s := NewStream([]Market{{"ABC", "BTC", "ABCBTC"}}, t0, hour, h2)
+	s.URL, s.API, s.minBackoff = "ws"+strings.TrimPrefix(ws.URL, "http"), rest.URL, time.Millisecond
+
+	var got []string
+	for x := range s.Feed() {
+		got = append(got, fmt.Sprintf("%dh@%d", x.Timeframe.N, x.Timestamp.T().Hour()))
+		if len(got) == 8 {
+			s.Stop()
+		}
+	}
+	// by close time, the fastest timeframe first
+	expected := fmt.Sprint([]string{"1h@0", "1h@1", "2h@0", "1h@2", "1h@3", "2h@2", "1h@4", "2h@4"})
+	if fmt.Sprint(got) != expected {
+		t.Errorf("expected %s, got %v", expected, got)
+	}
+}
@@ -0,0 +1,219 @@
+package trading
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInsufficientFunds is returned when an order or a fill exceeds available funds.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Funds of an asset, Locked is reserved by open orders.
+type Funds struct {
+	Free   float64
+	Locked float64
+}
+
+func (f Funds) Total() float64 {
+	return f.Free + f.Locked
+}
+
+// Reservation is an amount of Asset locked for open orders.
+type Reservation struct {
+	Asset  string
+	Amount float64
+}
+
+// Account is a ledger of funds per asset. It locks funds reserved by open orders,
+// and applies fills & their fees to the right assets.
+type Account struct {
+	Funds map[string]Funds
+	// Reservations by order id, orders of an oco pair share theirs.
+	Reservations map[string]*Reservation
+	// Borrowed holds margin loans by asset, borrowed funds are added to Funds.
+	Borrowed map[string]float64
+}
+
+func NewAccount() *Account {
+	return &Account{
+		Funds:        map[string]Funds{},
+		Reservations: map[string]*Reservation{},
+	}
+}
+
+func (a *Account) init() {
+	if a.Funds == nil {
+		a.Funds = map[string]Funds{}
+	}
+	if a.Reservations == nil {
+		a.Reservations = map[string]*Reservation{}
+	}
+	if a.Borrowed == nil {
+		a.Borrowed = map[string]float64{}
+	}
+}
+
+func (a Account) String() string {
+	s := ""
+	for asset, f := range a.Funds {
+		s += fmt.Sprintf("%s: %f (%f locked)\n", asset, f.Total(), f.Locked)
+	}
+	for asset, q := range a.Borrowed {
+		s += fmt.Sprintf("%s: %f borrowed\n", asset, q)
+	}
+	return s
+}
+
+func (a Account) Free(asset string) float64 {
+	return a.Funds[asset].Free
+}
+
+func (a Account) Locked(asset string) float64 {
+	return a.Funds[asset].Locked
+}
+
+func (a Account) Total(asset string) float64 {
+	return a.Funds[asset].Total()
+}
+
+// Deposit adds q of asset to free funds, q can be negative for withdrawals.
+func (a *Account) Deposit(asset string, q float64) {
+	a.init()
+	f := a.Funds[asset]
+	f.Free += q
+	a.Funds[asset] = f
+}
+
+// Debt returns the borrowed quantity of asset.
+func (a Account) Debt(asset string) float64 {
+	return a.Borrowed[asset]
+}
+
+// Borrow adds q of asset to free funds, as a loan to be repaid.
+func (a *Account) Borrow(asset string, q float64) {
+	a.init()
+	a.Deposit(asset, q)
+	a.Borrowed[asset] += q
+}
+
+// Repay pays back q of asset loan from free funds, q is capped to the debt.
+func (a *Account) Repay(asset string, q float64) error {
+	a.init()
+	q = math.Min(q, a.Borrowed[asset])
+	if !enough(a.Free(asset), q) {
+		return ErrInsufficientFunds
+	}
+	a.Deposit(asset, -q)
+	if a.Borrowed[asset] -= q; a.Borrowed[asset] <= 1e-12 {
+		delete(a.Borrowed, asset)
+	}
+	return nil
+}
+
+// Lock moves q of asset from free to locked funds, e.g. as futures margin.
+func (a *Account) Lock(asset string, q float64) error {
+	a.init()
+	f := a.Funds[asset]
+	if !enough(f.Free, q) {
+		return ErrInsufficientFunds
+	}
+	f.Free -= q
+	f.Locked += q
+	a.Funds[asset] = f
+	return nil
+}
+
+// Unlock moves q of asset from locked back to free funds.
+func (a *Account) Unlock(asset string, q float64) {
+	a.init()
+	f := a.Funds[asset]
+	f.Free += q
+	f.Locked -= q
+	a.Funds[asset] = f
+}
+
+// Reserve locks q of asset for orders ids, or returns ErrInsufficientFunds.
+func (a *Account) Reserve(asset string, q float64, ids ...string) error {
+	a.init()
+	f := a.Funds[asset]
+	if !enough(f.Free, q) {
+		return ErrInsufficientFunds
+	}
+	f.Free -= q
+	f.Locked += q
+	a.Funds[asset] = f
+	r := &Reservation{Asset: asset, Amount: q}
+	for _, id := range ids {
+		a.Reservations[id] = r
+	}
+	return nil
+}
+
+// Release unlocks what is left of order id reservation.
+func (a *Account) Release(id string) {
+	r, ok := a.Reservations[id]
+	if !ok {
+		return
+	}
+	f := a.Funds[r.Asset]
+	f.Free += r.Amount
+	f.Locked -= r.Amount
+	a.Funds[r.Asset] = f
+	for k, v := range a.Reservations {
+		if v == r {
+			delete(a.Reservations, k)
+		}
+	}
+}
+
+// Apply moves funds of fill t of symbol base/quote, using its order reservation
+// first. Commission is taken from t.CommissionAsset, quote if unset.
+// Nothing is applied if funds are insufficient.
+func (a *Account) Apply(t *Transaction, base, quote string) error {
+	a.init()
+	spent, received := quote, base
+	spend, receive := t.Quantity*t.Price, t.Quantity
+	if t.Direction == Sell {
+		spent, received = base, quote
+		spend, receive = t.Quantity, t.Quantity*t.Price
+	}
+	feeAsset := t.CommissionAsset
+	if feeAsset == "" {
+		feeAsset = quote
+	}
+
+	// amounts due per asset, fees on received asset are taken from proceeds
+	due := map[string]float64{spent: spend}
+	due[feeAsset] += t.Commission
+	due[received] -= receive
+
+	r := a.Reservations[t.OrderId]
+	var fromReservation float64
+	for asset, q := range due {
+		available := a.Free(asset)
+		if r != nil && r.Asset == asset {
+			fromReservation = math.Max(0, math.Min(q, r.Amount))
+			available += fromReservation
+		}
+		if !enough(available, q) {
+			return ErrInsufficientFunds
+		}
+	}
+	for asset, q := range due {
+		f := a.Funds[asset]
+		if r != nil && r.Asset == asset {
+			f.Locked -= fromReservation
+			r.Amount -= fromReservation
+			q -= fromReservation
+		}
+		f.Free -= q
+		a.Funds[asset] = f
+	}
+	return nil
+}
+
+// enough returns true if available covers q, tolerating float rounding.
+func enough(available, q float64) bool {
+	return q <= available+1e-9*math.Max(1, math.Abs(available))
+}
@@ -0,0 +1,92 @@
+package trading
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"testing"
+)
+
+func almostEq(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestAccount_Apply(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	buy := &Transaction{Direction: Buy, Quantity: 10, Price: 0.05, Commission: 0.01, CommissionAsset: "ABC"}
+	if err := a.Apply(buy, "ABC", "BTC"); err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(a.Free("BTC"), 0.5) || !almostEq(a.Free("ABC"), 9.99) {
+		t.Errorf("expected 0.5 BTC & 9.99 ABC, got %s", a)
+	}
+	sell := &Transaction{Direction: Sell, Quantity: 10, Price: 0.06}
+	if err := a.Apply(sell, "ABC", "BTC"); err != ErrInsufficientFunds {
+		t.Errorf("expected insufficient funds, got %v", err)
+	}
+	if !almostEq(a.Free("BTC"), 0.5) {
+		t.Errorf("rejected fill should not move funds, got %s", a)
+	}
+	sell.Quantity, sell.Commission = 9.99, 0.001
+	if err := a.Apply(sell, "ABC", "BTC"); err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(a.Free("BTC"), 0.5+9.99*0.06-0.001) || !almostEq(a.Total("ABC"), 0) {
+		t.Errorf("unexpected funds after sell: %s", a)
+	}
+}
+
+func TestPaperTrading_Account(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a, FeesRate: 0.01}
+	pt.Update(ts.OHLCV{Close: 0.1})
+	buy := Order{Symbol: "ABCBTC", Base: "ABC", Quote: "BTC", Direction: Buy, Type: MarketOrder}
+
+	buy.Quantity = 10
+	if _, err := pt.PlaceOrder(buy); err != ErrInsufficientFunds {
+		t.Errorf("expected order exceeding funds with fees to be rejected, got %v", err)
+	}
+	buy.Quantity = 5
+	if _, err := pt.PlaceOrder(buy); err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(a.Free("BTC"), 0.495) || a.Free("ABC") != 5 {
+		t.Errorf("unexpected funds after buy: %s", a)
+	}
+
+	// resting orders lock funds until filled or cancelled
+	limit := buy
+	limit.Type, limit.Quantity, limit.Price = LimitOrder, 4, 0.09
+	o, err := pt.PlaceOrder(limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(a.Locked("BTC"), 4*0.09*1.01) {
+		t.Errorf("expected limit order funds locked, got %s", a)
+	}
+	if _, err := pt.PlaceOrder(limit); err != ErrInsufficientFunds {
+		t.Errorf("expected second limit order to exceed free funds, got %v", err)
+	}
+	if _, err := pt.CancelOrder("ABCBTC", o.Id); err != nil {
+		t.Fatal(err)
+	}
+	if a.Locked("BTC") != 0 || !almostEq(a.Free("BTC"), 0.495) {
+		t.Errorf("expected funds unlocked on cancel, got %s", a)
+	}
+
+	// oco legs share locked base
+	limit.Direction, limit.Quantity, limit.Price = Sell, 5, 0.12
+	stop := limit
+	stop.Type, stop.Price, stop.StopPrice = StopOrder, 0, 0.08
+	if _, err := pt.PlaceOCO(limit, stop); err != nil {
+		t.Fatal(err)
+	}
+	if a.Locked("ABC") != 5 || a.Free("ABC") != 0 {
+		t.Errorf("expected oco to lock 5 ABC once, got %s", a)
+	}
+	pt.Update(ts.OHLCV{Open: 0.1, High: 0.13, Low: 0.1, Close: 0.12})
+	if a.Total("ABC") != 0 || !almostEq(a.Free("BTC"), 0.495+0.6*0.99) || len(a.Reservations) != 0 {
+		t.Errorf("expected take profit filled & reservation released, got %s", a)
+	}
+}
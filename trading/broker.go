@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/rkjdid/gocx/ts"
+	"log"
+	"math"
+	"strconv"
 	"time"
 )
 
@@ -11,6 +14,15 @@ type Broker interface {
 	MarketBuy(sym string, q float64) ([]*Transaction, error)
 	MarketSell(sym string, q float64) ([]*Transaction, error)
 
+	// PlaceOrder sends o and returns it as acknowledged by the broker.
+	PlaceOrder(o Order) (*Order, error)
+	// PlaceOCO sends limit & stop as a one-cancels-the-other pair, see ValidateOCO.
+	PlaceOCO(limit, stop Order) ([]*Order, error)
+	CancelOrder(sym, id string) (*Order, error)
+	GetOrder(sym, id string) (*Order, error)
+	// FindOrder returns order by its client id, or ErrOrderNotFound.
+	FindOrder(sym, clientId string) (*Order, error)
+
 	Snapshot() (*Snapshot, error)
 	Name() string
 	Symbol(base, quote string) string
@@ -20,30 +32,77 @@ type PaperTrading struct {
 	FeesRate float64
 	Time     time.Time
 	Price    float64
+	// Slippage, when set, adjusts Price of market orders.
+	Slippage Slippage
+	// Symbols, when set, rounds & rejects orders like the exchange would.
+	Symbols Symbols
+	// Account, when set, holds funds of orders placed with PlaceOrder & PlaceOCO,
+	// orders exceeding available funds are rejected.
+	Account *Account
+	// Margin, when set with Account, lets sells borrow missing funds.
+	Margin *Margin
+	// Perpetuals, when set with Account, makes orders trade perpetual futures.
+	Perpetuals *Perpetuals
+
+	bar ts.OHLCV
+	// orders holds every order placed, resting ones are matched on Update.
+	orders  map[string]*Order
+	resting []*Order
+	lastId  int
+	// loans by symbol, see Margin.
+	loans map[string]*loan
+	// contracts by symbol, see Perpetuals.
+	contracts map[string]*contract
 }
 
 func (p PaperTrading) MarketBuy(sym string, q float64) ([]*Transaction, error) {
-	return []*Transaction{
-		{
-			Direction:  Buy,
-			Quantity:   q,
-			Price:      p.Price,
-			Time:       p.Time,
-			Commission: p.FeesRate * (q * p.Price),
-		},
-	}, nil
+	return p.market(sym, Buy, q)
 }
 
 func (p PaperTrading) MarketSell(sym string, q float64) ([]*Transaction, error) {
-	return []*Transaction{
-		{
-			Direction:  Sell,
-			Quantity:   q,
-			Price:      p.Price,
-			Time:       p.Time,
-			Commission: p.FeesRate * (q * p.Price),
-		},
-	}, nil
+	return p.market(sym, Sell, q)
+}
+
+func (p PaperTrading) market(sym string, direction Direction, q float64) ([]*Transaction, error) {
+	o, err := p.filter(Order{Symbol: sym, Direction: direction, Type: MarketOrder, Quantity: q})
+	if err != nil {
+		return nil, err
+	}
+	return []*Transaction{p.fill(direction, o.Quantity)}, nil
+}
+
+// filter applies Symbols rules to o, if any.
+func (p PaperTrading) filter(o Order) (Order, error) {
+	if info, ok := p.Symbols.Get(o.Symbol); ok {
+		return info.Apply(o, p.Price)
+	}
+	return o, nil
+}
+
+func (p PaperTrading) fill(direction Direction, q float64) *Transaction {
+	return p.transaction(direction, q, p.slip(direction, q, p.Price))
+}
+
+// FillPrice returns the price a market order of q units would fill at.
+func (p PaperTrading) FillPrice(direction Direction, q float64) float64 {
+	return p.slip(direction, q, p.Price)
+}
+
+func (p PaperTrading) slip(direction Direction, q, price float64) float64 {
+	if p.Slippage != nil {
+		return p.Slippage.Slip(direction, q, price, p.bar)
+	}
+	return price
+}
+
+func (p PaperTrading) transaction(direction Direction, q, price float64) *Transaction {
+	return &Transaction{
+		Direction:  direction,
+		Quantity:   q,
+		Price:      price,
+		Time:       p.Time,
+		Commission: p.FeesRate * (q * price),
+	}
 }
 
 func (p PaperTrading) Symbol(base, quote string) string {
@@ -59,6 +118,299 @@ func (p PaperTrading) Name() string {
 }
 
 func (p *PaperTrading) Update(o ts.OHLCV) {
+	p.UpdateSymbol("", o)
+}
+
+// UpdateSymbol is Update for bar o of sym, only orders, loans & contracts of sym
+// are matched & charged. Empty sym matches all of them.
+func (p *PaperTrading) UpdateSymbol(sym string, o ts.OHLCV) {
 	p.Time = o.Timestamp.T()
 	p.Price = o.Close
+	p.bar = o
+	p.match(sym, o)
+	p.marginCall(sym, o)
+	p.settleContracts(sym, o)
+}
+
+// SetPrice sets time & price of next fills, e.g. to fill a stop at its trigger price.
+func (p *PaperTrading) SetPrice(t time.Time, price float64) {
+	p.Time = t
+	p.Price = price
+}
+
+func (p *PaperTrading) PlaceOrder(o Order) (*Order, error) {
+	o, err := p.filter(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	order := p.add(o)
+	switch {
+	case o.Type == MarketOrder:
+		err = p.fillOrder(order, p.slip(o.Direction, o.Quantity, p.Price))
+	case p.Price <= 0:
+		// no price yet, orders rest until next Update
+	case o.Type == LimitOrder:
+		// marketable limit orders fill right away at current price
+		if o.Direction == Buy && p.Price <= o.Price || o.Direction == Sell && p.Price >= o.Price {
+			err = p.fillOrder(order, p.Price)
+		}
+	case o.Type == StopOrder || o.Type == StopLimitOrder:
+		if o.Direction == Buy && p.Price >= o.StopPrice || o.Direction == Sell && p.Price <= o.StopPrice {
+			err = fmt.Errorf("stop order %s would trigger immediately", order.Id)
+		}
+	}
+	if err == nil && order.Open() && o.Type == LimitOrder && order.TimeInForce != GTC {
+		// paper fills are never partial, IOC & FOK behave the same
+		_ = order.SetStatus(OrderExpired)
+	}
+	if err == nil && order.Open() {
+		err = p.reserve(order)
+	}
+	if err != nil {
+		_ = order.SetStatus(OrderRejected)
+		return order.copy(), err
+	}
+	if order.Open() {
+		p.resting = append(p.resting, order)
+	}
+	return order.copy(), nil
+}
+
+// PlaceOCO places stop before limit, so that bars touching both levels fill
+// the stop, like backtest.Pessimistic.
+func (p *PaperTrading) PlaceOCO(limit, stop Order) ([]*Order, error) {
+	limit, err := p.filter(limit)
+	if err != nil {
+		return nil, err
+	}
+	if stop, err = p.filter(stop); err != nil {
+		return nil, err
+	}
+	if err := ValidateOCO(limit, stop); err != nil {
+		return nil, err
+	}
+	if p.Price > 0 {
+		if limit.Direction == Buy && (limit.Price >= p.Price || stop.StopPrice <= p.Price) ||
+			limit.Direction == Sell && (limit.Price <= p.Price || stop.StopPrice >= p.Price) {
+			return nil, fmt.Errorf("oco: price %f is not between limit %f & stop %f",
+				p.Price, limit.Price, stop.StopPrice)
+		}
+	}
+	s, l := p.add(stop), p.add(limit)
+	s.OCO, l.OCO = l.Id, s.Id
+	if err := p.reserve(s, l); err != nil {
+		_ = s.SetStatus(OrderRejected)
+		_ = l.SetStatus(OrderRejected)
+		return nil, err
+	}
+	p.resting = append(p.resting, s, l)
+	return []*Order{l.copy(), s.copy()}, nil
+}
+
+func (p *PaperTrading) CancelOrder(sym, id string) (*Order, error) {
+	o, ok := p.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	if !o.Open() {
+		return nil, fmt.Errorf("order %s is %s", id, o.Status)
+	}
+	_ = o.SetStatus(OrderCancelled)
+	if other, ok := p.orders[o.OCO]; ok && other.Open() {
+		_ = other.SetStatus(OrderCancelled)
+	}
+	p.release(o)
+	return o.copy(), nil
+}
+
+func (p *PaperTrading) GetOrder(sym, id string) (*Order, error) {
+	o, ok := p.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	return o.copy(), nil
+}
+
+func (p *PaperTrading) FindOrder(sym, clientId string) (*Order, error) {
+	for _, o := range p.orders {
+		if o.ClientId == clientId {
+			return o.copy(), nil
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+func (p *PaperTrading) add(o Order) *Order {
+	if p.orders == nil {
+		p.orders = make(map[string]*Order)
+	}
+	p.lastId++
+	o.Id = strconv.Itoa(p.lastId)
+	o.Status = OrderNew
+	o.Time = p.Time
+	o.Transactions = nil
+	if o.ClientId == "" {
+		o.ClientId = "paper-" + o.Id
+	}
+	if o.TimeInForce == "" {
+		o.TimeInForce = GTC
+	}
+	p.orders[o.Id] = &o
+	return &o
+}
+
+// fillOrder fills remaining quantity of o at price, unless Account funds are insufficient.
+func (p *PaperTrading) fillOrder(o *Order, price float64) error {
+	t := p.transaction(o.Direction, o.Remaining(), price)
+	t.Id, _ = strconv.Atoi(o.Id)
+	t.OrderId = o.Id
+	t.CommissionAsset = o.Quote
+	if p.Account != nil && p.Perpetuals != nil {
+		if err := p.fillContract(o, t); err != nil {
+			return err
+		}
+	} else if p.Account != nil {
+		base, quote, err := p.assets(o)
+		if err != nil {
+			return err
+		}
+		t.CommissionAsset = quote
+		_, reserved := p.Account.Reservations[o.Id]
+		if p.Margin != nil && o.Direction == Sell && !reserved {
+			if err := p.borrow(o, t.Quantity); err != nil {
+				return err
+			}
+		}
+		if err := p.Account.Apply(t, base, quote); err != nil {
+			return err
+		}
+		if p.Margin != nil && o.Direction == Buy {
+			p.repay(o)
+		}
+	}
+	// fills of whole remaining quantity never fail
+	_ = o.Fill(t)
+	if other, ok := p.orders[o.OCO]; ok && other.Open() {
+		_ = other.SetStatus(OrderCancelled)
+	}
+	p.release(o)
+	return nil
+}
+
+// reserve locks Account funds needed by orders, which share the reservation.
+// Margin of perpetuals is only checked on fills.
+func (p *PaperTrading) reserve(orders ...*Order) error {
+	if p.Account == nil || p.Perpetuals != nil || len(orders) == 0 {
+		return nil
+	}
+	base, quote, err := p.assets(orders[0])
+	if err != nil {
+		return err
+	}
+	var q, cost float64
+	var ids []string
+	for _, o := range orders {
+		price := o.Price
+		if price == 0 {
+			price = o.StopPrice
+		}
+		q = math.Max(q, o.Quantity)
+		cost = math.Max(cost, o.Quantity*price*(1+p.FeesRate))
+		ids = append(ids, o.Id)
+	}
+	if orders[0].Direction == Buy {
+		return p.Account.Reserve(quote, cost, ids...)
+	}
+	if p.Margin != nil {
+		if err := p.borrow(orders[0], q); err != nil {
+			return err
+		}
+	}
+	return p.Account.Reserve(base, q, ids...)
+}
+
+// release unlocks Account funds of o.
+func (p *PaperTrading) release(o *Order) {
+	if p.Account != nil {
+		p.Account.Release(o.Id)
+	}
+}
+
+// assets returns base & quote assets of o, from o itself or Symbols.
+func (p PaperTrading) assets(o *Order) (base, quote string, err error) {
+	if o.Base != "" && o.Quote != "" {
+		return o.Base, o.Quote, nil
+	}
+	if info, ok := p.Symbols.Get(o.Symbol); ok && info.Base != "" && info.Quote != "" {
+		return info.Base, info.Quote, nil
+	}
+	return "", "", fmt.Errorf("unknown assets of symbol %s", o.Symbol)
+}
+
+// match fills resting orders of sym reached by bar o, all of them if sym is empty.
+func (p *PaperTrading) match(sym string, o ts.OHLCV) {
+	var resting []*Order
+	for _, order := range p.resting {
+		if !order.Open() {
+			continue
+		}
+		if sym != "" && order.Symbol != sym {
+			resting = append(resting, order)
+			continue
+		}
+		if price, ok := p.matchPrice(order, o); ok {
+			if err := p.fillOrder(order, price); err != nil {
+				log.Printf("paper: order %s expired: %s", order.Id, err)
+				_ = order.SetStatus(OrderExpired)
+				p.release(order)
+			}
+			continue
+		}
+		resting = append(resting, order)
+	}
+	p.resting = resting
+}
+
+// matchPrice returns the fill price of order during bar o, if any.
+// Bars opening beyond a level fill at Open.
+func (p *PaperTrading) matchPrice(order *Order, o ts.OHLCV) (float64, bool) {
+	buy := order.Direction == Buy
+	switch order.Type {
+	case StopOrder:
+		if buy && o.High >= order.StopPrice {
+			return p.slip(Buy, order.Quantity, math.Max(order.StopPrice, o.Open)), true
+		} else if !buy && o.Low <= order.StopPrice {
+			return p.slip(Sell, order.Quantity, math.Min(order.StopPrice, o.Open)), true
+		}
+		return 0, false
+	case StopLimitOrder:
+		if !order.triggered {
+			if buy && o.High < order.StopPrice || !buy && o.Low > order.StopPrice {
+				return 0, false
+			}
+			order.triggered = true
+			// on the trigger bar, the limit is only reached once the stop is
+			if buy && math.Max(order.StopPrice, o.Open) <= order.Price {
+				return math.Max(order.StopPrice, o.Open), true
+			} else if !buy && math.Min(order.StopPrice, o.Open) >= order.Price {
+				return math.Min(order.StopPrice, o.Open), true
+			}
+			// triggered beyond the limit, which rests from there. The bar only
+			// reaches it after the trigger if it opened through the stop.
+			gapped := buy && o.Open >= order.StopPrice || !buy && o.Open <= order.StopPrice
+			if gapped && (buy && o.Low <= order.Price || !buy && o.High >= order.Price) {
+				return order.Price, true
+			}
+			return 0, false
+		}
+	}
+	if buy && o.Low <= order.Price {
+		return math.Min(order.Price, o.Open), true
+	} else if !buy && o.High >= order.Price {
+		return math.Max(order.Price, o.Open), true
+	}
+	return 0, false
 }
@@ -0,0 +1,128 @@
+package trading
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"testing"
+)
+
+func TestPaperTrading_PlaceOrder(t *testing.T) {
+	pt := &PaperTrading{}
+	pt.Update(ts.OHLCV{Open: 10, High: 10, Low: 10, Close: 10})
+
+	limit, err := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: LimitOrder, Quantity: 1, Price: 9})
+	if err != nil || !limit.Open() {
+		t.Fatalf("expected resting limit order, got %v, %v", limit, err)
+	}
+	ioc, err := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: LimitOrder, Quantity: 1, Price: 9, TimeInForce: IOC})
+	if err != nil || ioc.Status != OrderExpired {
+		t.Errorf("expected expired IOC order, got %v, %v", ioc, err)
+	}
+	marketable, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: LimitOrder, Quantity: 1, Price: 11})
+	if marketable.Status != OrderFilled || marketable.Transactions[0].Price != 10 {
+		t.Errorf("expected marketable limit filled at 10, got %v", marketable)
+	}
+	if _, err := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: StopOrder, Quantity: 1, StopPrice: 11}); err == nil {
+		t.Errorf("expected sell stop above price to be rejected")
+	}
+	stop, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: StopOrder, Quantity: 1, StopPrice: 8})
+	cancelled, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: LimitOrder, Quantity: 1, Price: 12})
+	if o, err := pt.CancelOrder("ABCBTC", cancelled.Id); err != nil || o.Status != OrderCancelled {
+		t.Errorf("expected cancelled order, got %v, %v", o, err)
+	}
+
+	// gaps down through limit & stop, both fill at open
+	pt.Update(ts.OHLCV{Open: 7, High: 12.5, Low: 6, Close: 7})
+	if o, _ := pt.GetOrder("ABCBTC", limit.Id); o.Status != OrderFilled || o.Transactions[0].Price != 7 {
+		t.Errorf("expected limit filled at 7, got %v", o)
+	}
+	if o, _ := pt.GetOrder("ABCBTC", stop.Id); o.Status != OrderFilled || o.Transactions[0].Price != 7 {
+		t.Errorf("expected stop filled at 7, got %v", o)
+	}
+	if o, _ := pt.GetOrder("ABCBTC", cancelled.Id); o.Status != OrderCancelled || len(o.Transactions) != 0 {
+		t.Errorf("expected cancelled order not to fill, got %v", o)
+	}
+}
+
+func TestPaperTrading_StopLimit(t *testing.T) {
+	pt := &PaperTrading{}
+	pt.Update(ts.OHLCV{Close: 10})
+	o, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: StopLimitOrder, Quantity: 1, StopPrice: 9, Price: 8.5})
+
+	// triggers but never trades back up to limit
+	pt.Update(ts.OHLCV{Open: 8, High: 8.2, Low: 7, Close: 8})
+	if o, _ = pt.GetOrder("ABCBTC", o.Id); !o.Open() {
+		t.Fatalf("expected triggered stop-limit to rest, got %v", o)
+	}
+	pt.Update(ts.OHLCV{Open: 8, High: 9, Low: 8, Close: 8.8})
+	if o, _ = pt.GetOrder("ABCBTC", o.Id); o.Status != OrderFilled || o.Transactions[0].Price != 8.5 {
+		t.Errorf("expected stop-limit filled at 8.5, got %v", o)
+	}
+
+	// opens below the stop, fills at the stop rather than at open
+	buy, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: StopLimitOrder, Quantity: 1, StopPrice: 10.5, Price: 10.6})
+	sell, _ := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: StopLimitOrder, Quantity: 1, StopPrice: 8, Price: 7.9})
+	pt.Update(ts.OHLCV{Open: 10, High: 11, Low: 7, Close: 10})
+	if o, _ = pt.GetOrder("ABCBTC", buy.Id); o.Status != OrderFilled || o.Transactions[0].Price != 10.5 {
+		t.Errorf("expected buy stop-limit filled at 10.5, got %v", o)
+	}
+	if o, _ = pt.GetOrder("ABCBTC", sell.Id); o.Status != OrderFilled || o.Transactions[0].Price != 8 {
+		t.Errorf("expected sell stop-limit filled at 8, got %v", o)
+	}
+
+	// gaps above the limit, which is then reached within the bar
+	buy, _ = pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: StopLimitOrder, Quantity: 1, StopPrice: 10.5, Price: 10.6})
+	pt.Update(ts.OHLCV{Open: 11, High: 11, Low: 10, Close: 10})
+	if o, _ = pt.GetOrder("ABCBTC", buy.Id); o.Status != OrderFilled || o.Transactions[0].Price != 10.6 {
+		t.Errorf("expected buy stop-limit filled at its limit, got %v", o)
+	}
+
+	// triggered beyond the limit within the bar, whose low may come first
+	buy, _ = pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: StopLimitOrder, Quantity: 1, StopPrice: 10.5, Price: 10.4})
+	sell, _ = pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Sell, Type: StopLimitOrder, Quantity: 1, StopPrice: 9.5, Price: 9.6})
+	pt.Update(ts.OHLCV{Open: 10, High: 11, Low: 9, Close: 10})
+	if o, _ = pt.GetOrder("ABCBTC", buy.Id); !o.Open() {
+		t.Errorf("expected buy stop-limit to rest from the next bar, got %v", o)
+	}
+	if o, _ = pt.GetOrder("ABCBTC", sell.Id); !o.Open() {
+		t.Errorf("expected sell stop-limit to rest from the next bar, got %v", o)
+	}
+	pt.Update(ts.OHLCV{Open: 10, High: 10.2, Low: 9.8, Close: 10})
+	if o, _ = pt.GetOrder("ABCBTC", buy.Id); o.Status != OrderFilled || o.Transactions[0].Price != 10 {
+		t.Errorf("expected buy stop-limit filled at open below its limit, got %v", o)
+	}
+	if o, _ = pt.GetOrder("ABCBTC", sell.Id); o.Status != OrderFilled || o.Transactions[0].Price != 10 {
+		t.Errorf("expected sell stop-limit filled at open above its limit, got %v", o)
+	}
+}
+
+func TestPosition_Exits(t *testing.T) {
+	pt := &PaperTrading{}
+	pt.Update(ts.OHLCV{Close: 10})
+	p := NewPosition(pt, "ABC", "BTC", Long)
+	_ = p.MarketBuy(2)
+	if err := p.PlaceExits(9, 11); err == nil {
+		t.Errorf("expected exits on the wrong side of price to fail")
+	}
+	if err := p.PlaceExits(11, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	// touches both levels, stop is assumed first
+	p.SetTick(ts.OHLCV{Open: 10, High: 11.5, Low: 8.5, Close: 10})
+	if p.State != Closed || p.AvgExit != 9 {
+		t.Errorf("expected position stopped at 9, got %s", p)
+	}
+	if p.Exits()[0].Status != OrderCancelled || p.Exits()[1].Status != OrderFilled {
+		t.Errorf("expected take profit cancelled & stop loss filled, got %v %v", p.Exits()[0], p.Exits()[1])
+	}
+
+	p = NewPosition(pt, "ABC", "BTC", Long)
+	_ = p.MarketBuy(1)
+	_ = p.PlaceExits(11, 9)
+	if p.NetOnClose(); !p.Exits()[0].Open() {
+		t.Errorf("NetOnClose should not cancel exits")
+	}
+	if err := p.Close(); err != nil || p.State != Closed || p.Exits()[0].Status != OrderCancelled {
+		t.Errorf("expected exits cancelled on close, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/adshao/go-binance"
+	"github.com/adshao/go-binance/common"
 	"github.com/rkjdid/gocx/trading"
 	"github.com/rkjdid/gocx/util"
 	"log"
@@ -14,22 +15,88 @@ import (
 type Binance struct {
 	*binance.Client
 	Account string
+	// Symbols, when set, is used to round & pre-validate orders, see LoadSymbols.
+	Symbols trading.Symbols
+	// Stream, when set, tracks orders & balances of the account, they are read from
+	// it rather than over REST while it is synced.
+	Stream *UserStream
 }
 
 func NewBinanceBroker(account, key, secret string) *Binance {
-	return &Binance{binance.NewClient(key, secret), account}
+	return &Binance{Client: binance.NewClient(key, secret), Account: account}
 }
 
 func (b Binance) MarketBuy(sym string, q float64) ([]*trading.Transaction, error) {
-	resp, err := b.Client.NewCreateOrderService().
-		Symbol(sym).
-		Type(binance.OrderTypeMarket).
-		Side(binance.SideTypeBuy).
-		Quantity(fmt.Sprintf("%f", q)).Do(context.Background())
+	return b.marketOrder(sym, trading.Buy, q)
+}
+
+func (b Binance) MarketSell(sym string, q float64) ([]*trading.Transaction, error) {
+	return b.marketOrder(sym, trading.Sell, q)
+}
+
+func (b Binance) marketOrder(sym string, direction trading.Direction, q float64) ([]*trading.Transaction, error) {
+	o, err := b.PlaceOrder(trading.Order{
+		Symbol: sym, Direction: direction, Type: trading.MarketOrder, Quantity: q,
+	})
 	if err != nil {
 		return nil, err
 	}
-	var ts []*trading.Transaction
+	return o.Transactions, nil
+}
+
+func (b Binance) PlaceOrder(o trading.Order) (*trading.Order, error) {
+	info, ok := b.Symbols.Get(o.Symbol)
+	if ok {
+		var price float64
+		var err error
+		if o.Type == trading.MarketOrder && info.MinNotional > 0 {
+			if price, err = b.Ticker(o.Symbol); err != nil {
+				log.Printf("PlaceOrder: skipping min notional check: %s", err)
+			}
+		}
+		if o, err = info.Apply(o, price); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.TimeInForce == "" {
+		o.TimeInForce = trading.GTC
+	}
+	s := b.Client.NewCreateOrderService().
+		Symbol(o.Symbol).
+		Side(binanceSide(o.Direction)).
+		Quantity(info.FormatQuantity(o.Quantity)).
+		NewOrderRespType(binance.NewOrderRespTypeFULL)
+	if o.ClientId != "" {
+		s.NewClientOrderID(o.ClientId)
+	}
+	switch o.Type {
+	case trading.MarketOrder:
+		s.Type(binance.OrderTypeMarket)
+	case trading.LimitOrder:
+		s.Type(binance.OrderTypeLimit).
+			Price(info.FormatPrice(o.Price)).
+			TimeInForce(binance.TimeInForceType(o.TimeInForce))
+	case trading.StopOrder:
+		s.Type(binance.OrderTypeStopLoss).
+			StopPrice(info.FormatPrice(o.StopPrice))
+	case trading.StopLimitOrder:
+		s.Type(binance.OrderTypeStopLossLimit).
+			Price(info.FormatPrice(o.Price)).
+			StopPrice(info.FormatPrice(o.StopPrice)).
+			TimeInForce(binance.TimeInForceType(o.TimeInForce))
+	}
+	resp, err := s.Do(context.Background())
+	if err != nil {
+		return nil, binanceError(err)
+	}
+	o.Id = strconv.FormatInt(resp.OrderID, 10)
+	o.ClientId = resp.ClientOrderID
+	o.Status = orderStatus(resp.Status)
+	o.Time = util.UnixToTime(resp.TransactTime)
+	o.Transactions = nil
 	for _, fill := range resp.Fills {
 		p, err := strconv.ParseFloat(fill.Price, 64)
 		if err != nil {
@@ -45,55 +112,257 @@ func (b Binance) MarketBuy(sym string, q float64) ([]*trading.Transaction, error
 		if err != nil {
 			log.Printf("bad commission from binance response: %s", err)
 		}
-		ts = append(ts, &trading.Transaction{
-			Id:         int(resp.OrderID),
-			Time:       util.UnixToTime(resp.TransactTime),
-			Direction:  trading.Buy,
-			Quantity:   q,
-			Price:      p,
-			Commission: fee,
+		o.Transactions = append(o.Transactions, &trading.Transaction{
+			Id:              int(resp.OrderID),
+			OrderId:         o.Id,
+			Time:            o.Time,
+			Direction:       o.Direction,
+			Quantity:        q,
+			Price:           p,
+			Commission:      fee,
+			CommissionAsset: fill.CommissionAsset,
 		})
 	}
-	return ts, nil
+	if b.Stream != nil {
+		b.Stream.Track(&o)
+	}
+	return &o, nil
 }
 
-func (b Binance) MarketSell(sym string, q float64) ([]*trading.Transaction, error) {
-	resp, err := b.Client.NewCreateOrderService().
-		Symbol(sym).
-		Type(binance.OrderTypeMarket).
-		Side(binance.SideTypeSell).
-		Quantity(fmt.Sprintf("%f", q)).Do(context.Background())
-	if err != nil {
+// PlaceOCO places limit & stop as a binance OCO order list. stop leg is a
+// STOP_LOSS order, or STOP_LOSS_LIMIT if stop is a stop-limit order.
+func (b Binance) PlaceOCO(limit, stop trading.Order) ([]*trading.Order, error) {
+	info, ok := b.Symbols.Get(limit.Symbol)
+	if ok {
+		var err error
+		if limit, err = info.Apply(limit, 0); err != nil {
+			return nil, err
+		}
+		if stop, err = info.Apply(stop, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := trading.ValidateOCO(limit, stop); err != nil {
 		return nil, err
 	}
+	s := b.Client.NewCreateOCOService().
+		Symbol(limit.Symbol).
+		Side(binanceSide(limit.Direction)).
+		Quantity(info.FormatQuantity(limit.Quantity)).
+		Price(info.FormatPrice(limit.Price)).
+		StopPrice(info.FormatPrice(stop.StopPrice))
+	if limit.ClientId != "" {
+		s.LimitClientOrderID(limit.ClientId)
+	}
+	if stop.ClientId != "" {
+		s.StopClientOrderID(stop.ClientId)
+	}
+	if stop.Type == trading.StopLimitOrder {
+		s.StopLimitPrice(info.FormatPrice(stop.Price)).
+			StopLimitTimeInForce(binance.TimeInForceTypeGTC)
+	}
+	resp, err := s.Do(context.Background())
+	if err != nil {
+		return nil, binanceError(err)
+	}
+	if len(resp.OrderReports) != 2 {
+		return nil, fmt.Errorf("unexpected oco response with %d orders", len(resp.OrderReports))
+	}
+	orders := []*trading.Order{&limit, &stop}
+	for _, r := range resp.OrderReports {
+		o := orders[1]
+		if r.Type == binance.OrderTypeLimitMaker {
+			o = orders[0]
+		}
+		o.Id = strconv.FormatInt(r.OrderID, 10)
+		o.ClientId = r.ClientOrderID
+		o.Status = orderStatus(r.Status)
+		o.Time = util.UnixToTime(r.TransactionTime)
+		o.TimeInForce = trading.GTC
+	}
+	limit.OCO, stop.OCO = stop.Id, limit.Id
+	if b.Stream != nil {
+		b.Stream.Track(&limit)
+		b.Stream.Track(&stop)
+	}
+	return orders, nil
+}
+
+func (b Binance) CancelOrder(sym, id string) (*trading.Order, error) {
+	orderId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad binance order id \"%s\": %s", id, err)
+	}
+	_, err = b.Client.NewCancelOrderService().Symbol(sym).OrderID(orderId).Do(context.Background())
+	if err != nil {
+		return nil, binanceError(err)
+	}
+	// query order to get fills received before cancellation
+	o, err := b.restOrder(sym, id)
+	if err == nil && b.Stream != nil {
+		b.Stream.Track(o)
+	}
+	return o, err
+}
+
+// GetOrder returns order id from Stream if synced, or over REST.
+func (b Binance) GetOrder(sym, id string) (*trading.Order, error) {
+	if b.Stream != nil && b.Stream.Synced() {
+		if o := b.Stream.Order(id); o != nil {
+			return o, nil
+		}
+	}
+	return b.restOrder(sym, id)
+}
+
+func (b Binance) restOrder(sym, id string) (*trading.Order, error) {
+	orderId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad binance order id \"%s\": %s", id, err)
+	}
+	resp, err := b.Client.NewGetOrderService().Symbol(sym).OrderID(orderId).Do(context.Background())
+	if err != nil {
+		return nil, binanceError(err)
+	}
+	return b.order(resp)
+}
+
+func (b Binance) FindOrder(sym, clientId string) (*trading.Order, error) {
+	if b.Stream != nil && b.Stream.Synced() {
+		if o := b.Stream.FindOrder(sym, clientId); o != nil {
+			return o, nil
+		}
+	}
+	resp, err := b.Client.NewGetOrderService().Symbol(sym).OrigClientOrderID(clientId).Do(context.Background())
+	if err != nil {
+		if apiErr, ok := err.(*common.APIError); ok && apiErr.Code == codeNoSuchOrder {
+			return nil, trading.ErrOrderNotFound
+		}
+		return nil, binanceError(err)
+	}
+	return b.order(resp)
+}
+
+// order converts resp to a trading.Order, with its fills.
+func (b Binance) order(resp *binance.Order) (*trading.Order, error) {
+	var err error
+	o := &trading.Order{
+		Id:          strconv.FormatInt(resp.OrderID, 10),
+		ClientId:    resp.ClientOrderID,
+		Symbol:      resp.Symbol,
+		Direction:   resp.Side == binance.SideTypeBuy,
+		TimeInForce: trading.TimeInForce(resp.TimeInForce),
+		Status:      orderStatus(resp.Status),
+		Type:        orderType(resp.Type),
+		Time:        util.UnixToTime(resp.Time),
+	}
+	o.Quantity, _ = strconv.ParseFloat(resp.OrigQuantity, 64)
+	o.Price, _ = strconv.ParseFloat(resp.Price, 64)
+	o.StopPrice, _ = strconv.ParseFloat(resp.StopPrice, 64)
+	if executed, _ := strconv.ParseFloat(resp.ExecutedQuantity, 64); executed > 0 {
+		o.Transactions, err = b.orderTrades(resp.Symbol, resp.OrderID, resp.Time, o.Direction)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// orderTrades returns fills of order id, placed at unix time t (ms).
+func (b Binance) orderTrades(sym string, id int64, t int64, direction trading.Direction) ([]*trading.Transaction, error) {
+	trades, err := b.Client.NewListTradesService().Symbol(sym).StartTime(t).Limit(1000).Do(context.Background())
+	if err != nil {
+		return nil, binanceError(err)
+	}
 	var ts []*trading.Transaction
-	for _, fill := range resp.Fills {
-		p, err := strconv.ParseFloat(fill.Price, 64)
+	for _, trade := range trades {
+		if trade.OrderID != id {
+			continue
+		}
+		p, err := strconv.ParseFloat(trade.Price, 64)
 		if err != nil {
 			log.Printf("bad price from binance response: %s", err)
 			continue
 		}
-		q, err := strconv.ParseFloat(fill.Quantity, 64)
+		q, err := strconv.ParseFloat(trade.Quantity, 64)
 		if err != nil {
 			log.Printf("bad quantity from binance response: %s", err)
 			continue
 		}
-		fee, err := strconv.ParseFloat(fill.Commission, 64)
+		fee, err := strconv.ParseFloat(trade.Commission, 64)
 		if err != nil {
 			log.Printf("bad commission from binance response: %s", err)
 		}
 		ts = append(ts, &trading.Transaction{
-			Id:         int(resp.OrderID),
-			Time:       util.UnixToTime(resp.TransactTime),
-			Direction:  trading.Sell,
-			Quantity:   q,
-			Price:      p,
-			Commission: fee,
+			Id:              int(id),
+			OrderId:         strconv.FormatInt(id, 10),
+			Time:            util.UnixToTime(trade.Time),
+			Direction:       direction,
+			Quantity:        q,
+			Price:           p,
+			Commission:      fee,
+			CommissionAsset: trade.CommissionAsset,
 		})
 	}
 	return ts, nil
 }
 
+const codeNoSuchOrder = -2013
+
+// transientCodes are binance api error codes after which a request can be retried.
+var transientCodes = map[int64]bool{
+	-1000: true, // unknown error, request may or may not have been processed
+	-1001: true, // internal error, disconnected
+	-1003: true, // too many requests
+	-1006: true, // unexpected response, execution status unknown
+	-1007: true, // timeout waiting for backend, execution status unknown
+	-1015: true, // too many orders
+	-1021: true, // timestamp outside of recvWindow
+}
+
+// binanceError wraps err in trading.TransientError if it can be retried.
+func binanceError(err error) error {
+	if apiErr, ok := err.(*common.APIError); ok && transientCodes[apiErr.Code] {
+		return trading.TransientError{Err: err}
+	}
+	return err
+}
+
+func binanceSide(direction trading.Direction) binance.SideType {
+	if direction == trading.Buy {
+		return binance.SideTypeBuy
+	}
+	return binance.SideTypeSell
+}
+
+func orderType(t binance.OrderType) trading.OrderType {
+	switch t {
+	case binance.OrderTypeLimit, binance.OrderTypeLimitMaker:
+		return trading.LimitOrder
+	case binance.OrderTypeStopLoss, binance.OrderTypeTakeProfit:
+		return trading.StopOrder
+	case binance.OrderTypeStopLossLimit, binance.OrderTypeTakeProfitLimit:
+		return trading.StopLimitOrder
+	}
+	return trading.MarketOrder
+}
+
+func orderStatus(s binance.OrderStatusType) trading.OrderStatus {
+	switch s {
+	case binance.OrderStatusTypePartiallyFilled:
+		return trading.OrderPartiallyFilled
+	case binance.OrderStatusTypeFilled:
+		return trading.OrderFilled
+	case binance.OrderStatusTypeCanceled, binance.OrderStatusTypePendingCancel:
+		return trading.OrderCancelled
+	case binance.OrderStatusTypeRejected:
+		return trading.OrderRejected
+	case binance.OrderStatusTypeExpired:
+		return trading.OrderExpired
+	}
+	return trading.OrderNew
+}
+
 func (b Binance) Name() string {
 	if prefix := "binance"; b.Account == "" {
 		return prefix
@@ -115,20 +384,64 @@ func (b Binance) GetFees() (maker, taker float64, err error) {
 }
 
 func (b Binance) BTCUSDTicker() (float64, error) {
-	tname := "BTCUSDT"
-	ticker, err := b.NewBookTickerService().Symbol(tname).Do(context.Background())
+	return b.Ticker("BTCUSDT")
+}
+
+// Ticker returns mid price of sym order book.
+func (b Binance) Ticker(sym string) (float64, error) {
+	ticker, err := b.NewBookTickerService().Symbol(sym).Do(context.Background())
 	if err != nil {
-		return 0, fmt.Errorf("error getting binance ticker %s: %s", tname, err)
+		return 0, fmt.Errorf("error getting binance ticker %s: %s", sym, err)
 	}
 	bid, _ := strconv.ParseFloat(ticker.BidPrice, 64)
 	ask, _ := strconv.ParseFloat(ticker.AskPrice, 64)
 	return (bid + ask) / 2, nil
 }
 
+// LoadSymbols returns trading rules of all symbols from binance exchangeInfo.
+func (b Binance) LoadSymbols() (trading.Symbols, error) {
+	info, err := b.NewExchangeInfoService().Do(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("binance exchangeInfo: %s", err)
+	}
+	symbols := trading.Symbols{}
+	for _, sym := range info.Symbols {
+		s := trading.SymbolInfo{
+			Symbol: sym.Symbol,
+			Base:   sym.BaseAsset,
+			Quote:  sym.QuoteAsset,
+		}
+		for _, f := range sym.Filters {
+			switch f["filterType"] {
+			case "LOT_SIZE":
+				s.StepSize = filterValue(f, "stepSize")
+				s.MinQty = filterValue(f, "minQty")
+				s.MaxQty = filterValue(f, "maxQty")
+			case "PRICE_FILTER":
+				s.TickSize = filterValue(f, "tickSize")
+			case "MIN_NOTIONAL", "NOTIONAL":
+				s.MinNotional = filterValue(f, "minNotional")
+			}
+		}
+		symbols[s.Symbol] = s
+	}
+	return symbols, nil
+}
+
+func filterValue(f map[string]interface{}, key string) float64 {
+	s, _ := f[key].(string)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Printf("bad %s filter value from binance response: %s", key, err)
+	}
+	return v
+}
+
+// Snapshot values balances of Stream if synced, or of the account over REST.
 func (b Binance) Snapshot() (*trading.Snapshot, error) {
-	acc, err := b.NewGetAccountService().Do(context.Background())
+	balances, err := b.balances()
 	if err != nil {
-		return nil, fmt.Errorf("binance api: %s", err)
+		return nil, err
 	}
 	s := &trading.Snapshot{
 		Time:     time.Now(),
@@ -139,19 +452,17 @@ func (b Binance) Snapshot() (*trading.Snapshot, error) {
 	if err != nil {
 		log.Println("Snapshot:", err)
 	}
-	for _, asset := range acc.Balances {
-		free, _ := strconv.ParseFloat(asset.Free, 64)
-		locked, _ := strconv.ParseFloat(asset.Locked, 64)
-		total := free + locked
+	for asset, funds := range balances {
+		total := funds.Total()
 		if total == 0 {
 			continue
 		}
 		bal := trading.Balance{
 			Total: total,
-			Free:  free,
+			Free:  funds.Free,
 		}
 
-		switch asset.Asset {
+		switch asset {
 		case "BTC":
 			bal.BTCEquiv = total
 			if errBtcUsdt == nil {
@@ -163,7 +474,7 @@ func (b Binance) Snapshot() (*trading.Snapshot, error) {
 				bal.BTCEquiv = total / btcusdt
 			}
 		default:
-			tname := fmt.Sprintf("%sBTC", asset.Asset)
+			tname := fmt.Sprintf("%sBTC", asset)
 			ticker, err := b.NewBookTickerService().Symbol(tname).Do(context.Background())
 			if err != nil {
 				log.Printf("error getting binance ticker %s: %s", tname, err)
@@ -183,7 +494,25 @@ func (b Binance) Snapshot() (*trading.Snapshot, error) {
 		}
 		s.BTCEquiv += bal.BTCEquiv
 		s.USDTEquiv += bal.USDTEquiv
-		s.Balances[asset.Asset] = bal
+		s.Balances[asset] = bal
 	}
 	return s, nil
 }
+
+// balances returns funds by asset, of Stream if synced.
+func (b Binance) balances() (map[string]trading.Funds, error) {
+	if b.Stream != nil && b.Stream.Synced() {
+		return b.Stream.Balances(), nil
+	}
+	acc, err := b.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("binance api: %s", err)
+	}
+	balances := map[string]trading.Funds{}
+	for _, asset := range acc.Balances {
+		free, _ := strconv.ParseFloat(asset.Free, 64)
+		locked, _ := strconv.ParseFloat(asset.Locked, 64)
+		balances[asset.Asset] = trading.Funds{Free: free, Locked: locked}
+	}
+	return balances, nil
+}
@@ -3,26 +3,217 @@ package brokers
 import (
 	"context"
 	"flag"
+	"github.com/adshao/go-binance/common"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/brokers/fakebinance"
+	"math"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 var (
-	testBinanceKey    = flag.String("testBinanceKey", "", "binance api key used in tests")
+	testBinanceKey    = flag.String("testBinanceKey", "", "binance api key used in tests, a fake exchange is used if empty")
 	testBinanceSecret = flag.String("testBinanceSecret", "", "binance api secret used in tests")
 )
 
+var testSymbols = trading.Symbols{
+	"ABCBTC": {Symbol: "ABCBTC", Base: "ABC", Quote: "BTC",
+		StepSize: 0.01, MinQty: 0.01, TickSize: 0.000001, MinNotional: 0.0001},
+	"BTCUSDT": {Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT",
+		StepSize: 0.000001, MinQty: 0.000001, TickSize: 0.01, MinNotional: 10},
+}
+
+var t0 = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeBinance returns a broker on a fake exchange holding 1 BTC, ABCBTC at 0.01
+// & BTCUSDT at 4000, and the exchange server.
+func fakeBinance(t *testing.T) (*Binance, *fakebinance.Exchange, *httptest.Server) {
+	e := fakebinance.NewExchange("key", "secret", testSymbols)
+	e.Deposit("BTC", 1)
+	e.SetPrice("ABCBTC", t0, 0.01)
+	e.SetPrice("BTCUSDT", t0, 4000)
+	srv := httptest.NewServer(e)
+	b := NewBinanceBroker("test", "key", "secret")
+	b.BaseURL = srv.URL
+	var err error
+	if b.Symbols, err = b.LoadSymbols(); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return b, e, srv
+}
+
+// testBinance returns a broker on binance if testBinanceKey is set, or on a fake exchange.
+func testBinance(t *testing.T) (*Binance, func()) {
+	if *testBinanceKey != "" {
+		return NewBinanceBroker("test", *testBinanceKey, *testBinanceSecret), func() {}
+	}
+	b, _, srv := fakeBinance(t)
+	return b, srv.Close
+}
+
+func almostEq(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
 func TestBinancePing(t *testing.T) {
-	err := NewBinanceBroker("", "a", "b").NewPingService().Do(context.Background())
+	b, done := testBinance(t)
+	defer done()
+	err := b.NewPingService().Do(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestBinance_Snapshot(t *testing.T) {
-	cl := NewBinanceBroker("test", *testBinanceKey, *testBinanceSecret)
+	cl, done := testBinance(t)
+	defer done()
 	s, err := cl.Snapshot()
 	if err != nil {
 		t.Errorf("snapshot: %s", err)
 	}
 	t.Logf("%s", s)
+	if *testBinanceKey == "" && (s.BTCEquiv != 1 || s.USDTEquiv != 4000) {
+		t.Errorf("expected 1 BTC worth 4000 USDT, got %s", s)
+	}
+}
+
+func TestBinance_LoadSymbols(t *testing.T) {
+	b, _, srv := fakeBinance(t)
+	defer srv.Close()
+	if len(b.Symbols) != len(testSymbols) {
+		t.Fatalf("expected %d symbols, got %v", len(testSymbols), b.Symbols)
+	}
+	for sym, info := range testSymbols {
+		if b.Symbols[sym] != info {
+			t.Errorf("expected %+v, got %+v", info, b.Symbols[sym])
+		}
+	}
+}
+
+func TestBinance_PlaceOrder(t *testing.T) {
+	b, e, srv := fakeBinance(t)
+	defer srv.Close()
+	e.SetFees(0.001)
+
+	fills, err := b.MarketBuy("ABCBTC", 10.005)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 1 || fills[0].Quantity != 10 || fills[0].Price != 0.01 || !almostEq(fills[0].Commission, 0.0001) {
+		t.Fatalf("unexpected fills %+v", fills)
+	}
+	bal := e.Balances()
+	if bal["ABC"].Free != 10 || !almostEq(bal["BTC"].Free, 0.8999) {
+		t.Errorf("unexpected balances %v", bal)
+	}
+
+	// resting limit sell, filled when price reaches it
+	o, err := b.PlaceOrder(trading.Order{
+		ClientId: "tp", Symbol: "ABCBTC", Direction: trading.Sell, Type: trading.LimitOrder,
+		Quantity: 10, Price: 0.012,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != trading.OrderNew || len(o.Transactions) != 0 {
+		t.Fatalf("expected a new order, got %s", o)
+	}
+	if bal := e.Balances()["ABC"]; bal.Locked != 10 {
+		t.Errorf("expected 10 ABC locked, got %v", bal)
+	}
+	e.SetPrice("ABCBTC", t0.Add(time.Hour), 0.012)
+	o, err = b.FindOrder("ABCBTC", "tp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != trading.OrderFilled || len(o.Transactions) != 1 || o.Transactions[0].Price != 0.012 {
+		t.Errorf("expected a fill at 0.012, got %s with %v", o, o.Transactions)
+	}
+	if _, err = b.FindOrder("ABCBTC", "unknown"); err != trading.ErrOrderNotFound {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+
+	// cancelled order releases its funds
+	o, err = b.PlaceOrder(trading.Order{
+		Symbol: "ABCBTC", Direction: trading.Buy, Type: trading.LimitOrder, Quantity: 10, Price: 0.01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, err = b.CancelOrder("ABCBTC", o.Id); err != nil || o.Status != trading.OrderCancelled {
+		t.Fatalf("expected a cancelled order, got %v, %v", o, err)
+	}
+	if bal := e.Balances()["BTC"]; bal.Locked != 0 {
+		t.Errorf("expected no BTC locked, got %v", bal)
+	}
+
+	// rejections
+	b.Symbols = nil
+	_, err = b.MarketBuy("ABCBTC", 0.001)
+	if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != -1013 {
+		t.Errorf("expected a filter failure, got %v", err)
+	}
+	_, err = b.MarketBuy("ABCBTC", 1000)
+	if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != -2010 {
+		t.Errorf("expected an insufficient balance rejection, got %v", err)
+	}
+	_, err = b.GetOrder("ABCBTC", "1000")
+	if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != codeNoSuchOrder {
+		t.Errorf("expected an unknown order, got %v", err)
+	}
+}
+
+func TestBinance_PlaceOCO(t *testing.T) {
+	b, e, srv := fakeBinance(t)
+	defer srv.Close()
+	if _, err := b.MarketBuy("ABCBTC", 10); err != nil {
+		t.Fatal(err)
+	}
+	limit := trading.Order{Symbol: "ABCBTC", Direction: trading.Sell, Type: trading.LimitOrder, Quantity: 10, Price: 0.012}
+	stop := limit
+	stop.Type, stop.Price, stop.StopPrice = trading.StopOrder, 0, 0.009
+	orders, err := b.PlaceOCO(limit, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders[0].Type != trading.LimitOrder || orders[1].Type != trading.StopOrder || orders[0].OCO != orders[1].Id {
+		t.Fatalf("unexpected oco orders %s, %s", orders[0], orders[1])
+	}
+
+	e.SetPrice("ABCBTC", t0.Add(time.Hour), 0.008)
+	l, err := b.GetOrder("ABCBTC", orders[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := b.GetOrder("ABCBTC", orders[1].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Status != trading.OrderCancelled || s.Status != trading.OrderFilled || s.Executed() != 10 {
+		t.Errorf("expected stop filled & limit cancelled, got %s, %s", s, l)
+	}
+	if bal := e.Balances(); bal["ABC"].Total() != 0 || !almostEq(bal["BTC"].Free, 0.98) {
+		t.Errorf("unexpected balances %v", bal)
+	}
+}
+
+func TestBinance_Signature(t *testing.T) {
+	_, _, srv := fakeBinance(t)
+	defer srv.Close()
+	for _, c := range []struct {
+		key, secret string
+		code        int64
+	}{
+		{"key", "wrong", -1022},
+		{"wrong", "secret", -2015},
+	} {
+		b := NewBinanceBroker("test", c.key, c.secret)
+		b.BaseURL = srv.URL
+		_, err := b.NewGetAccountService().Do(context.Background())
+		if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != c.code {
+			t.Errorf("expected error code %d, got %v", c.code, err)
+		}
+	}
 }
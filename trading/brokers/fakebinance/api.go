@@ -0,0 +1,616 @@
+package fakebinance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/adshao/go-binance"
+	"github.com/adshao/go-binance/common"
+	"github.com/rkjdid/gocx/trading"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// binance api error codes
+const (
+	codeUnknown          = -1000
+	codeMandatoryParam   = -1102
+	codeBadParam         = -1100
+	codeInvalidOrderType = -1116
+	codeInvalidSymbol    = -1121
+	codeNoListenKey      = -1125
+	codeBadSignature     = -1022
+	codeFilterFailure    = -1013
+	codeOrderRejected    = -2010
+	codeCancelRejected   = -2011
+	codeNoSuchOrder      = -2013
+	codeBadAPIKey        = -2015
+)
+
+// httpError is a binance api error, and its http status.
+type httpError struct {
+	status int
+	common.APIError
+}
+
+func (e *httpError) Error() string {
+	return e.APIError.Error()
+}
+
+func newError(status int, code int64, format string, args ...interface{}) *httpError {
+	return &httpError{status, common.APIError{Code: code, Message: fmt.Sprintf(format, args...)}}
+}
+
+func writeError(w http.ResponseWriter, err *httpError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	_ = json.NewEncoder(w).Encode(err.APIError)
+}
+
+func apiError(w http.ResponseWriter, status int, code int64, msg string) {
+	writeError(w, newError(status, code, "%s", msg))
+}
+
+func (e *Exchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/ws/") {
+		e.serveUser(w, r, strings.TrimPrefix(r.URL.Path, "/ws/"))
+		return
+	}
+	params, body, err := readParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	type handler struct {
+		// key requires the api key, signed a signature too
+		key, signed bool
+		fn          func(params url.Values) (interface{}, *httpError)
+	}
+	routes := map[string]handler{
+		"GET /ping":              {fn: e.ping},
+		"GET /time":              {fn: e.serverTime},
+		"GET /exchangeInfo":      {fn: e.exchangeInfo},
+		"GET /ticker/bookTicker": {fn: e.bookTicker},
+		"GET /klines":            {fn: e.getKlines},
+		"POST /userDataStream":   {key: true, fn: e.startUserStream},
+		"PUT /userDataStream":    {key: true, fn: e.keepaliveUserStream},
+		"DELETE /userDataStream": {key: true, fn: e.closeUserStream},
+		"GET /account":           {key: true, signed: true, fn: e.getAccount},
+		"POST /order":            {key: true, signed: true, fn: e.createOrder},
+		"GET /order":             {key: true, signed: true, fn: e.getOrder},
+		"DELETE /order":          {key: true, signed: true, fn: e.cancelOrder},
+		"GET /openOrders":        {key: true, signed: true, fn: e.openOrders},
+		"GET /myTrades":          {key: true, signed: true, fn: e.myTrades},
+		"POST /order/oco":        {key: true, signed: true, fn: e.createOCO},
+	}
+	h, ok := routes[r.Method+" "+endpoint(r)]
+	if !ok {
+		apiError(w, http.StatusNotFound, codeUnknown, "unknown endpoint "+r.Method+" "+r.URL.Path)
+		return
+	}
+	if h.key && r.Header.Get("X-MBX-APIKEY") != e.Key {
+		apiError(w, http.StatusUnauthorized, codeBadAPIKey, "Invalid API-key, IP, or permissions for action.")
+		return
+	}
+	if h.signed {
+		if err := e.verify(r.URL.RawQuery, body, params); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	v, err := h.fn(params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// readParams returns query & form parameters of r, and its body.
+func readParams(r *http.Request) (url.Values, string, *httpError) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", newError(http.StatusBadRequest, codeUnknown, "reading body: %s", err)
+	}
+	params := r.URL.Query()
+	form, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, "", newError(http.StatusBadRequest, codeBadParam, "bad form: %s", err)
+	}
+	for k, vs := range form {
+		params[k] = append(params[k], vs...)
+	}
+	return params, string(data), nil
+}
+
+// verify checks the signature of a request, the hmac of its query string without
+// signature followed by its body.
+func (e *Exchange) verify(query, body string, params url.Values) *httpError {
+	if params.Get("timestamp") == "" {
+		return newError(http.StatusBadRequest, codeMandatoryParam, "Mandatory parameter 'timestamp' was not sent.")
+	}
+	signature := params.Get("signature")
+	if signature == "" {
+		return newError(http.StatusBadRequest, codeMandatoryParam, "Mandatory parameter 'signature' was not sent.")
+	}
+	var signed []string
+	for _, p := range strings.Split(query, "&") {
+		if !strings.HasPrefix(p, "signature=") {
+			signed = append(signed, p)
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(e.Secret))
+	mac.Write([]byte(strings.Join(signed, "&") + body))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return newError(http.StatusBadRequest, codeBadSignature, "Signature for this request is not valid.")
+	}
+	return nil
+}
+
+func (e *Exchange) ping(url.Values) (interface{}, *httpError) {
+	return struct{}{}, nil
+}
+
+func (e *Exchange) serverTime(url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return map[string]int64{"serverTime": ms(e.now())}, nil
+}
+
+func (e *Exchange) exchangeInfo(url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info := binance.ExchangeInfo{Timezone: "UTC", ServerTime: ms(e.now())}
+	for _, sym := range e.sortedSymbols() {
+		s := e.symbols[sym]
+		info.Symbols = append(info.Symbols, binance.Symbol{
+			Symbol: sym, Status: "TRADING", BaseAsset: s.Base, QuoteAsset: s.Quote,
+			OrderTypes: []string{"LIMIT", "LIMIT_MAKER", "MARKET", "STOP_LOSS", "STOP_LOSS_LIMIT"},
+			Filters: []map[string]interface{}{
+				{"filterType": "PRICE_FILTER", "tickSize": format(s.TickSize)},
+				{"filterType": "LOT_SIZE", "stepSize": format(s.StepSize),
+					"minQty": format(s.MinQty), "maxQty": format(s.MaxQty)},
+				{"filterType": "MIN_NOTIONAL", "minNotional": format(s.MinNotional)},
+			},
+		})
+	}
+	return info, nil
+}
+
+func (e *Exchange) sortedSymbols() []string {
+	var symbols []string
+	for sym := range e.symbols {
+		symbols = append(symbols, sym)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// symbol returns the symbol parameter, which must be known.
+func (e *Exchange) symbol(params url.Values) (string, *httpError) {
+	sym := params.Get("symbol")
+	if sym == "" {
+		return "", newError(http.StatusBadRequest, codeMandatoryParam, "Mandatory parameter 'symbol' was not sent.")
+	}
+	if _, ok := e.symbols[sym]; !ok {
+		return "", newError(http.StatusBadRequest, codeInvalidSymbol, "Invalid symbol.")
+	}
+	return sym, nil
+}
+
+func (e *Exchange) bookTicker(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	price := format(e.prices[sym])
+	return binance.BookTicker{Symbol: sym, BidPrice: price, BidQuantity: "1000", AskPrice: price, AskQuantity: "1000"}, nil
+}
+
+// getKlines returns klines opened from startTime, as arrays like binance.
+func (e *Exchange) getKlines(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	start, _ := strconv.ParseInt(params.Get("startTime"), 10, 64)
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 {
+		limit = 500
+	}
+	rows := [][]interface{}{}
+	s, ok := e.klines[sym][params.Get("interval")]
+	if !ok {
+		return rows, nil
+	}
+	for _, o := range s.bars {
+		open := ms(o.Timestamp.T())
+		if open < start {
+			continue
+		}
+		if len(rows) == limit {
+			break
+		}
+		rows = append(rows, []interface{}{
+			open, format(o.Open), format(o.High), format(o.Low), format(o.Close), format(o.Volume),
+			open + int64(s.tf.ToDuration()/time.Millisecond) - 1, format(o.Volume * o.Close), 1, "0", "0", "0",
+		})
+	}
+	return rows, nil
+}
+
+func (e *Exchange) startUserStream(url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastKey++
+	key := fmt.Sprintf("listenkey%d", e.lastKey)
+	e.keys[key] = true
+	return map[string]string{"listenKey": key}, nil
+}
+
+func (e *Exchange) keepaliveUserStream(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.keys[params.Get("listenKey")] {
+		return nil, newError(http.StatusBadRequest, codeNoListenKey, "This listenKey does not exist.")
+	}
+	return struct{}{}, nil
+}
+
+// closeUserStream invalidates a listen key, and closes its connections.
+func (e *Exchange) closeUserStream(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := params.Get("listenKey")
+	if !e.keys[key] {
+		return nil, newError(http.StatusBadRequest, codeNoListenKey, "This listenKey does not exist.")
+	}
+	delete(e.keys, key)
+	for c := range e.conns {
+		if c.key == key {
+			c.close()
+		}
+	}
+	return struct{}{}, nil
+}
+
+func (e *Exchange) getAccount(url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fees := int64(e.paper.FeesRate * 10000)
+	acc := binance.Account{
+		MakerCommission: fees, TakerCommission: fees, CanTrade: true, CanWithdraw: true, CanDeposit: true,
+		Balances: []binance.Balance{},
+	}
+	for _, asset := range e.assets() {
+		f := e.account.Funds[asset]
+		acc.Balances = append(acc.Balances, binance.Balance{Asset: asset, Free: format(f.Free), Locked: format(f.Locked)})
+	}
+	return acc, nil
+}
+
+// float returns parameter name, mandatory unless zero is allowed.
+func float(params url.Values, name string, optional bool) (float64, *httpError) {
+	s := params.Get(name)
+	if s == "" {
+		if optional {
+			return 0, nil
+		}
+		return 0, newError(http.StatusBadRequest, codeMandatoryParam, "Mandatory parameter '%s' was not sent.", name)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, newError(http.StatusBadRequest, codeBadParam, "Illegal characters found in parameter '%s'.", name)
+	}
+	return v, nil
+}
+
+// newOrder returns the order of create order parameters.
+func (e *Exchange) newOrder(sym string, params url.Values) (trading.Order, binance.OrderType, *httpError) {
+	o := trading.Order{
+		Symbol:      sym,
+		ClientId:    params.Get("newClientOrderId"),
+		Direction:   binance.SideType(params.Get("side")) == binance.SideTypeBuy,
+		TimeInForce: trading.TimeInForce(params.Get("timeInForce")),
+	}
+	switch binance.SideType(params.Get("side")) {
+	case binance.SideTypeBuy, binance.SideTypeSell:
+	default:
+		return o, "", newError(http.StatusBadRequest, codeBadParam, "Invalid side.")
+	}
+	typ := binance.OrderType(params.Get("type"))
+	switch typ {
+	case binance.OrderTypeMarket:
+		o.Type = trading.MarketOrder
+	case binance.OrderTypeLimit, binance.OrderTypeLimitMaker:
+		o.Type = trading.LimitOrder
+	case binance.OrderTypeStopLoss:
+		o.Type = trading.StopOrder
+	case binance.OrderTypeStopLossLimit:
+		o.Type = trading.StopLimitOrder
+	default:
+		return o, "", newError(http.StatusBadRequest, codeInvalidOrderType, "Invalid orderType.")
+	}
+	var err *httpError
+	if o.Quantity, err = float(params, "quantity", false); err != nil {
+		return o, "", err
+	}
+	if o.Price, err = float(params, "price", o.Type == trading.MarketOrder || o.Type == trading.StopOrder); err != nil {
+		return o, "", err
+	}
+	if o.StopPrice, err = float(params, "stopPrice", o.Type != trading.StopOrder && o.Type != trading.StopLimitOrder); err != nil {
+		return o, "", err
+	}
+	return o, typ, nil
+}
+
+// filter applies trading rules of o symbol to o, at the price of its symbol.
+func (e *Exchange) filter(o trading.Order) (trading.Order, *httpError) {
+	o, err := e.symbols[o.Symbol].Apply(o, e.prices[o.Symbol])
+	if err != nil {
+		return o, newError(http.StatusBadRequest, codeFilterFailure, "Filter failure: %s", err)
+	}
+	if err = o.Validate(); err != nil {
+		return o, newError(http.StatusBadRequest, codeBadParam, "%s", err)
+	}
+	return o, nil
+}
+
+func (e *Exchange) createOrder(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	o, typ, err := e.newOrder(sym, params)
+	if err != nil {
+		return nil, err
+	}
+	if o, err = e.filter(o); err != nil {
+		return nil, err
+	}
+	price := e.prices[sym]
+	if o.Type == trading.MarketOrder && price <= 0 {
+		return nil, newError(http.StatusBadRequest, codeOrderRejected, "Market is closed.")
+	}
+	if typ == binance.OrderTypeLimitMaker && price > 0 &&
+		(o.Direction == trading.Buy && price <= o.Price || o.Direction == trading.Sell && price >= o.Price) {
+		return nil, newError(http.StatusBadRequest, codeOrderRejected, "Order would immediately match and take.")
+	}
+	e.at(sym)
+	placed, placeErr := e.paper.PlaceOrder(o)
+	if placeErr != nil {
+		if placed != nil {
+			e.add(placed, typ, -1)
+			e.publish(false)
+		}
+		return nil, newError(http.StatusBadRequest, codeOrderRejected, "%s", placeErr)
+	}
+	e.add(placed, typ, -1)
+	e.publish(false)
+	resp := binance.CreateOrderResponse{
+		Symbol: sym, OrderID: id(placed.Id), ClientOrderID: placed.ClientId, TransactTime: ms(e.now()),
+		Price: format(placed.Price), OrigQuantity: format(placed.Quantity),
+		ExecutedQuantity: format(placed.Executed()), CummulativeQuoteQuantity: format(quoteExecuted(placed)),
+		Status: binanceStatus(placed.Status), TimeInForce: binance.TimeInForceType(placed.TimeInForce),
+		Type: typ, Side: side(placed.Direction), Fills: []*binance.Fill{},
+	}
+	for _, t := range placed.Transactions {
+		resp.Fills = append(resp.Fills, &binance.Fill{
+			Price: format(t.Price), Quantity: format(t.Quantity),
+			Commission: format(t.Commission), CommissionAsset: t.CommissionAsset,
+		})
+	}
+	return resp, nil
+}
+
+// lookup returns the order of orderId or origClientOrderId parameters.
+func (e *Exchange) lookup(sym string, params url.Values) (*trading.Order, *httpError) {
+	var o *trading.Order
+	var err error
+	if orderId := params.Get("orderId"); orderId != "" {
+		o, err = e.paper.GetOrder(sym, orderId)
+	} else if clientId := params.Get("origClientOrderId"); clientId != "" {
+		o, err = e.paper.FindOrder(sym, clientId)
+	} else {
+		return nil, newError(http.StatusBadRequest, codeMandatoryParam,
+			"Param 'origClientOrderId' or 'orderId' must be sent, but both were empty/null!")
+	}
+	if err != nil || o.Symbol != sym {
+		return nil, newError(http.StatusBadRequest, codeNoSuchOrder, "Order does not exist.")
+	}
+	return o, nil
+}
+
+func (e *Exchange) getOrder(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	o, err := e.lookup(sym, params)
+	if err != nil {
+		return nil, err
+	}
+	return e.order(o), nil
+}
+
+func (e *Exchange) cancelOrder(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	o, err := e.lookup(sym, params)
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, codeCancelRejected, "Unknown order sent.")
+	}
+	cancelled, cancelErr := e.paper.CancelOrder(sym, o.Id)
+	if cancelErr != nil {
+		return nil, newError(http.StatusBadRequest, codeCancelRejected, "Unknown order sent.")
+	}
+	e.publish(false)
+	return e.order(cancelled), nil
+}
+
+func (e *Exchange) openOrders(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym := params.Get("symbol")
+	if sym != "" {
+		if _, err := e.symbol(params); err != nil {
+			return nil, err
+		}
+	}
+	orders := []*binance.Order{}
+	for _, orderId := range e.ids {
+		o, err := e.paper.GetOrder(sym, orderId)
+		if err == nil && o.Open() && (sym == "" || o.Symbol == sym) {
+			orders = append(orders, e.order(o))
+		}
+	}
+	return orders, nil
+}
+
+// myTrades returns fills of symbol from startTime, up to limit.
+func (e *Exchange) myTrades(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	start, _ := strconv.ParseInt(params.Get("startTime"), 10, 64)
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 {
+		limit = 500
+	}
+	trades := []*binance.TradeV3{}
+	var n int64
+	for _, orderId := range e.ids {
+		o, err := e.paper.GetOrder(sym, orderId)
+		if err != nil || o.Symbol != sym {
+			continue
+		}
+		for _, t := range o.Transactions {
+			n++
+			if ms(t.Time) < start || len(trades) == limit {
+				continue
+			}
+			trades = append(trades, &binance.TradeV3{
+				ID: n, Symbol: sym, OrderID: id(o.Id), Price: format(t.Price), Quantity: format(t.Quantity),
+				QuoteQuantity: format(t.Cost()), Commission: format(t.Commission),
+				CommissionAsset: t.CommissionAsset, Time: ms(t.Time), IsBuyer: t.Direction == trading.Buy,
+				IsMaker: o.Type != trading.MarketOrder, IsBestMatch: true,
+			})
+		}
+	}
+	return trades, nil
+}
+
+func (e *Exchange) createOCO(params url.Values) (interface{}, *httpError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sym, err := e.symbol(params)
+	if err != nil {
+		return nil, err
+	}
+	limit := trading.Order{
+		Symbol:      sym,
+		ClientId:    params.Get("limitClientOrderId"),
+		Direction:   binance.SideType(params.Get("side")) == binance.SideTypeBuy,
+		Type:        trading.LimitOrder,
+		TimeInForce: trading.GTC,
+	}
+	if limit.Quantity, err = float(params, "quantity", false); err != nil {
+		return nil, err
+	}
+	if limit.Price, err = float(params, "price", false); err != nil {
+		return nil, err
+	}
+	stop := limit
+	stop.ClientId, stop.Type, stop.Price = params.Get("stopClientOrderId"), trading.StopOrder, 0
+	stopType := binance.OrderTypeStopLoss
+	if stop.StopPrice, err = float(params, "stopPrice", false); err != nil {
+		return nil, err
+	}
+	if stop.Price, err = float(params, "stopLimitPrice", true); err != nil {
+		return nil, err
+	}
+	if stop.Price > 0 {
+		stop.Type, stopType = trading.StopLimitOrder, binance.OrderTypeStopLossLimit
+	} else {
+		stop.TimeInForce = ""
+	}
+	if limit, err = e.filter(limit); err != nil {
+		return nil, err
+	}
+	if stop, err = e.filter(stop); err != nil {
+		return nil, err
+	}
+	e.at(sym)
+	orders, placeErr := e.paper.PlaceOCO(limit, stop)
+	if placeErr != nil {
+		return nil, newError(http.StatusBadRequest, codeOrderRejected, "%s", placeErr)
+	}
+	e.lastList++
+	listId := e.lastList
+	// stop is placed first by paper
+	e.add(orders[1], stopType, listId)
+	e.add(orders[0], binance.OrderTypeLimitMaker, listId)
+	e.publish(false)
+	resp := binance.CreateOCOResponse{
+		OrderListID: listId, ContingencyType: "OCO", ListStatusType: "EXEC_STARTED",
+		ListOrderStatus: "EXECUTING", ListClientOrderID: params.Get("listClientOrderId"),
+		TransactionTime: ms(e.now()), Symbol: sym,
+	}
+	for _, o := range []*trading.Order{orders[1], orders[0]} {
+		resp.Orders = append(resp.Orders, &binance.OCOOrder{Symbol: sym, OrderID: id(o.Id), ClientOrderID: o.ClientId})
+		resp.OrderReports = append(resp.OrderReports, &binance.OCOOrderReport{
+			Symbol: sym, OrderID: id(o.Id), OrderListID: listId, ClientOrderID: o.ClientId,
+			TransactionTime: ms(e.now()), Price: format(o.Price), OrigQuantity: format(o.Quantity),
+			ExecutedQuantity: "0", CummulativeQuoteQuantity: "0", Status: binanceStatus(o.Status),
+			TimeInForce: binance.TimeInForceType(o.TimeInForce), Type: e.orders[o.Id].typ,
+			Side: side(o.Direction), StopPrice: format(o.StopPrice),
+		})
+	}
+	return resp, nil
+}
+
+// order returns o as binance does.
+func (e *Exchange) order(o *trading.Order) *binance.Order {
+	updated := o.Time
+	if n := len(o.Transactions); n > 0 {
+		updated = o.Transactions[n-1].Time
+	}
+	return &binance.Order{
+		Symbol: o.Symbol, OrderID: id(o.Id), ClientOrderID: o.ClientId,
+		Price: format(o.Price), OrigQuantity: format(o.Quantity), ExecutedQuantity: format(o.Executed()),
+		CummulativeQuoteQuantity: format(quoteExecuted(o)), Status: binanceStatus(o.Status),
+		TimeInForce: binance.TimeInForceType(o.TimeInForce), Type: e.orders[o.Id].typ,
+		Side: side(o.Direction), StopPrice: format(o.StopPrice), Time: ms(o.Time), UpdateTime: ms(updated),
+	}
+}
+
+func quoteExecuted(o *trading.Order) float64 {
+	var q float64
+	for _, t := range o.Transactions {
+		q += t.Cost()
+	}
+	return q
+}
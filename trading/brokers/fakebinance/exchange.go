@@ -0,0 +1,361 @@
+// Package fakebinance is a local binance spot exchange, serving the REST api
+// subset used by brokers.Binance, user data streams and klines, for tests.
+package fakebinance
+
+import (
+	"encoding/json"
+	"github.com/adshao/go-binance"
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/gorilla/websocket"
+	scraper "github.com/rkjdid/gocx/backtest/scraper/binance"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exchange is a fake binance exchange, it is an http.Handler, e.g. of an
+// httptest.Server. Orders are matched by a trading.PaperTrading on prices
+// set with SetPrice & Update, against balances of a single account. Fills
+// are never partial.
+//
+// Signed endpoints check the api key & signature of requests.
+type Exchange struct {
+	Key, Secret string
+
+	mu      sync.Mutex
+	symbols trading.Symbols
+	paper   *trading.PaperTrading
+	account *trading.Account
+	prices  map[string]float64
+	// time of the last price, wall time if zero
+	time time.Time
+	// klines by symbol & interval
+	klines map[string]map[string]*series
+	// orders by paper order id, ids in placement order
+	orders   map[string]*order
+	ids      []string
+	lastList int64
+	// listen keys, and user data connections
+	keys     map[string]bool
+	lastKey  int
+	conns    map[*userConn]bool
+	upgrader websocket.Upgrader
+}
+
+// order holds what paper orders lack, and what was last published of them.
+type order struct {
+	typ    binance.OrderType
+	listId int64
+	status trading.OrderStatus
+	fills  int
+}
+
+type series struct {
+	tf   ts.Timeframe
+	bars []ts.OHLCV
+}
+
+func NewExchange(key, secret string, symbols trading.Symbols) *Exchange {
+	account := trading.NewAccount()
+	return &Exchange{
+		Key: key, Secret: secret,
+		symbols:  symbols,
+		paper:    &trading.PaperTrading{Symbols: symbols, Account: account},
+		account:  account,
+		prices:   map[string]float64{},
+		klines:   map[string]map[string]*series{},
+		orders:   map[string]*order{},
+		keys:     map[string]bool{},
+		conns:    map[*userConn]bool{},
+		lastList: -1,
+	}
+}
+
+// SetFees sets the commission rate of fills, paid in quote.
+func (e *Exchange) SetFees(rate float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paper.FeesRate = rate
+}
+
+// Deposit credits q asset to the account.
+func (e *Exchange) Deposit(asset string, q float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.account.Deposit(asset, q)
+	e.publish(true)
+}
+
+// Balances returns a copy of the account funds.
+func (e *Exchange) Balances() map[string]trading.Funds {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	funds := make(map[string]trading.Funds, len(e.account.Funds))
+	for asset, f := range e.account.Funds {
+		funds[asset] = f
+	}
+	return funds
+}
+
+// SetPrice sets the price of sym at t, resting orders reached are filled.
+func (e *Exchange) SetPrice(sym string, t time.Time, price float64) {
+	e.Update(sym, ts.OHLCV{
+		Timestamp: util.JSONTime(t), Open: price, High: price, Low: price, Close: price,
+	})
+}
+
+// Update matches resting orders of sym on bar o, its close becomes the price of sym.
+func (e *Exchange) Update(sym string, o ts.OHLCV) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.time, e.prices[sym] = o.Timestamp.T(), o.Close
+	e.paper.UpdateSymbol(sym, o)
+	e.publish(false)
+}
+
+// AddKlines adds bars of sym on tf to served klines, in time order.
+func (e *Exchange) AddKlines(sym string, tf ts.Timeframe, bars ...ts.OHLCV) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.klines[sym] == nil {
+		e.klines[sym] = map[string]*series{}
+	}
+	interval := scraper.Interval(tf)
+	s, ok := e.klines[sym][interval]
+	if !ok {
+		s = &series{tf: tf}
+		e.klines[sym][interval] = s
+	}
+	s.bars = append(s.bars, bars...)
+	sort.SliceStable(s.bars, func(i, j int) bool {
+		return s.bars[i].Timestamp.T().Before(s.bars[j].Timestamp.T())
+	})
+}
+
+// Disconnect closes user data connections, listen keys remain valid.
+func (e *Exchange) Disconnect() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.conns {
+		c.close()
+	}
+}
+
+// now is the time of the last price, or wall time.
+func (e *Exchange) now() time.Time {
+	if e.time.IsZero() {
+		return time.Now()
+	}
+	return e.time
+}
+
+// at sets paper time & price to those of sym, before placing orders.
+func (e *Exchange) at(sym string) {
+	e.paper.SetPrice(e.now(), e.prices[sym])
+}
+
+// add records order o of paper, placed as typ.
+func (e *Exchange) add(o *trading.Order, typ binance.OrderType, listId int64) {
+	e.orders[o.Id] = &order{typ: typ, listId: listId}
+	e.ids = append(e.ids, o.Id)
+}
+
+// publish sends execution reports of orders updated since last call, followed by
+// balances if any order was, or if balances is true.
+func (e *Exchange) publish(balances bool) {
+	var events []interface{}
+	for _, id := range e.ids {
+		meta := e.orders[id]
+		o, err := e.paper.GetOrder("", id)
+		if err != nil {
+			continue
+		}
+		if meta.status == "" {
+			events = append(events, e.execution(o, meta, "NEW", trading.OrderNew, 0, nil))
+			meta.status = trading.OrderNew
+		}
+		var executed float64
+		for i, t := range o.Transactions {
+			executed += t.Quantity
+			if i < meta.fills {
+				continue
+			}
+			status := trading.OrderPartiallyFilled
+			if i == len(o.Transactions)-1 {
+				status = o.Status
+			}
+			events = append(events, e.execution(o, meta, "TRADE", status, executed, t))
+			meta.status = status
+		}
+		meta.fills = len(o.Transactions)
+		if o.Status != meta.status {
+			x := string(binanceStatus(o.Status))
+			events = append(events, e.execution(o, meta, x, o.Status, executed, nil))
+			meta.status = o.Status
+		}
+	}
+	if len(events) == 0 && !balances {
+		return
+	}
+	var bals []map[string]string
+	for _, asset := range e.assets() {
+		f := e.account.Funds[asset]
+		bals = append(bals, map[string]string{"a": asset, "f": format(f.Free), "l": format(f.Locked)})
+	}
+	events = append(events, map[string]interface{}{
+		"e": "outboundAccountPosition", "E": ms(e.now()), "u": ms(e.now()), "B": bals,
+	})
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		for c := range e.conns {
+			c.send(data)
+		}
+	}
+}
+
+// execution returns an execution report of o, executed up to executed, with fill t.
+func (e *Exchange) execution(o *trading.Order, meta *order, x string, status trading.OrderStatus,
+	executed float64, t *trading.Transaction) map[string]interface{} {
+	ev := map[string]interface{}{
+		"e": "executionReport", "E": ms(e.now()), "s": o.Symbol, "c": o.ClientId,
+		"S": side(o.Direction), "o": meta.typ, "f": o.TimeInForce, "q": format(o.Quantity),
+		"p": format(o.Price), "P": format(o.StopPrice), "x": x, "X": binanceStatus(status),
+		"i": id(o.Id), "l": "0", "z": format(executed), "L": "0", "n": "0", "N": nil,
+		"T": ms(e.now()), "t": -1, "O": ms(o.Time), "g": meta.listId,
+	}
+	if t != nil {
+		ev["l"], ev["L"], ev["n"], ev["N"] = format(t.Quantity), format(t.Price), format(t.Commission), t.CommissionAsset
+		ev["T"], ev["t"] = ms(t.Time), t.Id
+	}
+	return ev
+}
+
+// assets returns assets of the account, sorted.
+func (e *Exchange) assets() []string {
+	var assets []string
+	for asset := range e.account.Funds {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	return assets
+}
+
+// userConn is a user data connection, messages are dropped with the
+// connection if it does not keep up.
+type userConn struct {
+	key      string
+	conn     *websocket.Conn
+	messages chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (c *userConn) send(data []byte) {
+	select {
+	case c.messages <- data:
+	default:
+		c.close()
+	}
+}
+
+func (c *userConn) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// serveUser streams user data events of listen key to a websocket connection.
+func (e *Exchange) serveUser(w http.ResponseWriter, r *http.Request, key string) {
+	e.mu.Lock()
+	ok := e.keys[key]
+	e.mu.Unlock()
+	if !ok {
+		apiError(w, http.StatusBadRequest, codeNoListenKey, "This listenKey does not exist.")
+		return
+	}
+	conn, err := e.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &userConn{key: key, conn: conn, messages: make(chan []byte, 256), done: make(chan struct{})}
+	e.mu.Lock()
+	e.conns[c] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.conns, c)
+		e.mu.Unlock()
+		_ = conn.Close()
+	}()
+	go func() {
+		// client messages are ignored, until it disconnects
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				c.close()
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case data := <-c.messages:
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func binanceStatus(s trading.OrderStatus) binance.OrderStatusType {
+	switch s {
+	case trading.OrderPartiallyFilled:
+		return binance.OrderStatusTypePartiallyFilled
+	case trading.OrderFilled:
+		return binance.OrderStatusTypeFilled
+	case trading.OrderCancelled:
+		return binance.OrderStatusTypeCanceled
+	case trading.OrderRejected:
+		return binance.OrderStatusTypeRejected
+	case trading.OrderExpired:
+		return binance.OrderStatusTypeExpired
+	}
+	return binance.OrderStatusTypeNew
+}
+
+func side(d trading.Direction) binance.SideType {
+	if d == trading.Buy {
+		return binance.SideTypeBuy
+	}
+	return binance.SideTypeSell
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func ms(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func id(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// endpoint returns the path of r without api version, e.g. /order.
+func endpoint(r *http.Request) string {
+	for _, prefix := range []string{"/api/v1", "/api/v3"} {
+		if strings.HasPrefix(r.URL.Path, prefix+"/") {
+			return strings.TrimPrefix(r.URL.Path, prefix)
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,442 @@
+package brokers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/adshao/go-binance"
+	"github.com/gorilla/websocket"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/util"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// UserStreamURL is binance websocket endpoint.
+	UserStreamURL = "wss://stream.binance.com:9443"
+
+	// DefaultKeepAlive is the delay between listen key keepalives when KeepAlive
+	// is unset, binance expires listen keys after an hour without one.
+	DefaultKeepAlive = time.Minute * 30
+
+	// minBackoff & maxBackoff bound delays between reconnections, doubled after each failure.
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+
+	// userStreamTimeout closes connections idle for longer, binance pings every 3 minutes.
+	userStreamTimeout = time.Minute * 5
+)
+
+// UserStream keeps a binance user data stream of an account, and applies its
+// execution reports to local orders, and account updates to local balances.
+// Both are reconciled over REST on every connection, so that events missed while
+// disconnected are accounted for. See Binance.Stream.
+type UserStream struct {
+	// URL is the websocket endpoint, UserStreamURL if empty.
+	URL string
+	// KeepAlive is the delay between listen key keepalives, DefaultKeepAlive if zero.
+	KeepAlive time.Duration
+	// Done stops the stream when closed.
+	Done chan struct{}
+
+	// b is the broker of the stream, without stream
+	b Binance
+
+	mu sync.Mutex
+	// orders by id, of the account or placed through the broker
+	orders   map[string]*trading.Order
+	balances map[string]trading.Funds
+	// synced is set once reconciled, until disconnection
+	synced bool
+
+	// minBackoff is minBackoff, replaced in tests.
+	minBackoff time.Duration
+}
+
+func NewUserStream(b Binance) *UserStream {
+	b.Stream = nil
+	return &UserStream{
+		b:        b,
+		Done:     make(chan struct{}),
+		orders:   map[string]*trading.Order{},
+		balances: map[string]trading.Funds{},
+	}
+}
+
+// Stop stops the stream.
+func (s *UserStream) Stop() {
+	close(s.Done)
+}
+
+// Synced returns true while the stream is connected and reconciled, local
+// orders & balances are then up to date.
+func (s *UserStream) Synced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.synced
+}
+
+// Balances returns a copy of local balances.
+func (s *UserStream) Balances() map[string]trading.Funds {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	balances := make(map[string]trading.Funds, len(s.balances))
+	for asset, f := range s.balances {
+		balances[asset] = f
+	}
+	return balances
+}
+
+// Order returns a copy of local order id, or nil if unknown.
+func (s *UserStream) Order(id string) *trading.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.orders[id]; ok {
+		return copyOrder(o)
+	}
+	return nil
+}
+
+// FindOrder returns a copy of local order of sym by its client id, or nil if unknown.
+func (s *UserStream) FindOrder(sym, clientId string) *trading.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orders {
+		if o.Symbol == sym && o.ClientId == clientId {
+			return copyOrder(o)
+		}
+	}
+	return nil
+}
+
+// Track adds o to local orders, unless the stream already knows of more fills.
+func (s *UserStream) Track(o *trading.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.track(o)
+}
+
+func (s *UserStream) track(o *trading.Order) {
+	if prev, ok := s.orders[o.Id]; ok && prev.Executed() > o.Executed() {
+		return
+	}
+	s.orders[o.Id] = copyOrder(o)
+}
+
+// Run connects the stream until Done is closed, reconnecting with backoff.
+func (s *UserStream) Run() {
+	min := s.minBackoff
+	if min <= 0 {
+		min = minBackoff
+	}
+	backoff := min
+	for {
+		received, err := s.serve()
+		if err == nil {
+			// done
+			return
+		}
+		log.Printf("user stream: %s", err)
+		if received {
+			backoff = min
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.Done:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// serve connects with a new listen key, reconciles, then applies events until the
+// connection fails, or Done is closed in which case err is nil. received is true
+// if an event was received.
+func (s *UserStream) serve() (received bool, err error) {
+	ctx := context.Background()
+	key, err := s.b.NewStartUserStreamService().Do(ctx)
+	if err != nil {
+		return false, fmt.Errorf("listen key: %s", err)
+	}
+	base := s.URL
+	if base == "" {
+		base = UserStreamURL
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(base+"/ws/"+key, nil)
+	if err != nil {
+		return false, fmt.Errorf("dial: %s", err)
+	}
+	closed := make(chan struct{})
+	defer func() {
+		s.mu.Lock()
+		s.synced = false
+		s.mu.Unlock()
+		close(closed)
+	}()
+	go s.keepAlive(conn, key, closed)
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(userStreamTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second*10))
+	})
+
+	// events received meanwhile are applied next
+	if err = s.reconcile(); err != nil {
+		return false, fmt.Errorf("reconcile: %s", err)
+	}
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(userStreamTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-s.Done:
+				return received, nil
+			default:
+				return received, fmt.Errorf("read: %s", err)
+			}
+		}
+		var event userEvent
+		if err = json.Unmarshal(data, &event); err != nil {
+			log.Printf("user stream: invalid message %s: %s", data, err)
+			continue
+		}
+		received = true
+		switch event.Event {
+		case "executionReport":
+			if err = s.execution(event); err != nil {
+				log.Printf("user stream: %s", err)
+			}
+		case "outboundAccountPosition":
+			s.account(event)
+		case "listenKeyExpired":
+			return received, fmt.Errorf("listen key expired")
+		}
+	}
+}
+
+// keepAlive extends listen key until closed, then closes conn, and the
+// listen key if Done was closed.
+func (s *UserStream) keepAlive(conn *websocket.Conn, key string, closed chan struct{}) {
+	period := s.KeepAlive
+	if period <= 0 {
+		period = DefaultKeepAlive
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.b.NewKeepaliveUserStreamService().ListenKey(key).Do(context.Background()); err != nil {
+				log.Printf("user stream: keepalive: %s", err)
+			}
+		case <-s.Done:
+			_ = conn.Close()
+			if err := s.b.NewCloseUserStreamService().ListenKey(key).Do(context.Background()); err != nil {
+				log.Printf("user stream: closing listen key: %s", err)
+			}
+			return
+		case <-closed:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// reconcile replaces local balances & open orders with account state over REST,
+// orders no longer open are queried for their final state & fills.
+func (s *UserStream) reconcile() error {
+	balances, err := s.b.balances()
+	if err != nil {
+		return err
+	}
+	for asset, f := range balances {
+		if f.Total() == 0 {
+			delete(balances, asset)
+		}
+	}
+	resp, err := s.b.NewListOpenOrdersService().Do(context.Background())
+	if err != nil {
+		return binanceError(err)
+	}
+	open := map[string]*trading.Order{}
+	for _, r := range resp {
+		o, err := s.b.order(r)
+		if err != nil {
+			return err
+		}
+		open[o.Id] = o
+	}
+	var closed []trading.Order
+	s.mu.Lock()
+	for id, o := range s.orders {
+		if _, ok := open[id]; !ok && o.Open() {
+			closed = append(closed, *o)
+		}
+	}
+	s.mu.Unlock()
+	for i, o := range closed {
+		up, err := s.b.restOrder(o.Symbol, o.Id)
+		if err != nil {
+			return err
+		}
+		up.Base, up.Quote = o.Base, o.Quote
+		closed[i] = *up
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances = balances
+	for _, o := range open {
+		if prev, ok := s.orders[o.Id]; ok {
+			o.Base, o.Quote = prev.Base, prev.Quote
+		}
+		s.orders[o.Id] = o
+	}
+	for i := range closed {
+		s.orders[closed[i].Id] = &closed[i]
+	}
+	s.synced = true
+	return nil
+}
+
+// userEvent is the payload of user data events. json keys are case insensitive,
+// keys differing by case only must all be declared.
+type userEvent struct {
+	Event     string `json:"e"`
+	EventTime int64  `json:"E"`
+
+	// executionReport
+	Symbol          string `json:"s"`
+	Side            string `json:"S"`
+	ClientOrderID   string `json:"c"`
+	OrigClientID    string `json:"C"`
+	Type            string `json:"o"`
+	CreationTime    int64  `json:"O"`
+	TimeInForce     string `json:"f"`
+	IcebergQuantity string `json:"F"`
+	Quantity        string `json:"q"`
+	QuoteQuantity   string `json:"Q"`
+	Price           string `json:"p"`
+	StopPrice       string `json:"P"`
+	ExecutionType   string `json:"x"`
+	Status          string `json:"X"`
+	OrderID         int64  `json:"i"`
+	Ignore          int64  `json:"I"`
+	LastQuantity    string `json:"l"`
+	LastPrice       string `json:"L"`
+	Executed        string `json:"z"`
+	QuoteExecuted   string `json:"Z"`
+	Commission      string `json:"n"`
+	CommissionAsset string `json:"N"`
+	TradeID         int64  `json:"t"`
+	TradeTime       int64  `json:"T"`
+
+	// outboundAccountPosition
+	Balances []struct {
+		Asset  string `json:"a"`
+		Free   string `json:"f"`
+		Locked string `json:"l"`
+	} `json:"B"`
+}
+
+// execution applies an execution report to its order, which is queried over
+// REST if fills were missed.
+func (s *UserStream) execution(e userEvent) error {
+	id := strconv.FormatInt(e.OrderID, 10)
+	executed, err := strconv.ParseFloat(e.Executed, 64)
+	if err != nil {
+		return fmt.Errorf("order %s: bad executed quantity: %s", id, err)
+	}
+	if s.apply(id, e, executed) {
+		return nil
+	}
+	o, err := s.b.restOrder(e.Symbol, id)
+	if err != nil {
+		return fmt.Errorf("order %s: %s", id, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.orders[id]; ok {
+		o.Base, o.Quote = prev.Base, prev.Quote
+	}
+	s.orders[id] = o
+	return nil
+}
+
+// apply applies e to order id, it returns false if e is not the next fill of the
+// order, which must then be queried.
+func (s *UserStream) apply(id string, e userEvent, executed float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		// placed elsewhere, or before the broker got its response
+		o = &trading.Order{
+			Id:          id,
+			ClientId:    e.ClientOrderID,
+			Symbol:      e.Symbol,
+			Direction:   binance.SideType(e.Side) == binance.SideTypeBuy,
+			Type:        orderType(binance.OrderType(e.Type)),
+			TimeInForce: trading.TimeInForce(e.TimeInForce),
+			Time:        util.UnixToTime(e.CreationTime),
+		}
+		o.Quantity, _ = strconv.ParseFloat(e.Quantity, 64)
+		o.Price, _ = strconv.ParseFloat(e.Price, 64)
+		o.StopPrice, _ = strconv.ParseFloat(e.StopPrice, 64)
+		s.orders[id] = o
+	}
+	o.Status = orderStatus(binance.OrderStatusType(e.Status))
+	prev := o.Executed()
+	if executed <= prev*(1+1e-9) {
+		// nothing new, or already applied
+		return true
+	}
+	q, _ := strconv.ParseFloat(e.LastQuantity, 64)
+	if e.ExecutionType != "TRADE" || prev+q < executed*(1-1e-9) {
+		return false
+	}
+	p, _ := strconv.ParseFloat(e.LastPrice, 64)
+	fee, _ := strconv.ParseFloat(e.Commission, 64)
+	o.Transactions = append(o.Transactions, &trading.Transaction{
+		Id:              int(e.OrderID),
+		OrderId:         id,
+		Time:            util.UnixToTime(e.TradeTime),
+		Direction:       o.Direction,
+		Quantity:        q,
+		Price:           p,
+		Commission:      fee,
+		CommissionAsset: e.CommissionAsset,
+	})
+	return true
+}
+
+// account applies balances of an account update.
+func (s *UserStream) account(e userEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bal := range e.Balances {
+		free, _ := strconv.ParseFloat(bal.Free, 64)
+		locked, _ := strconv.ParseFloat(bal.Locked, 64)
+		if free+locked == 0 {
+			delete(s.balances, bal.Asset)
+			continue
+		}
+		s.balances[bal.Asset] = trading.Funds{Free: free, Locked: locked}
+	}
+}
+
+// copyOrder returns a copy of o, with copies of its transactions.
+func copyOrder(o *trading.Order) *trading.Order {
+	c := *o
+	c.Transactions = make([]*trading.Transaction, len(o.Transactions))
+	for i, t := range o.Transactions {
+		tc := *t
+		c.Transactions[i] = &tc
+	}
+	return &c
+}
@@ -0,0 +1,287 @@
+package brokers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/adshao/go-binance"
+	"github.com/gorilla/websocket"
+	"github.com/rkjdid/gocx/trading"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// userServer fakes binance REST endpoints of a user stream, and its websocket.
+type userServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu         sync.Mutex
+	balances   []binance.Balance
+	orders     map[int64]*binance.Order
+	trades     []*binance.TradeV3
+	keepalives int
+	// events are sent to the connected client, drop closes its connection
+	events chan string
+	drop   chan struct{}
+}
+
+func newUserServer(t *testing.T) *userServer {
+	s := &userServer{
+		t: t, orders: map[int64]*binance.Order{},
+		events: make(chan string), drop: make(chan struct{}),
+	}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var v interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/ws/"):
+			s.mu.Unlock()
+			s.serveWs(upgrader, w, r)
+			s.mu.Lock()
+			return
+		case strings.HasSuffix(r.URL.Path, "/userDataStream"):
+			if r.Method == http.MethodPut {
+				s.keepalives++
+			}
+			v = map[string]string{"listenKey": "key"}
+		case r.URL.Path == "/api/v3/account":
+			v = binance.Account{Balances: s.balances}
+		case r.URL.Path == "/api/v3/openOrders":
+			open := []*binance.Order{}
+			for _, o := range s.orders {
+				if o.Status == binance.OrderStatusTypeNew || o.Status == binance.OrderStatusTypePartiallyFilled {
+					open = append(open, o)
+				}
+			}
+			v = open
+		case r.URL.Path == "/api/v3/order":
+			id, _ := strconv.ParseInt(r.URL.Query().Get("orderId"), 10, 64)
+			o, ok := s.orders[id]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				v = map[string]interface{}{"code": codeNoSuchOrder, "msg": "Order does not exist."}
+				break
+			}
+			v = o
+		case r.URL.Path == "/api/v3/myTrades":
+			v = s.trades
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}))
+	return s
+}
+
+func (s *userServer) serveWs(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ws/key" {
+		s.t.Errorf("unexpected listen key in %s", r.URL.Path)
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	defer conn.Close()
+	closed := make(chan struct{})
+	go func() {
+		_, _, _ = conn.ReadMessage()
+		close(closed)
+	}()
+	for {
+		select {
+		case msg := <-s.events:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				s.t.Error(err)
+			}
+		case <-s.drop:
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (s *userServer) set(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
+
+// execution returns an execution report of a sell order id of BTCUSDT, filled
+// by last, up to executed.
+func execution(id int64, status string, last, executed, price float64) string {
+	x := "TRADE"
+	if last == 0 {
+		x = "NEW"
+	}
+	return fmt.Sprintf(`{"e":"executionReport","E":1,"s":"BTCUSDT","c":"client%[1]d","S":"SELL",`+
+		`"o":"LIMIT","f":"GTC","q":"1","p":"%[5]g","P":"0","x":"%[6]s","X":"%[2]s","i":%[1]d,`+
+		`"l":"%[3]g","z":"%[4]g","L":"%[5]g","n":"0","N":"USDT","T":1000,"t":1,"O":1000}`,
+		id, status, last, executed, price, x)
+}
+
+func accountPosition(balances ...binance.Balance) string {
+	data, _ := json.Marshal(balances)
+	s := strings.NewReplacer(`"asset"`, `"a"`, `"free"`, `"f"`, `"locked"`, `"l"`).Replace(string(data))
+	return `{"e":"outboundAccountPosition","E":1,"u":1,"B":` + s + `}`
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestUserStream(t *testing.T) {
+	srv := newUserServer(t)
+	defer srv.Close()
+	srv.balances = []binance.Balance{{Asset: "BTC", Free: "1", Locked: "0"}, {Asset: "USDT", Free: "0", Locked: "0"}}
+	srv.orders[1] = &binance.Order{
+		Symbol: "BTCUSDT", OrderID: 1, ClientOrderID: "client1", Price: "100", OrigQuantity: "1",
+		ExecutedQuantity: "0", Status: binance.OrderStatusTypeNew, TimeInForce: binance.TimeInForceTypeGTC,
+		Type: binance.OrderTypeLimit, Side: binance.SideTypeSell, Time: 1000,
+	}
+
+	b := NewBinanceBroker("test", "key", "secret")
+	b.BaseURL = srv.URL
+	b.Stream = NewUserStream(*b)
+	b.Stream.URL, b.Stream.KeepAlive, b.Stream.minBackoff = "ws"+strings.TrimPrefix(srv.URL, "http"),
+		time.Millisecond*10, time.Millisecond
+	go b.Stream.Run()
+	defer b.Stream.Stop()
+
+	eventually(t, "sync", b.Stream.Synced)
+	if bal := b.Stream.Balances(); len(bal) != 1 || bal["BTC"].Free != 1 {
+		t.Errorf("unexpected balances after sync: %v", bal)
+	}
+	if o := b.Stream.Order("1"); o == nil || o.Status != trading.OrderNew || o.Direction != trading.Sell {
+		t.Fatalf("open order not reconciled: %v", o)
+	}
+
+	// partial fill, applied once
+	srv.events <- execution(1, "PARTIALLY_FILLED", 0.4, 0.4, 100)
+	srv.events <- execution(1, "PARTIALLY_FILLED", 0.4, 0.4, 100)
+	srv.events <- accountPosition(
+		binance.Balance{Asset: "BTC", Free: "0", Locked: "0.6"},
+		binance.Balance{Asset: "USDT", Free: "40", Locked: "0"},
+	)
+	eventually(t, "account update", func() bool { return b.Stream.Balances()["USDT"].Free == 40 })
+	o, err := b.GetOrder("BTCUSDT", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != trading.OrderPartiallyFilled || len(o.Transactions) != 1 || o.Executed() != 0.4 {
+		t.Errorf("unexpected order after partial fill: %s, %d fills of %f", o, len(o.Transactions), o.Executed())
+	}
+	if bal := b.Stream.Balances()["BTC"]; bal.Free != 0 || bal.Locked != 0.6 {
+		t.Errorf("unexpected BTC balance: %v", bal)
+	}
+	s, err := b.balances()
+	if err != nil || s["USDT"].Free != 40 {
+		t.Errorf("expected balances of stream, got %v, %v", s, err)
+	}
+
+	// manual order, with a fill missed: it is queried
+	srv.set(func() {
+		srv.orders[2] = &binance.Order{
+			Symbol: "BTCUSDT", OrderID: 2, ClientOrderID: "client2", Price: "90", OrigQuantity: "1",
+			ExecutedQuantity: "1", Status: binance.OrderStatusTypeFilled, Type: binance.OrderTypeLimit,
+			Side: binance.SideTypeSell, Time: 1000,
+		}
+		srv.trades = []*binance.TradeV3{
+			{OrderID: 2, Price: "90", Quantity: "0.5", Commission: "0", Time: 1000},
+			{OrderID: 2, Price: "90", Quantity: "0.5", Commission: "0", Time: 1001},
+		}
+	})
+	srv.events <- execution(2, "FILLED", 0.5, 1, 90)
+	eventually(t, "manual order", func() bool {
+		o := b.Stream.Order("2")
+		return o != nil && o.Status == trading.OrderFilled
+	})
+	if o := b.Stream.FindOrder("BTCUSDT", "client2"); o == nil || len(o.Transactions) != 2 {
+		t.Errorf("expected manual order fills queried, got %v", o)
+	}
+
+	// order 1 fills while disconnected
+	srv.set(func() {
+		srv.orders[1].Status, srv.orders[1].ExecutedQuantity = binance.OrderStatusTypeFilled, "1"
+		srv.trades = []*binance.TradeV3{
+			{OrderID: 1, Price: "100", Quantity: "0.4", Commission: "0", Time: 1000},
+			{OrderID: 1, Price: "100", Quantity: "0.6", Commission: "0", Time: 1001},
+		}
+		srv.balances = []binance.Balance{{Asset: "BTC", Free: "0", Locked: "0"}, {Asset: "USDT", Free: "100", Locked: "0"}}
+	})
+	srv.drop <- struct{}{}
+	eventually(t, "reconciliation", func() bool {
+		o := b.Stream.Order("1")
+		return b.Stream.Synced() && o.Status == trading.OrderFilled
+	})
+	if o := b.Stream.Order("1"); len(o.Transactions) != 2 || o.Executed() != 1 {
+		t.Errorf("expected 2 fills of 1 after reconciliation, got %d of %f", len(o.Transactions), o.Executed())
+	}
+	if bal := b.Stream.Balances(); len(bal) != 1 || bal["USDT"].Free != 100 {
+		t.Errorf("unexpected balances after reconciliation: %v", bal)
+	}
+	srv.set(func() {
+		if srv.keepalives == 0 {
+			t.Errorf("expected listen key keepalives")
+		}
+	})
+}
+
+func TestUserStream_Exchange(t *testing.T) {
+	b, e, srv := fakeBinance(t)
+	defer srv.Close()
+	b.Stream = NewUserStream(*b)
+	b.Stream.URL, b.Stream.minBackoff = "ws"+strings.TrimPrefix(srv.URL, "http"), time.Millisecond
+	go b.Stream.Run()
+	defer b.Stream.Stop()
+	eventually(t, "sync", b.Stream.Synced)
+
+	o, err := b.PlaceOrder(trading.Order{
+		Symbol: "ABCBTC", Direction: trading.Buy, Type: trading.LimitOrder, Quantity: 10, Price: 0.009,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetPrice("ABCBTC", t0.Add(time.Hour), 0.009)
+	eventually(t, "fill", func() bool {
+		o := b.Stream.Order(o.Id)
+		return o.Status == trading.OrderFilled && b.Stream.Balances()["ABC"].Free == 10
+	})
+
+	// manual trade, while disconnected
+	manual := *b
+	manual.Stream = nil
+	e.Disconnect()
+	if _, err = manual.MarketSell("ABCBTC", 4); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "reconciliation", func() bool {
+		return b.Stream.Synced() && b.Stream.Balances()["ABC"].Free == 6
+	})
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal := s.Balances["ABC"]; bal.Total != 6 {
+		t.Errorf("expected 6 ABC in snapshot, got %v", bal)
+	}
+}
@@ -12,5 +12,8 @@ type DataSource interface {
 
 type Tick struct {
 	Timeframe ts.Timeframe
+	// Base & Quote identify the market of the tick, they may be empty
+	// for single market sources.
+	Base, Quote string
 	ts.OHLCV
 }
@@ -0,0 +1,249 @@
+package trading
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Instrument is the kind of contract a position trades.
+type Instrument string
+
+const (
+	Spot      = Instrument("spot")
+	Perpetual = Instrument("perpetual")
+)
+
+// Perpetuals enables perpetual futures on PaperTrading: orders open & close
+// contracts margined in quote, instead of exchanging base for quote.
+type Perpetuals struct {
+	// Leverage is the ratio of position notional to its margin.
+	Leverage float64
+	// MaintenanceRate is the ratio of margin to notional, at mark price, under
+	// which a position is liquidated. 0 disables liquidation.
+	MaintenanceRate float64
+	// Funding holds funding rates history by symbol.
+	Funding map[string]FundingRates
+}
+
+// FundingRate is paid by longs to shorts at Time, shorts pay when negative.
+type FundingRate struct {
+	Time time.Time
+	Rate float64
+	// MarkPrice funding is computed on, bar close if unset.
+	MarkPrice float64
+}
+
+// FundingRates history, sorted by time.
+type FundingRates []FundingRate
+
+// Between returns rates in (from, to].
+func (fr FundingRates) Between(from, to time.Time) FundingRates {
+	i := sort.Search(len(fr), func(i int) bool { return fr[i].Time.After(from) })
+	j := sort.Search(len(fr), func(i int) bool { return fr[i].Time.After(to) })
+	return fr[i:j]
+}
+
+// ReadFundingRates reads csv records of time, rate & an optional mark price.
+// Time is either RFC3339 or unix milliseconds, a header line is skipped.
+func ReadFundingRates(r io.Reader) (FundingRates, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rates FundingRates
+	for i, rec := range records {
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected time & rate", i+1)
+		}
+		var fr FundingRate
+		if ms, err := strconv.ParseInt(rec[0], 10, 64); err == nil {
+			fr.Time = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+		} else if fr.Time, err = time.Parse(time.RFC3339, rec[0]); err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		if fr.Rate, err = strconv.ParseFloat(rec[1], 64); err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		if len(rec) > 2 && rec[2] != "" {
+			if fr.MarkPrice, err = strconv.ParseFloat(rec[2], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+		}
+		rates = append(rates, fr)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Time.Before(rates[j].Time) })
+	return rates, nil
+}
+
+// contract is an open perpetual position of PaperTrading.
+type contract struct {
+	Base, Quote string
+	// Size is positive for longs, negative for shorts.
+	Size   float64
+	Entry  float64
+	Margin float64
+	// Mark is the last mark price.
+	Mark float64
+	// Funding paid since the contract was opened, negative if received.
+	Funding float64
+	// Liquidations are orders closing the contract.
+	Liquidations []*Order
+
+	fundedAt time.Time
+}
+
+// leverage returns Perpetuals leverage, 1 if unset.
+func (pp Perpetuals) leverage() float64 {
+	if pp.Leverage <= 0 {
+		return 1
+	}
+	return pp.Leverage
+}
+
+// fillContract applies fill t of o to the contract of o symbol: it realizes pnl
+// of the reduced size, and locks margin of the increased size.
+func (p *PaperTrading) fillContract(o *Order, t *Transaction) error {
+	base, quote, err := p.assets(o)
+	if err != nil {
+		return err
+	}
+	if p.contracts == nil {
+		p.contracts = make(map[string]*contract)
+	}
+	c, ok := p.contracts[o.Symbol]
+	if !ok || c.Size == 0 {
+		c = &contract{Base: base, Quote: quote, Mark: t.Price, fundedAt: t.Time}
+	}
+	q := t.Quantity
+	if t.Direction == Sell {
+		q = -q
+	}
+
+	// reduced part of the fill
+	var reduced, pnl, released float64
+	if c.Size*q < 0 {
+		reduced = math.Min(math.Abs(q), math.Abs(c.Size))
+		pnl = reduced * (t.Price - c.Entry)
+		if c.Size < 0 {
+			pnl = -pnl
+		}
+		released = c.Margin * reduced / math.Abs(c.Size)
+	}
+	increased := math.Abs(q) - reduced
+	margin := increased * t.Price / p.Perpetuals.leverage()
+	// reducing never fails, even when losses exceed funds
+	if increased > 0 && !enough(p.Account.Free(quote)+released+pnl, margin+t.Commission) {
+		return ErrInsufficientFunds
+	}
+
+	p.Account.Unlock(quote, released)
+	p.Account.Deposit(quote, pnl-t.Commission)
+	if err := p.Account.Lock(quote, margin); err != nil {
+		return err
+	}
+	c.Margin += margin - released
+	if c.Size*q < 0 && increased == 0 {
+		c.Size += q
+	} else {
+		// increase, or flip to the other side
+		size := c.Size + q
+		if c.Size*size > 0 {
+			c.Entry = (c.Entry*math.Abs(c.Size) + t.Price*increased) / math.Abs(size)
+		} else {
+			c.Entry = t.Price
+		}
+		c.Size = size
+	}
+	if math.Abs(c.Size) < 1e-12 {
+		c.Size = 0
+	}
+	p.contracts[o.Symbol] = c
+	return nil
+}
+
+// Funding returns funding paid on the current, or last, contract of sym.
+func (p PaperTrading) Funding(sym string) float64 {
+	if c, ok := p.contracts[sym]; ok {
+		return c.Funding
+	}
+	return 0
+}
+
+// MarkPrice returns the last mark price of sym contract.
+func (p PaperTrading) MarkPrice(sym string) float64 {
+	if c, ok := p.contracts[sym]; ok {
+		return c.Mark
+	}
+	return 0
+}
+
+// settleContracts charges funding on contracts of sym, all of them if sym is empty,
+// up to bar o, and liquidates those whose margin falls below maintenance.
+func (p *PaperTrading) settleContracts(sym string, o ts.OHLCV) {
+	if p.Perpetuals == nil || p.Account == nil {
+		return
+	}
+	t := o.Timestamp.T()
+	for s, c := range p.contracts {
+		if sym != "" && s != sym || c.Size == 0 {
+			continue
+		}
+		c.Mark = o.Close
+		for _, fr := range p.Perpetuals.Funding[s].Between(c.fundedAt, t) {
+			mark := fr.MarkPrice
+			if mark == 0 {
+				mark = o.Close
+			}
+			payment := c.Size * mark * fr.Rate
+			p.Account.Deposit(c.Quote, -payment)
+			c.Funding += payment
+		}
+		if t.After(c.fundedAt) {
+			c.fundedAt = t
+		}
+
+		margin := c.Margin + c.Size*(c.Mark-c.Entry)
+		if p.Perpetuals.MaintenanceRate > 0 && margin < p.Perpetuals.MaintenanceRate*math.Abs(c.Size)*c.Mark {
+			if err := p.liquidateContract(s, c); err != nil {
+				log.Printf("paper: liquidating %s: %s", s, err)
+			}
+		}
+	}
+}
+
+// liquidateContract cancels open orders of sym and closes c at market.
+func (p *PaperTrading) liquidateContract(sym string, c *contract) error {
+	for _, o := range p.resting {
+		if o.Symbol == sym && o.Open() {
+			_, _ = p.CancelOrder(sym, o.Id)
+		}
+	}
+	direction := Sell
+	if c.Size < 0 {
+		direction = Buy
+	}
+	q := math.Abs(c.Size)
+	o := p.add(Order{
+		Symbol: sym, Base: c.Base, Quote: c.Quote, Direction: direction, Type: MarketOrder, Quantity: q,
+	})
+	o.ClientId = "liquidation-" + o.Id
+	if err := p.fillOrder(o, p.slip(direction, q, p.Price)); err != nil {
+		_ = o.SetStatus(OrderRejected)
+		return err
+	}
+	c.Liquidations = append(c.Liquidations, o)
+	return nil
+}
@@ -0,0 +1,83 @@
+package trading
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFundingRates(t *testing.T) {
+	rates, err := ReadFundingRates(strings.NewReader(`time,rate,mark
+2019-01-01T16:00:00Z,-0.0001,
+1546300800000,0.0001,3800.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[0].Rate != 0.0001 || rates[0].MarkPrice != 3800.5 || rates[1].Rate != -0.0001 {
+		t.Fatalf("unexpected rates: %+v", rates)
+	}
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	if r := rates.Between(t0, t0.Add(time.Hour*16)); len(r) != 1 || r[0].Rate != -0.0001 {
+		t.Errorf("expected rates after t0 only, got %+v", r)
+	}
+	if _, err := ReadFundingRates(strings.NewReader("1546300800000,x\n")); err == nil {
+		t.Errorf("expected invalid rate to fail")
+	}
+}
+
+func TestPaperTrading_Perpetuals(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a, Perpetuals: &Perpetuals{
+		Leverage: 5,
+		Funding:  map[string]FundingRates{"ABCBTC": {{Time: dayBar(1, 0).Timestamp.T(), Rate: 0.001}}},
+	}}
+	pt.Update(dayBar(0, 10))
+	p := NewPosition(pt, "ABC", "BTC", Long)
+	p.Instrument = Perpetual
+	if err := p.MarketBuy(0.6); err == nil {
+		t.Errorf("expected notional above 5 times capital to be rejected")
+	}
+	if err := p.MarketBuy(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if a.Free("BTC") != 0 || a.Locked("BTC") != 1 || a.Total("ABC") != 0 {
+		t.Errorf("expected capital locked as margin, got %s", a)
+	}
+
+	// longs pay funding on mark price
+	p.SetTick(dayBar(1, 11))
+	if !almostEq(p.Funding, 0.0055) || !almostEq(p.Value(), 0.5) {
+		t.Errorf("expected 0.0055 funding paid & 0.5 unrealized, got %f, %f", p.Funding, p.Value())
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(p.Net(), 0.5-0.0055) || !almostEq(a.Free("BTC"), 1+p.Net()) || a.Locked("BTC") != 0 {
+		t.Errorf("unexpected net %f: %s", p.Net(), a)
+	}
+}
+
+func TestPaperTrading_PerpetualsLiquidation(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a, Perpetuals: &Perpetuals{Leverage: 5, MaintenanceRate: 0.05}}
+	pt.Update(dayBar(0, 10))
+	p := NewPosition(pt, "ABC", "BTC", Long)
+	p.Instrument = Perpetual
+	_ = p.MarketBuy(0.5)
+
+	// margin 1 - 0.5*1.5 is above 5% of 0.5*8.5
+	p.SetTick(dayBar(1, 8.5))
+	if !p.Active() {
+		t.Fatalf("expected position to stay open at 8.5")
+	}
+	p.SetTick(dayBar(2, 8.4))
+	if p.State != Closed || p.AvgExit != 8.4 {
+		t.Fatalf("expected position liquidated at 8.4, got %s", p)
+	}
+	if !almostEq(a.Total("BTC"), 0.2) || !almostEq(a.Locked("BTC"), 0) {
+		t.Errorf("expected 0.2 BTC left, got %s", a)
+	}
+}
@@ -0,0 +1,52 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"math"
+)
+
+// ATR is the average true range of bars over Period, smoothed like talib.Atr.
+type ATR struct {
+	Period int
+
+	n     int
+	sum   float64
+	prev  ts.OHLCV
+	value float64
+}
+
+func NewATR(period int) *ATR {
+	checkPeriod("atr", period)
+	return &ATR{Period: period}
+}
+
+func (a *ATR) Add(o ts.OHLCV) {
+	a.n++
+	if a.n == 1 {
+		a.prev = o
+		return
+	}
+	tr := math.Max(o.High-o.Low, math.Max(math.Abs(o.High-a.prev.Close), math.Abs(o.Low-a.prev.Close)))
+	a.prev = o
+	period := float64(a.Period)
+	switch {
+	case a.n <= a.Period:
+		a.sum += tr
+	case a.n == a.Period+1:
+		a.value = (a.sum + tr) / period
+	default:
+		a.value = (a.value*(period-1) + tr) / period
+	}
+}
+
+func (a *ATR) Value() float64 {
+	return a.value
+}
+
+func (a *ATR) Ready() bool {
+	return a.n > a.Period
+}
+
+func (a *ATR) WarmUp() int {
+	return a.Period + 1
+}
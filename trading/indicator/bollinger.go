@@ -0,0 +1,107 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"math"
+)
+
+// StdDev is the standard deviation of closes over Period, times Dev.
+type StdDev struct {
+	Period int
+	Dev    float64
+
+	n              int
+	total, totalSq float64
+	window         []float64
+	value          float64
+}
+
+func NewStdDev(period int, dev float64) *StdDev {
+	checkPeriod("stddev", period)
+	return &StdDev{Period: period, Dev: dev, window: make([]float64, period)}
+}
+
+func (s *StdDev) Add(o ts.OHLCV) {
+	s.Update(o.Close)
+}
+
+// Update adds value x, as talib.StdDev does.
+func (s *StdDev) Update(x float64) {
+	i := s.n
+	s.n++
+	s.window[i%s.Period] = x
+	s.total += x
+	s.totalSq += x * x
+	if s.n < s.Period {
+		return
+	}
+	period := float64(s.Period)
+	mean, meanSq := s.total/period, s.totalSq/period
+	old := s.window[(i+1)%s.Period]
+	s.total -= old
+	s.totalSq -= old * old
+	if variance := meanSq - mean*mean; !(variance < 1e-14) {
+		s.value = math.Sqrt(variance) * s.Dev
+	} else {
+		s.value = 0
+	}
+}
+
+func (s *StdDev) Value() float64 {
+	return s.value
+}
+
+func (s *StdDev) Ready() bool {
+	return s.n >= s.Period
+}
+
+func (s *StdDev) WarmUp() int {
+	return s.Period
+}
+
+// Bollinger bands are Up & Down standard deviations of closes above & below
+// their SMA over Period. Value is the middle band.
+type Bollinger struct {
+	Period   int
+	Up, Down float64
+
+	sma    *SMA
+	stddev *StdDev
+}
+
+func NewBollinger(period int, up, down float64) *Bollinger {
+	return &Bollinger{
+		Period: period, Up: up, Down: down,
+		sma: NewSMA(period), stddev: NewStdDev(period, 1),
+	}
+}
+
+func (b *Bollinger) Add(o ts.OHLCV) {
+	b.Update(o.Close)
+}
+
+// Update adds value x, as talib.BBands with a simple moving average does.
+func (b *Bollinger) Update(x float64) {
+	b.sma.Update(x)
+	b.stddev.Update(x)
+}
+
+func (b *Bollinger) Value() float64 {
+	return b.sma.Value()
+}
+
+func (b *Bollinger) Upper() float64 {
+	return b.sma.Value() + b.stddev.Value()*b.Up
+}
+
+func (b *Bollinger) Lower() float64 {
+	return b.sma.Value() - b.stddev.Value()*b.Down
+}
+
+func (b *Bollinger) Ready() bool {
+	return b.sma.Ready()
+}
+
+func (b *Bollinger) WarmUp() int {
+	return b.Period
+}
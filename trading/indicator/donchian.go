@@ -0,0 +1,90 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+)
+
+// Donchian channel of bars over Period: Upper is their highest high, Lower
+// their lowest low, Value the middle of both.
+type Donchian struct {
+	Period int
+
+	high, low *extremum
+}
+
+func NewDonchian(period int) *Donchian {
+	checkPeriod("donchian", period)
+	return &Donchian{
+		Period: period,
+		high:   &extremum{period: period, max: true},
+		low:    &extremum{period: period},
+	}
+}
+
+func (d *Donchian) Add(o ts.OHLCV) {
+	d.high.update(o.High)
+	d.low.update(o.Low)
+}
+
+func (d *Donchian) Upper() float64 {
+	return d.high.value()
+}
+
+func (d *Donchian) Lower() float64 {
+	return d.low.value()
+}
+
+func (d *Donchian) Value() float64 {
+	if !d.Ready() {
+		return 0
+	}
+	return (d.Upper() + d.Lower()) / 2
+}
+
+func (d *Donchian) Ready() bool {
+	return d.high.n >= d.Period
+}
+
+func (d *Donchian) WarmUp() int {
+	return d.Period
+}
+
+// extremum is the max, or min, of the last period values, as talib.Max &
+// talib.Min. Candidates are kept in a monotonic queue, for amortized constant
+// time updates.
+type extremum struct {
+	period int
+	max    bool
+
+	n int
+	// values and their index, from the extremum to the last value
+	values  []float64
+	indexes []int
+}
+
+func (e *extremum) update(x float64) {
+	for len(e.values) > 0 && e.dominates(x, e.values[len(e.values)-1]) {
+		e.values, e.indexes = e.values[:len(e.values)-1], e.indexes[:len(e.indexes)-1]
+	}
+	e.values, e.indexes = append(e.values, x), append(e.indexes, e.n)
+	e.n++
+	if e.indexes[0] <= e.n-1-e.period {
+		e.values, e.indexes = e.values[1:], e.indexes[1:]
+	}
+}
+
+// dominates is true if x replaces y as candidate, ties go to the latest.
+func (e *extremum) dominates(x, y float64) bool {
+	if e.max {
+		return x >= y
+	}
+	return x <= y
+}
+
+// value is 0 until period values were added.
+func (e *extremum) value() float64 {
+	if e.n < e.period {
+		return 0
+	}
+	return e.values[0]
+}
@@ -0,0 +1,90 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+)
+
+// Ichimoku cloud of bars. Tenkan & Kijun are the middles of Donchian channels
+// over their periods. The cloud spans are displaced Kijun bars forward: SpanA
+// & SpanB are those plotted at the last bar, i.e. computed Kijun bars before.
+// Value is the Kijun.
+type Ichimoku struct {
+	Tenkan, Kijun, Senkou int
+
+	n                     int
+	tenkan, kijun, senkou *Donchian
+	// spans computed over the last Kijun+1 bars
+	spansA, spansB []float64
+}
+
+func NewIchimoku(tenkan, kijun, senkou int) *Ichimoku {
+	return &Ichimoku{
+		Tenkan: tenkan, Kijun: kijun, Senkou: senkou,
+		tenkan: NewDonchian(tenkan), kijun: NewDonchian(kijun), senkou: NewDonchian(senkou),
+		spansA: make([]float64, kijun+1), spansB: make([]float64, kijun+1),
+	}
+}
+
+func (ic *Ichimoku) Add(o ts.OHLCV) {
+	ic.tenkan.Add(o)
+	ic.kijun.Add(o)
+	ic.senkou.Add(o)
+	var a float64
+	if ic.tenkan.Ready() && ic.kijun.Ready() {
+		a = (ic.tenkan.Value() + ic.kijun.Value()) / 2
+	}
+	i := ic.n % len(ic.spansA)
+	ic.n++
+	ic.spansA[i], ic.spansB[i] = a, ic.senkou.Value()
+}
+
+// TenkanSen is the conversion line.
+func (ic *Ichimoku) TenkanSen() float64 {
+	return ic.tenkan.Value()
+}
+
+// KijunSen is the base line.
+func (ic *Ichimoku) KijunSen() float64 {
+	return ic.kijun.Value()
+}
+
+// SpanA is the leading span A at the last bar, 0 until it is defined.
+func (ic *Ichimoku) SpanA() float64 {
+	return ic.span(ic.spansA)
+}
+
+// SpanB is the leading span B at the last bar, 0 until Ready.
+func (ic *Ichimoku) SpanB() float64 {
+	return ic.span(ic.spansB)
+}
+
+// span returns the span of spans computed Kijun bars before the last one.
+func (ic *Ichimoku) span(spans []float64) float64 {
+	if ic.n <= ic.Kijun {
+		return 0
+	}
+	return spans[ic.n%len(spans)]
+}
+
+func (ic *Ichimoku) Value() float64 {
+	if !ic.Ready() {
+		return 0
+	}
+	return ic.kijun.Value()
+}
+
+func (ic *Ichimoku) Ready() bool {
+	return ic.n >= ic.WarmUp()
+}
+
+// WarmUp is the number of bars needed for a first cloud.
+func (ic *Ichimoku) WarmUp() int {
+	first := ic.Senkou
+	if ic.Tenkan > first {
+		first = ic.Tenkan
+	}
+	if ic.Kijun > first {
+		first = ic.Kijun
+	}
+	return first + ic.Kijun
+}
@@ -0,0 +1,127 @@
+// Package indicator implements technical indicators updated bar by bar in
+// constant time. Values are those talib computes over the whole history of
+// bars added.
+package indicator
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+)
+
+// Indicator is updated with bars in time order. Constructors panic on
+// periods that are not positive.
+type Indicator interface {
+	Add(ts.OHLCV)
+	// Value is the last value, 0 until Ready.
+	Value() float64
+	// Ready is true once WarmUp bars were added.
+	Ready() bool
+	// WarmUp is the number of bars needed for a first value.
+	WarmUp() int
+}
+
+// Feed adds bars to indicators, e.g. history to warm them up.
+func Feed(bars ts.OHLCVs, indicators ...Indicator) {
+	for _, o := range bars {
+		for _, ind := range indicators {
+			ind.Add(o)
+		}
+	}
+}
+
+// checkPeriod panics if period of indicator name is not positive.
+func checkPeriod(name string, period int) {
+	if period <= 0 {
+		panic(fmt.Sprintf("indicator %s: invalid period %d", name, period))
+	}
+}
+
+// SMA is the simple moving average of closes over Period.
+type SMA struct {
+	Period int
+
+	n      int
+	total  float64
+	window []float64
+	value  float64
+}
+
+func NewSMA(period int) *SMA {
+	checkPeriod("sma", period)
+	return &SMA{Period: period, window: make([]float64, period)}
+}
+
+func (s *SMA) Add(o ts.OHLCV) {
+	s.Update(o.Close)
+}
+
+// Update adds value x, as talib.Sma does.
+func (s *SMA) Update(x float64) {
+	i := s.n
+	s.n++
+	s.window[i%s.Period] = x
+	s.total += x
+	if s.n >= s.Period {
+		s.value = s.total / float64(s.Period)
+		// the oldest value of the window leaves the total
+		s.total -= s.window[(i+1)%s.Period]
+	}
+}
+
+func (s *SMA) Value() float64 {
+	return s.value
+}
+
+func (s *SMA) Ready() bool {
+	return s.n >= s.Period
+}
+
+func (s *SMA) WarmUp() int {
+	return s.Period
+}
+
+// EMA is the exponential moving average of closes over Period, seeded with
+// the SMA of its first Period values.
+type EMA struct {
+	Period int
+
+	n     int
+	k     float64
+	sum   float64
+	value float64
+}
+
+func NewEMA(period int) *EMA {
+	checkPeriod("ema", period)
+	return &EMA{Period: period, k: 2.0 / float64(period+1)}
+}
+
+func (e *EMA) Add(o ts.OHLCV) {
+	e.Update(o.Close)
+}
+
+// Update adds value x, as talib.Ema does.
+func (e *EMA) Update(x float64) {
+	e.n++
+	switch {
+	case e.n < e.Period:
+		e.sum += x
+	case e.n == e.Period:
+		e.sum += x
+		e.value = e.sum / float64(e.Period)
+	default:
+		e.value = ((x - e.value) * e.k) + e.value
+	}
+}
+
+func (e *EMA) Value() float64 {
+	return e.value
+}
+
+func (e *EMA) Ready() bool {
+	return e.n >= e.Period
+}
+
+func (e *EMA) WarmUp() int {
+	return e.Period
+}
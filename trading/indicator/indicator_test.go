@@ -0,0 +1,246 @@
+package indicator
+
+import (
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/markcheno/go-talib"
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// bars returns n hourly bars of a noisy sine wave, with some flat closes.
+func bars(n int) ts.OHLCVs {
+	r := rand.New(rand.NewSource(1))
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := make(ts.OHLCVs, n)
+	price := 100.0
+	for i := range data {
+		open := price
+		if i%17 != 0 {
+			price = 100 + 10*math.Sin(float64(i)/10) + r.NormFloat64()*2
+		}
+		data[i] = ts.OHLCV{
+			Timestamp: util.JSONTime(t0.Add(time.Hour * time.Duration(i))),
+			Open:      open, Close: price,
+			High: math.Max(open, price) + r.Float64(), Low: math.Min(open, price) - r.Float64(),
+		}
+	}
+	return data
+}
+
+// check adds data to ind and compares its values to those of want, computed
+// over all data, once ind is ready. Value must be 0 until then.
+func check(t *testing.T, name string, ind Indicator, data ts.OHLCVs, value func() float64, want []float64) {
+	t.Helper()
+	for i, o := range data {
+		ind.Add(o)
+		if ready := i+1 >= ind.WarmUp(); ready != ind.Ready() {
+			t.Fatalf("%s: expected ready %v after %d bars", name, ready, i+1)
+		}
+		if !ind.Ready() {
+			if v := ind.Value(); v != 0 {
+				t.Fatalf("%s: expected 0 before warm up, got %f at %d", name, v, i)
+			}
+			continue
+		}
+		if v := value(); v != want[i] {
+			t.Fatalf("%s: expected %v at %d, got %v", name, want[i], i, v)
+		}
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	data := bars(500)
+	closes := data.Close()
+	for _, period := range []int{2, 9, 14, 26} {
+		sma := NewSMA(period)
+		check(t, "sma", sma, data, sma.Value, talib.Sma(closes, period))
+		ema := NewEMA(period)
+		check(t, "ema", ema, data, ema.Value, talib.Ema(closes, period))
+		rsi := NewRSI(period)
+		check(t, "rsi", rsi, data, rsi.Value, talib.Rsi(closes, period))
+		atr := NewATR(period)
+		check(t, "atr", atr, data, atr.Value, talib.Atr(data.High(), data.Low(), closes, period))
+		stddev := NewStdDev(period, 2)
+		check(t, "stddev", stddev, data, stddev.Value, talib.StdDev(closes, period, 2))
+
+		up, mid, down := talib.BBands(closes, period, 2, 1.5, talib.SMA)
+		for _, c := range []struct {
+			name  string
+			value func(*Bollinger) func() float64
+			want  []float64
+		}{
+			{"bollinger upper", func(b *Bollinger) func() float64 { return b.Upper }, up},
+			{"bollinger middle", func(b *Bollinger) func() float64 { return b.Value }, mid},
+			{"bollinger lower", func(b *Bollinger) func() float64 { return b.Lower }, down},
+		} {
+			b := NewBollinger(period, 2, 1.5)
+			check(t, c.name, b, data, c.value(b), c.want)
+		}
+	}
+}
+
+func TestInvalidPeriod(t *testing.T) {
+	for name, f := range map[string]func(){
+		"sma":        func() { NewSMA(0) },
+		"ema":        func() { NewEMA(0) },
+		"macd":       func() { NewMACD(12, 26, 0) },
+		"rsi":        func() { NewRSI(-1) },
+		"rsi of 1":   func() { NewRSI(1) },
+		"atr":        func() { NewATR(0) },
+		"bollinger":  func() { NewBollinger(0, 2, 2) },
+		"donchian":   func() { NewDonchian(0) },
+		"ichimoku":   func() { NewIchimoku(9, 0, 52) },
+		"supertrend": func() { NewSupertrend(0, 3) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestMACD(t *testing.T) {
+	data := bars(500)
+	for _, periods := range [][3]int{{12, 26, 9}, {5, 35, 5}, {26, 12, 9}, {3, 10, 2}} {
+		macd, signal, hist := talib.Macd(data.Close(), periods[0], periods[1], periods[2])
+		for _, c := range []struct {
+			name  string
+			value func(*MACD) func() float64
+			want  []float64
+		}{
+			{"macd", func(m *MACD) func() float64 { return m.Value }, macd},
+			{"macd signal", func(m *MACD) func() float64 { return m.Signal }, signal},
+			{"macd hist", func(m *MACD) func() float64 { return m.Hist }, hist},
+		} {
+			m := NewMACD(periods[0], periods[1], periods[2])
+			check(t, c.name, m, data, c.value(m), c.want)
+		}
+	}
+}
+
+func TestATR(t *testing.T) {
+	a := NewATR(2)
+	for i, c := range []float64{10, 11, 13, 12} {
+		a.Add(ts.OHLCV{
+			Timestamp: util.JSONTime(time.Date(2019, 1, 1+i, 0, 0, 0, 0, time.UTC)),
+			Open:      c, High: c + 1, Low: c - 1, Close: c,
+		})
+	}
+	// true ranges 2, 3, 2: seeded with (2+3)/2, then (2.5*1+2)/2
+	if a.Value() != 2.25 {
+		t.Errorf("expected atr 2.25, got %f", a.Value())
+	}
+}
+
+func TestFeed(t *testing.T) {
+	data := bars(50)
+	ema, rsi := NewEMA(10), NewRSI(14)
+	Feed(data, ema, rsi)
+	want := talib.Ema(data.Close(), 10)
+	if !ema.Ready() || !rsi.Ready() || ema.Value() != want[len(want)-1] {
+		t.Errorf("expected warm indicators, got ema %f (%v), rsi ready %v", ema.Value(), want[len(want)-1], rsi.Ready())
+	}
+}
+
+// BenchmarkMACD_Talib recomputes MACD over the whole history on every bar.
+func BenchmarkMACD_Talib(b *testing.B) {
+	data := bars(1000).Close()
+	for i := 0; i < b.N; i++ {
+		for j := 26; j <= len(data); j++ {
+			talib.Macd(data[:j], 12, 26, 9)
+		}
+	}
+}
+
+func BenchmarkMACD(b *testing.B) {
+	data := bars(1000).Close()
+	for i := 0; i < b.N; i++ {
+		m := NewMACD(12, 26, 9)
+		for _, x := range data {
+			m.Update(x)
+		}
+	}
+}
+
+func TestDonchian(t *testing.T) {
+	data := bars(300)
+	for _, period := range []int{2, 5, 20} {
+		upper, lower := talib.Max(data.High(), period), talib.Min(data.Low(), period)
+		middle := make([]float64, len(data))
+		for i := range middle {
+			middle[i] = (upper[i] + lower[i]) / 2
+		}
+		d := NewDonchian(period)
+		check(t, "donchian upper", d, data, d.Upper, upper)
+		d = NewDonchian(period)
+		check(t, "donchian lower", d, data, d.Lower, lower)
+		d = NewDonchian(period)
+		check(t, "donchian", d, data, d.Value, middle)
+	}
+}
+
+func TestIchimoku(t *testing.T) {
+	data := bars(300)
+	mid := func(period int) []float64 {
+		upper, lower := talib.Max(data.High(), period), talib.Min(data.Low(), period)
+		v := make([]float64, len(data))
+		for i := range v {
+			v[i] = (upper[i] + lower[i]) / 2
+		}
+		return v
+	}
+	tenkan, kijun, senkou := mid(9), mid(26), mid(52)
+	spanA, spanB := make([]float64, len(data)), make([]float64, len(data))
+	for i := 26; i < len(data); i++ {
+		spanA[i] = (tenkan[i-26] + kijun[i-26]) / 2
+		spanB[i] = senkou[i-26]
+	}
+	for _, c := range []struct {
+		name  string
+		value func(*Ichimoku) func() float64
+		want  []float64
+	}{
+		{"tenkan", func(ic *Ichimoku) func() float64 { return ic.TenkanSen }, tenkan},
+		{"kijun", func(ic *Ichimoku) func() float64 { return ic.Value }, kijun},
+		{"span a", func(ic *Ichimoku) func() float64 { return ic.SpanA }, spanA},
+		{"span b", func(ic *Ichimoku) func() float64 { return ic.SpanB }, spanB},
+	} {
+		ic := NewIchimoku(9, 26, 52)
+		check(t, c.name, ic, data, c.value(ic), c.want)
+	}
+}
+
+func TestSupertrend(t *testing.T) {
+	s := NewSupertrend(2, 1)
+	for i, c := range []struct {
+		close float64
+		up    bool
+		value float64
+	}{
+		{10, false, 0},
+		{11, false, 0},
+		// atr (2+2)/2, median 11: up, lower band 9
+		{11, true, 9},
+		// atr 2, lower band only tightens
+		{10, true, 9},
+		// atr 2.5
+		{12, true, 9.5},
+		// close below the lower band, the upper band kept at 10 + 2
+		{9, false, 12},
+	} {
+		s.Add(ts.OHLCV{
+			Timestamp: util.JSONTime(time.Date(2019, 1, 1+i, 0, 0, 0, 0, time.UTC)),
+			Open:      c.close, High: c.close + 1, Low: c.close - 1, Close: c.close,
+		})
+		if s.Up() != c.up || s.Value() != c.value {
+			t.Errorf("bar %d: expected up %v at %f, got %v at %f", i, c.up, c.value, s.Up(), s.Value())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+)
+
+// MACD is the moving average convergence divergence of closes. Value is the
+// MACD line, Signal its EMA over SignalPeriod and Hist their difference.
+type MACD struct {
+	Fast, Slow, SignalPeriod int
+
+	n                  int
+	fast, slow, signal *EMA
+	macd, hist         float64
+}
+
+// NewMACD returns a MACD of fast & slow EMAs, swapped if fast is the slowest.
+func NewMACD(fast, slow, signal int) *MACD {
+	if slow < fast {
+		fast, slow = slow, fast
+	}
+	return &MACD{
+		Fast: fast, Slow: slow, SignalPeriod: signal,
+		fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal),
+	}
+}
+
+func (m *MACD) Add(o ts.OHLCV) {
+	m.Update(o.Close)
+}
+
+// Update adds value x. Like talib.Macd, the signal EMA is seeded with zeros
+// in place of the MACD values preceding the first one.
+func (m *MACD) Update(x float64) {
+	m.n++
+	m.fast.Update(x)
+	m.slow.Update(x)
+	var macd float64
+	if m.n >= m.WarmUp()-1 {
+		macd = m.fast.Value() - m.slow.Value()
+	}
+	m.signal.Update(macd)
+	if m.Ready() {
+		m.macd, m.hist = macd, macd-m.signal.Value()
+	}
+}
+
+func (m *MACD) Value() float64 {
+	return m.macd
+}
+
+// Signal is the last signal line value, 0 until Ready.
+func (m *MACD) Signal() float64 {
+	if !m.Ready() {
+		return 0
+	}
+	return m.signal.Value()
+}
+
+// Hist is the last histogram value, 0 until Ready.
+func (m *MACD) Hist() float64 {
+	return m.hist
+}
+
+func (m *MACD) Ready() bool {
+	return m.n >= m.WarmUp()
+}
+
+func (m *MACD) WarmUp() int {
+	return m.Slow + m.SignalPeriod - 1
+}
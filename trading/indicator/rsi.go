@@ -0,0 +1,71 @@
+package indicator
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+	"math"
+)
+
+// RSI is the relative strength index of closes over Period, at least 2.
+type RSI struct {
+	Period int
+
+	n          int
+	prev       float64
+	gain, loss float64
+	value      float64
+}
+
+func NewRSI(period int) *RSI {
+	if period < 2 {
+		panic(fmt.Sprintf("indicator rsi: invalid period %d", period))
+	}
+	return &RSI{Period: period}
+}
+
+func (r *RSI) Add(o ts.OHLCV) {
+	r.Update(o.Close)
+}
+
+// Update adds value x, as talib.Rsi does.
+func (r *RSI) Update(x float64) {
+	r.n++
+	if r.n == 1 {
+		r.prev = x
+		return
+	}
+	diff := x - r.prev
+	r.prev = x
+	period := float64(r.Period)
+	if r.n > r.Period+1 {
+		r.loss *= period - 1
+		r.gain *= period - 1
+	}
+	if diff < 0 {
+		r.loss -= diff
+	} else {
+		r.gain += diff
+	}
+	if r.n < r.Period+1 {
+		return
+	}
+	r.loss /= period
+	r.gain /= period
+	if total := r.gain + r.loss; math.Abs(total) >= 1e-14 {
+		r.value = 100 * (r.gain / total)
+	} else {
+		r.value = 0
+	}
+}
+
+func (r *RSI) Value() float64 {
+	return r.value
+}
+
+func (r *RSI) Ready() bool {
+	return r.n > r.Period
+}
+
+func (r *RSI) WarmUp() int {
+	return r.Period + 1
+}
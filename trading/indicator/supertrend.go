@@ -0,0 +1,74 @@
+package indicator
+
+import (
+	"github.com/rkjdid/gocx/ts"
+)
+
+// Supertrend trails bars at Multiplier ATR(Period) from their median price.
+// Value is the lower band in an uptrend, the upper band in a downtrend; the
+// trend reverses when a close crosses it.
+type Supertrend struct {
+	Period     int
+	Multiplier float64
+
+	atr          *ATR
+	upper, lower float64
+	up           bool
+	prevClose    float64
+}
+
+func NewSupertrend(period int, multiplier float64) *Supertrend {
+	return &Supertrend{Period: period, Multiplier: multiplier, atr: NewATR(period)}
+}
+
+func (s *Supertrend) Add(o ts.OHLCV) {
+	first := !s.atr.Ready()
+	s.atr.Add(o)
+	prevClose := s.prevClose
+	s.prevClose = o.Close
+	if !s.atr.Ready() {
+		return
+	}
+	median := (o.High + o.Low) / 2
+	upper := median + s.Multiplier*s.atr.Value()
+	lower := median - s.Multiplier*s.atr.Value()
+	if first {
+		s.upper, s.lower, s.up = upper, lower, o.Close >= median
+		return
+	}
+	// bands only tighten, unless the previous close crossed them
+	if upper < s.upper || prevClose > s.upper {
+		s.upper = upper
+	}
+	if lower > s.lower || prevClose < s.lower {
+		s.lower = lower
+	}
+	if s.up && o.Close < s.lower {
+		s.up = false
+	} else if !s.up && o.Close > s.upper {
+		s.up = true
+	}
+}
+
+func (s *Supertrend) Value() float64 {
+	if !s.Ready() {
+		return 0
+	}
+	if s.up {
+		return s.lower
+	}
+	return s.upper
+}
+
+// Up is true in an uptrend.
+func (s *Supertrend) Up() bool {
+	return s.up
+}
+
+func (s *Supertrend) Ready() bool {
+	return s.atr.Ready()
+}
+
+func (s *Supertrend) WarmUp() int {
+	return s.atr.WarmUp()
+}
@@ -0,0 +1,134 @@
+package trading
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"log"
+	"time"
+)
+
+// DefaultMargin is used by backtests opening shorts on a broker without margin.
+var DefaultMargin = Margin{InterestRate: 0.0002, MaintenanceRate: 0.1}
+
+// Margin enables borrowing on PaperTrading: sells exceeding free funds borrow the
+// missing base, and buys repay it.
+type Margin struct {
+	// InterestRate is the daily interest on borrowed value, paid in quote.
+	InterestRate float64
+	// MaintenanceRate is the ratio of equity to borrowed value under which
+	// loans are liquidated, 0 disables liquidation.
+	MaintenanceRate float64
+}
+
+// loan of base, borrowed to sell for quote.
+type loan struct {
+	Base, Quote string
+	// Interest paid since the loan was taken.
+	Interest float64
+	// Liquidations are orders buying back the loan.
+	Liquidations []*Order
+
+	since time.Time
+}
+
+// borrow borrows base missing to sell q of o.
+func (p *PaperTrading) borrow(o *Order, q float64) error {
+	base, quote, err := p.assets(o)
+	if err != nil {
+		return err
+	}
+	missing := q - p.Account.Free(base)
+	if missing <= 0 {
+		return nil
+	}
+	if p.loans == nil {
+		p.loans = make(map[string]*loan)
+	}
+	l, ok := p.loans[o.Symbol]
+	if !ok || p.Account.Debt(base) == 0 {
+		l = &loan{Base: base, Quote: quote, since: p.Time}
+		p.loans[o.Symbol] = l
+	}
+	p.Account.Borrow(base, missing)
+	return nil
+}
+
+// repay pays back what free funds allow of the loan of o base.
+func (p *PaperTrading) repay(o *Order) {
+	base, _, err := p.assets(o)
+	if err != nil {
+		return
+	}
+	_ = p.Account.Repay(base, p.Account.Free(base))
+}
+
+// Interest returns interest paid on the current, or last, loan of sym.
+func (p PaperTrading) Interest(sym string) float64 {
+	if l, ok := p.loans[sym]; ok {
+		return l.Interest
+	}
+	return 0
+}
+
+// Liquidations returns orders of sym liquidated by p, of its current
+// or last loan or contract.
+func (p PaperTrading) Liquidations(sym string) []*Order {
+	if c, ok := p.contracts[sym]; ok && p.Perpetuals != nil {
+		return c.Liquidations
+	}
+	if l, ok := p.loans[sym]; ok {
+		return l.Liquidations
+	}
+	return nil
+}
+
+// marginCall charges interest on loans of sym, all loans if sym is empty, up to bar o,
+// and liquidates those whose equity falls below maintenance.
+func (p *PaperTrading) marginCall(sym string, o ts.OHLCV) {
+	if p.Margin == nil || p.Account == nil {
+		return
+	}
+	for s, l := range p.loans {
+		debt := p.Account.Debt(l.Base)
+		if sym != "" && s != sym || debt == 0 {
+			continue
+		}
+		t := o.Timestamp.T()
+		if t.After(l.since) {
+			interest := debt * o.Close * p.Margin.InterestRate * t.Sub(l.since).Hours() / 24
+			p.Account.Deposit(l.Quote, -interest)
+			l.Interest += interest
+			l.since = t
+		}
+		equity := p.Account.Total(l.Quote) + (p.Account.Total(l.Base)-debt)*o.Close
+		if equity < p.Margin.MaintenanceRate*debt*o.Close {
+			if err := p.liquidate(s, l); err != nil {
+				log.Printf("paper: liquidating %s: %s", s, err)
+			}
+		}
+	}
+}
+
+// liquidate cancels open orders of sym and buys back its loan at market.
+func (p *PaperTrading) liquidate(sym string, l *loan) error {
+	for _, o := range p.resting {
+		if o.Symbol == sym && o.Open() {
+			_, _ = p.CancelOrder(sym, o.Id)
+		}
+	}
+	q := p.Account.Debt(l.Base) - p.Account.Free(l.Base)
+	if q <= 0 {
+		p.repay(&Order{Symbol: sym, Base: l.Base, Quote: l.Quote})
+		return nil
+	}
+	o := p.add(Order{
+		Symbol: sym, Base: l.Base, Quote: l.Quote, Direction: Buy, Type: MarketOrder, Quantity: q,
+	})
+	o.ClientId = "liquidation-" + o.Id
+	err := p.fillOrder(o, p.slip(Buy, q, p.Price))
+	if err != nil {
+		_ = o.SetStatus(OrderRejected)
+		return err
+	}
+	l.Liquidations = append(l.Liquidations, o)
+	return nil
+}
@@ -0,0 +1,72 @@
+package trading
+
+import (
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/ts"
+	"testing"
+	"time"
+)
+
+func dayBar(day int, price float64) ts.OHLCV {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	return ts.OHLCV{
+		Timestamp: util.JSONTime(t0.Add(time.Hour * 24 * time.Duration(day))),
+		Open:      price, High: price, Low: price, Close: price,
+	}
+}
+
+func TestPaperTrading_Margin(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a, Margin: &Margin{InterestRate: 0.01}}
+	pt.Update(dayBar(0, 10))
+	p := NewPosition(pt, "ABC", "BTC", Short)
+	if err := p.MarketSell(0.1); err != nil {
+		t.Fatal(err)
+	}
+	if !almostEq(a.Debt("ABC"), 0.1) || !almostEq(a.Free("BTC"), 2) {
+		t.Errorf("expected 0.1 ABC borrowed & sold for 1 BTC, got %s", a)
+	}
+
+	// 1% a day on borrowed value
+	p.SetTick(dayBar(1, 10))
+	p.SetTick(dayBar(2, 8))
+	if !almostEq(p.Interest, 0.018) || !almostEq(a.Free("BTC"), 1.982) {
+		t.Errorf("expected 0.018 interest paid, got %f: %s", p.Interest, a)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Debt("ABC") != 0 || !almostEq(a.Total("ABC"), 0) {
+		t.Errorf("expected loan repaid on close, got %s", a)
+	}
+	if !almostEq(p.Net(), 0.1*2-0.018) || !almostEq(a.Total("BTC"), 1+p.Net()) {
+		t.Errorf("unexpected short net %f: %s", p.Net(), a)
+	}
+}
+
+func TestPaperTrading_Liquidation(t *testing.T) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a, Margin: &Margin{MaintenanceRate: 0.1}}
+	pt.Update(dayBar(0, 10))
+	p := NewPosition(pt, "ABC", "BTC", Short)
+	_ = p.MarketSell(0.1)
+
+	// equity 2 - 0.1*18 is above 10% of 1.8
+	p.SetTick(dayBar(1, 18))
+	if !p.Active() {
+		t.Fatalf("expected position to stay open at 18")
+	}
+	// equity 2 - 0.1*19 is below 10% of 1.9
+	p.SetTick(dayBar(2, 19))
+	if p.State != Closed || p.AvgExit != 19 || a.Debt("ABC") != 0 {
+		t.Fatalf("expected position liquidated at 19, got %s: %s", p, a)
+	}
+	if o := p.Orders[len(p.Orders)-1]; o.ClientId != "liquidation-"+o.Id {
+		t.Errorf("expected liquidation order, got %s", o)
+	}
+	if !almostEq(a.Total("BTC"), 0.1) {
+		t.Errorf("expected 0.1 BTC left, got %s", a)
+	}
+}
@@ -0,0 +1,239 @@
+package trading
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOrderNotFound is returned by Broker.FindOrder for unknown client ids.
+var ErrOrderNotFound = errors.New("order not found")
+
+// TransientError wraps broker errors after which a request can be retried,
+// e.g. timeouts or rate limits.
+type TransientError struct {
+	Err error
+}
+
+func (e TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e TransientError) Temporary() bool {
+	return true
+}
+
+// IsTransient returns true if err is temporary, such as TransientError or
+// some net errors.
+func IsTransient(err error) bool {
+	t, ok := err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+type OrderType string
+
+const (
+	MarketOrder = OrderType("market")
+	// LimitOrder fills at Price or better.
+	LimitOrder = OrderType("limit")
+	// StopOrder is a market order sent once price reaches StopPrice.
+	StopOrder = OrderType("stop")
+	// StopLimitOrder is a limit order at Price sent once price reaches StopPrice.
+	StopLimitOrder = OrderType("stop-limit")
+)
+
+type TimeInForce string
+
+const (
+	// GTC orders rest until filled or cancelled.
+	GTC = TimeInForce("GTC")
+	// IOC orders fill what they can immediately, the rest expires.
+	IOC = TimeInForce("IOC")
+	// FOK orders fill entirely and immediately, or expire.
+	FOK = TimeInForce("FOK")
+)
+
+type OrderStatus string
+
+const (
+	OrderNew             = OrderStatus("new")
+	OrderPartiallyFilled = OrderStatus("partially-filled")
+	OrderFilled          = OrderStatus("filled")
+	OrderCancelled       = OrderStatus("cancelled")
+	OrderRejected        = OrderStatus("rejected")
+	OrderExpired         = OrderStatus("expired")
+)
+
+// transitions lists statuses reachable from each working status,
+// other statuses are final.
+var transitions = map[OrderStatus][]OrderStatus{
+	OrderNew:             {OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderRejected, OrderExpired},
+	OrderPartiallyFilled: {OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderExpired},
+}
+
+// lastClientId makes client ids generated during the same nanosecond unique.
+var lastClientId uint32
+
+// NewClientId returns a unique client order id, valid for binance.
+func NewClientId() string {
+	n := atomic.AddUint32(&lastClientId, 1)
+	return "gocx-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(uint64(n), 36)
+}
+
+type Order struct {
+	// Id is set by the broker, ClientId by the caller, or the broker if empty.
+	Id       string
+	ClientId string
+	Symbol   string
+	// Base & Quote are assets of Symbol, used by PaperTrading.Account.
+	Base, Quote string
+	Direction   Direction
+	Type        OrderType
+	TimeInForce TimeInForce
+	Quantity    float64
+	// Price is the limit price of limit & stop-limit orders.
+	Price float64
+	// StopPrice is the trigger price of stop & stop-limit orders.
+	StopPrice float64
+	Status    OrderStatus
+	Time      time.Time
+	// OCO is the Id of the other order of a one-cancels-the-other pair.
+	OCO string
+
+	// Transactions are fills of the order, see Fill.
+	Transactions []*Transaction
+
+	// triggered is set once a PaperTrading stop-limit order becomes a limit order.
+	triggered bool
+}
+
+func (o Order) String() string {
+	side := "sell"
+	if o.Direction == Buy {
+		side = "buy"
+	}
+	s := fmt.Sprintf("%s %s %s %s %f", o.Id, o.Symbol, o.Type, side, o.Quantity)
+	if o.Price != 0 {
+		s += fmt.Sprintf(" @ %f", o.Price)
+	}
+	if o.StopPrice != 0 {
+		s += fmt.Sprintf(" stop %f", o.StopPrice)
+	}
+	return fmt.Sprintf("%s (%s)", s, o.Status)
+}
+
+// Open returns true if o is still working.
+func (o Order) Open() bool {
+	return o.Status == OrderNew || o.Status == OrderPartiallyFilled
+}
+
+// Executed returns filled quantity of o.
+func (o Order) Executed() float64 {
+	var q float64
+	for _, t := range o.Transactions {
+		q += t.Quantity
+	}
+	return q
+}
+
+// Remaining returns quantity of o left to fill.
+func (o Order) Remaining() float64 {
+	return o.Quantity - o.Executed()
+}
+
+// SetStatus moves o to status s, if allowed from current status.
+func (o *Order) SetStatus(s OrderStatus) error {
+	if o.Status == "" {
+		o.Status = OrderNew
+	}
+	if s == o.Status && s != OrderPartiallyFilled {
+		return nil
+	}
+	for _, next := range transitions[o.Status] {
+		if next == s {
+			o.Status = s
+			return nil
+		}
+	}
+	return fmt.Errorf("order %s: invalid transition %s -> %s", o.Id, o.Status, s)
+}
+
+// Fill adds fill t to o, which becomes partially filled or filled.
+func (o *Order) Fill(t *Transaction) error {
+	if t.Direction != o.Direction {
+		return fmt.Errorf("order %s: fill direction mismatch", o.Id)
+	}
+	// tolerate float rounding of fill quantities
+	if t.Quantity > o.Remaining()*(1+1e-9) {
+		return fmt.Errorf("order %s: fill of %f exceeds remaining %f", o.Id, t.Quantity, o.Remaining())
+	}
+	status := OrderPartiallyFilled
+	if t.Quantity >= o.Remaining()*(1-1e-9) {
+		status = OrderFilled
+	}
+	if err := o.SetStatus(status); err != nil {
+		return err
+	}
+	t.OrderId = o.Id
+	o.Transactions = append(o.Transactions, t)
+	return nil
+}
+
+// Validate checks o has the prices its type requires.
+func (o Order) Validate() error {
+	if o.Quantity <= 0 {
+		return fmt.Errorf("invalid order quantity %f", o.Quantity)
+	}
+	switch o.Type {
+	case MarketOrder:
+	case LimitOrder:
+		if o.Price <= 0 {
+			return errors.New("limit order without price")
+		}
+	case StopOrder:
+		if o.StopPrice <= 0 {
+			return errors.New("stop order without stop price")
+		}
+	case StopLimitOrder:
+		if o.Price <= 0 || o.StopPrice <= 0 {
+			return errors.New("stop-limit order without price or stop price")
+		}
+	default:
+		return fmt.Errorf("unknown order type \"%s\"", o.Type)
+	}
+	switch o.TimeInForce {
+	case "", GTC, IOC, FOK:
+	default:
+		return fmt.Errorf("unknown time in force \"%s\"", o.TimeInForce)
+	}
+	return nil
+}
+
+// ValidateOCO checks limit & stop can be paired as a one-cancels-the-other order:
+// a limit order and a stop or stop-limit order, on the same side & quantity.
+func ValidateOCO(limit, stop Order) error {
+	if limit.Type != LimitOrder {
+		return fmt.Errorf("oco: expected limit order, got %s", limit.Type)
+	}
+	if stop.Type != StopOrder && stop.Type != StopLimitOrder {
+		return fmt.Errorf("oco: expected stop order, got %s", stop.Type)
+	}
+	if limit.Symbol != stop.Symbol || limit.Direction != stop.Direction || limit.Quantity != stop.Quantity {
+		return errors.New("oco: orders symbol, direction & quantity must match")
+	}
+	if err := limit.Validate(); err != nil {
+		return fmt.Errorf("oco: %s", err)
+	}
+	if err := stop.Validate(); err != nil {
+		return fmt.Errorf("oco: %s", err)
+	}
+	return nil
+}
+
+func (o *Order) copy() *Order {
+	c := *o
+	c.Transactions = append([]*Transaction(nil), o.Transactions...)
+	return &c
+}
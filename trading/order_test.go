@@ -0,0 +1,51 @@
+package trading
+
+import "testing"
+
+func TestOrder_Fill(t *testing.T) {
+	o := &Order{Id: "1", Direction: Buy, Type: LimitOrder, Quantity: 3, Price: 10}
+	if err := o.Fill(&Transaction{Direction: Buy, Quantity: 1, Price: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != OrderPartiallyFilled || !o.Open() || o.Remaining() != 2 {
+		t.Errorf("expected partially filled order, got %s", o)
+	}
+	if err := o.Fill(&Transaction{Direction: Buy, Quantity: 3, Price: 10}); err == nil {
+		t.Errorf("expected overfill to fail")
+	}
+	if err := o.Fill(&Transaction{Direction: Buy, Quantity: 2, Price: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != OrderFilled || o.Open() || o.Transactions[1].OrderId != "1" {
+		t.Errorf("expected filled order linked to its fills, got %s", o)
+	}
+	if err := o.SetStatus(OrderCancelled); err == nil {
+		t.Errorf("expected filled order not to be cancelled")
+	}
+}
+
+func TestOrder_SetStatus(t *testing.T) {
+	for _, tc := range []struct {
+		from, to OrderStatus
+		ok       bool
+	}{
+		{"", OrderRejected, true},
+		{OrderNew, OrderExpired, true},
+		{OrderPartiallyFilled, OrderCancelled, true},
+		{OrderPartiallyFilled, OrderRejected, false},
+		{OrderCancelled, OrderFilled, false},
+		{OrderExpired, OrderNew, false},
+	} {
+		o := &Order{Status: tc.from}
+		if err := o.SetStatus(tc.to); (err == nil) != tc.ok {
+			t.Errorf("%s -> %s: expected ok %v, got %v", tc.from, tc.to, tc.ok, err)
+		}
+	}
+}
+
+func TestNewClientId(t *testing.T) {
+	a, b := NewClientId(), NewClientId()
+	if a == b || len(a) > 36 {
+		t.Errorf("expected unique client ids of at most 36 chars, got %s & %s", a, b)
+	}
+}
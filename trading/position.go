@@ -5,6 +5,7 @@ import (
 	"github.com/rkjdid/gocx/ts"
 	"github.com/rkjdid/gocx/util"
 	"log"
+	"math"
 	"time"
 )
 
@@ -17,8 +18,14 @@ const (
 	Buy   = Long
 
 	DefaultFees = 0.0015
+
+	// orderAttempts is the number of times marketOrder tries transient errors.
+	orderAttempts = 3
 )
 
+// retryDelay is the pause between two marketOrder attempts.
+var retryDelay = time.Second * 2
+
 type State string
 
 const (
@@ -29,11 +36,14 @@ const (
 
 type Transaction struct {
 	Id         int
+	OrderId    string
 	Time       time.Time
 	Direction  Direction
 	Quantity   float64
 	Price      float64
 	Commission float64
+	// CommissionAsset is the asset commission is paid in, quote if empty.
+	CommissionAsset string
 }
 
 func (t Transaction) Cost() float64 {
@@ -44,9 +54,15 @@ type Position struct {
 	State       State
 	Base, Quote string
 	Direction   Direction
+	// Instrument is Spot if empty.
+	Instrument Instrument
 
 	FeesRate  float64
 	TotalFees float64
+	// Interest paid in quote on base borrowed by short positions.
+	Interest float64
+	// Funding paid in quote by perpetual positions, negative if received.
+	Funding   float64
 	Total     float64
 	Traded    float64
 	AvgEntry  float64
@@ -54,8 +70,12 @@ type Position struct {
 	OpenTime  time.Time
 	CloseTime time.Time
 
-	Broker       Broker
+	Broker Broker `json:"-"`
+	// Orders placed for p, Transactions are their fills.
+	Orders       []*Order
 	Transactions []*Transaction
+	// ExitIds are ids of take profit & stop loss orders resting on Broker, see PlaceExits.
+	ExitIds []string
 
 	tick ts.OHLCV
 }
@@ -91,12 +111,10 @@ func (p Position) Net() float64 {
 		return 0
 	}
 	net := p.Traded*p.AvgExit - p.Traded*p.AvgEntry
-	net -= p.TotalFees
-	if p.Direction == Long {
-		return net
-	} else {
-		return -net
+	if p.Direction == Short {
+		net = -net
 	}
+	return net - p.TotalFees - p.Interest - p.Funding
 }
 
 func (p Position) NetRatio() float64 {
@@ -119,7 +137,12 @@ func (p *Position) AddTransactions(ts ...*Transaction) {
 		p.OpenTime = ts[0].Time
 	}
 	for _, t := range ts {
-		p.TotalFees += t.Commission
+		if t.CommissionAsset == p.Base && p.Base != "" {
+			p.TotalFees += t.Commission * t.Price
+		} else {
+			// fees paid in a third asset, e.g. BNB, are counted as quote
+			p.TotalFees += t.Commission
+		}
 		p.Transactions = append(p.Transactions, t)
 		if p.Direction == t.Direction {
 			p.AvgEntry = ((p.AvgEntry * p.Total) + (t.Price * t.Quantity)) /
@@ -141,37 +164,187 @@ func (p *Position) AddTransactions(ts ...*Transaction) {
 func (p *Position) SetTick(o ts.OHLCV) {
 	p.tick = o
 	if pb, ok := p.Broker.(*PaperTrading); ok {
-		pb.Update(o)
+		sym := p.Broker.Symbol(p.Base, p.Quote)
+		pb.UpdateSymbol(sym, o)
+		if p.Active() && (p.Direction == Short || p.Instrument == Perpetual) {
+			p.syncMargin(pb, sym)
+		}
+		if len(p.ExitIds) > 0 {
+			if err := p.SyncExits(); err != nil {
+				log.Println(err)
+			}
+		}
 	}
 }
 
-func (p *Position) marketOrder(fn func(string, float64) ([]*Transaction, error), q float64, direction string) error {
-	var ts []*Transaction
-	var err error
-	for i := 0; i < 3; i++ {
-		ts, err = fn(p.Broker.Symbol(p.Base, p.Quote), q)
-		if err != nil {
-			log.Printf("marketOrder.%s: %s", direction, err)
-			time.Sleep(time.Second * 2)
-			continue
+// syncMargin updates interest or funding paid by p, and adds its liquidations.
+func (p *Position) syncMargin(pb *PaperTrading, sym string) {
+	if p.Instrument == Perpetual {
+		p.Funding = pb.Funding(sym)
+	} else {
+		p.Interest = pb.Interest(sym)
+	}
+	for _, o := range pb.Liquidations(sym) {
+		if p.Order(o.Id) == nil && !o.Time.Before(p.OpenTime) {
+			log.Printf("position %s%s liquidated", p.Base, p.Quote)
+			p.AddOrder(o.copy())
 		}
 	}
-	if err != nil {
-		return err
+}
+
+// AddOrder adds o to p orders, and its fills to p transactions.
+func (p *Position) AddOrder(o *Order) {
+	p.Orders = append(p.Orders, o)
+	for _, t := range o.Transactions {
+		t.OrderId = o.Id
+	}
+	p.AddTransactions(o.Transactions...)
+}
+
+// Order returns order id of p, nil if not found.
+func (p Position) Order(id string) *Order {
+	for _, o := range p.Orders {
+		if o.Id == id {
+			return o
+		}
 	}
-	p.AddTransactions(ts...)
 	return nil
 }
 
+// updateOrder replaces p order with up to date o, adding its new fills to p.
+func (p *Position) updateOrder(o *Order) {
+	prev := p.Order(o.Id)
+	if prev == nil {
+		p.AddOrder(o)
+		return
+	}
+	if n := len(prev.Transactions); len(o.Transactions) > n {
+		for _, t := range o.Transactions[n:] {
+			t.OrderId = o.Id
+		}
+		p.AddTransactions(o.Transactions[n:]...)
+	}
+	*prev = *o
+}
+
+// Audit lists orders of p with their fills, and checks quantities of p
+// add up to them.
+func (p Position) Audit() (string, error) {
+	var s string
+	var total, traded float64
+	for _, o := range p.Orders {
+		s += fmt.Sprintf("%s, executed %f\n", o, o.Executed())
+		for _, t := range o.Transactions {
+			s += fmt.Sprintf("  %s %f @ %f, fees %f\n", t.Time.Format(util.DefaultTimeFormat),
+				t.Quantity, t.Price, t.Commission)
+			if t.Direction == p.Direction {
+				total += t.Quantity
+			} else {
+				traded += t.Quantity
+			}
+		}
+	}
+	s += fmt.Sprintf("total %f, traded %f\n", p.Total, p.Traded)
+	if math.Abs(total-p.Total) > 1e-9 || math.Abs(traded-p.Traded) > 1e-9 {
+		return s, fmt.Errorf("orders fills (%f, %f) do not add up to position (%f, %f)",
+			total, traded, p.Total, p.Traded)
+	}
+	return s, nil
+}
+
+// marketOrder sends a market order, retrying transient errors up to orderAttempts
+// times. Each attempt has its own client id, and is looked up on Broker before
+// retrying or giving up, as it may have executed despite the error.
+func (p *Position) marketOrder(direction Direction, q float64, name string) error {
+	sym := p.Broker.Symbol(p.Base, p.Quote)
+	var clientId string
+	var err error
+	for i := 0; i < orderAttempts; i++ {
+		if i > 0 {
+			time.Sleep(retryDelay)
+			o, findErr := p.Broker.FindOrder(sym, clientId)
+			switch {
+			case findErr == nil && (o.Executed() > 0 || o.Open()):
+				log.Printf("marketOrder.%s: attempt %s went through", name, clientId)
+				p.AddOrder(o)
+				return nil
+			case findErr == nil, findErr == ErrOrderNotFound:
+				// previous attempt did not execute
+			case IsTransient(findErr):
+				// unknown outcome, look it up again before placing a new order
+				err = findErr
+				log.Printf("marketOrder.%s: looking up %s: %s", name, clientId, err)
+				continue
+			default:
+				return fmt.Errorf("marketOrder.%s: looking up %s: %s", name, clientId, findErr)
+			}
+		}
+
+		clientId = NewClientId()
+		var o *Order
+		o, err = p.Broker.PlaceOrder(Order{
+			ClientId:  clientId,
+			Symbol:    sym,
+			Base:      p.Base,
+			Quote:     p.Quote,
+			Direction: direction,
+			Type:      MarketOrder,
+			Quantity:  q,
+		})
+		if err == nil {
+			p.AddOrder(o)
+			return nil
+		}
+		log.Printf("marketOrder.%s: %s", name, err)
+		if !IsTransient(err) {
+			return err
+		}
+	}
+	// the last attempt may have executed as well
+	if clientId != "" {
+		time.Sleep(retryDelay)
+		if o, findErr := p.Broker.FindOrder(sym, clientId); findErr == nil && (o.Executed() > 0 || o.Open()) {
+			log.Printf("marketOrder.%s: attempt %s went through", name, clientId)
+			p.AddOrder(o)
+			return nil
+		}
+	}
+	return err
+}
+
 func (p *Position) MarketBuy(q float64) error {
-	return p.marketOrder(p.Broker.MarketBuy, q, "Buy")
+	return p.marketOrder(Buy, q, "Buy")
 }
 
 func (p *Position) MarketSell(q float64) error {
-	return p.marketOrder(p.Broker.MarketSell, q, "Sell")
+	return p.marketOrder(Sell, q, "Sell")
 }
 
+// Close cancels resting exits of p and closes the remaining quantity at market.
 func (p *Position) Close() error {
+	if err := p.CancelExits(); err != nil {
+		return err
+	}
+	if p.State == Closed {
+		// an exit filled in the meantime
+		return nil
+	}
+	return p.closeMarket()
+}
+
+// Reduce closes q of p at market, or all of it with Close if q covers what is left.
+func (p *Position) Reduce(q float64) error {
+	if q >= (p.Total-p.Traded)*(1-1e-9) {
+		return p.Close()
+	}
+	fn := p.MarketBuy
+	if p.Direction == Long {
+		fn = p.MarketSell
+	}
+	return fn(q)
+}
+
+func (p *Position) closeMarket() error {
 	fn := p.MarketBuy
 	if p.Direction == Long {
 		fn = p.MarketSell
@@ -181,14 +354,134 @@ func (p *Position) Close() error {
 
 // NetOnClose will PaperClose and return Net on a copy of p, caller Position is unchanged.
 func (p Position) NetOnClose() float64 {
-	if _, ok := p.Broker.(*PaperTrading); !ok {
-		pt := &PaperTrading{
-			FeesRate: p.FeesRate,
+	return p.closedOnTick().Net()
+}
+
+// closedOnTick returns a copy of p closed at market on its last tick.
+func (p Position) closedOnTick() Position {
+	pt := &PaperTrading{
+		FeesRate: p.FeesRate,
+	}
+	if pb, ok := p.Broker.(*PaperTrading); ok {
+		// fresh broker, so that the closing order is not recorded on pb
+		pt = &PaperTrading{
+			FeesRate: pb.FeesRate, Time: pb.Time, Price: pb.Price, Slippage: pb.Slippage, bar: pb.bar,
 		}
+		if p.tick.Close > 0 {
+			// pb may have been updated since by another market
+			pt.Update(p.tick)
+		}
+	} else {
 		pt.Update(p.tick)
-		p.Broker = pt
 	}
-	// PaperTrading broker does not error
-	_ = p.Close()
-	return p.Net()
+	p.Broker = pt
+	p.Orders = append([]*Order(nil), p.Orders...)
+	p.Transactions = append([]*Transaction(nil), p.Transactions...)
+	// PaperTrading broker does not error, resting exits are left untouched
+	_ = p.closeMarket()
+	return p
+}
+
+// Value returns what closing the open quantity of p at market would bring in
+// quote net of fees, negative for shorts which have to buy back. Perpetuals only
+// bring back their pnl, their margin being still accounted as quote. Proceeds of
+// partial exits are already in quote, they are not counted.
+func (p Position) Value() float64 {
+	q := p.Total - p.Traded
+	if q <= 0 {
+		return 0
+	}
+	c := p.closedOnTick()
+	exit := c.Traded*c.AvgExit - p.Traded*p.AvgExit
+	fees := c.TotalFees - p.TotalFees
+	switch {
+	case p.Instrument == Perpetual && p.Direction == Short:
+		return q*p.AvgEntry - exit - fees
+	case p.Instrument == Perpetual:
+		return exit - q*p.AvgEntry - fees
+	case p.Direction == Short:
+		return -exit - fees
+	}
+	return exit - fees
+}
+
+// Exits returns take profit & stop loss orders of p, see PlaceExits.
+func (p Position) Exits() []*Order {
+	var orders []*Order
+	for _, id := range p.ExitIds {
+		if o := p.Order(id); o != nil {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// PlaceExits places a one-cancels-the-other order closing p at tp, or at sl,
+// resting on Broker. Previous exits are cancelled. Fills are added to p by SyncExits.
+func (p *Position) PlaceExits(tp, sl float64) error {
+	if err := p.CancelExits(); err != nil {
+		return err
+	}
+	if p.State != Active {
+		return fmt.Errorf("PlaceExits: position is %s", p.State)
+	}
+	limit := Order{
+		ClientId:  NewClientId(),
+		Symbol:    p.Broker.Symbol(p.Base, p.Quote),
+		Base:      p.Base,
+		Quote:     p.Quote,
+		Direction: !p.Direction,
+		Type:      LimitOrder,
+		Quantity:  p.Total - p.Traded,
+		Price:     tp,
+	}
+	stop := limit
+	stop.ClientId, stop.Type, stop.Price, stop.StopPrice = NewClientId(), StopOrder, 0, sl
+	orders, err := p.Broker.PlaceOCO(limit, stop)
+	if err != nil {
+		return fmt.Errorf("PlaceExits: %s", err)
+	}
+	p.ExitIds = nil
+	for _, o := range orders {
+		p.AddOrder(o)
+		p.ExitIds = append(p.ExitIds, o.Id)
+	}
+	return nil
+}
+
+// SyncExits queries open exits of p and adds their new fills to p.
+func (p *Position) SyncExits() error {
+	for _, o := range p.Exits() {
+		if !o.Open() {
+			continue
+		}
+		order, err := p.Broker.GetOrder(o.Symbol, o.Id)
+		if err != nil {
+			return fmt.Errorf("SyncExits: %s", err)
+		}
+		p.updateOrder(order)
+	}
+	return nil
+}
+
+// CancelExits cancels open exits of p, fills received before cancellation are added to p.
+func (p *Position) CancelExits() error {
+	if err := p.SyncExits(); err != nil {
+		return err
+	}
+	for _, o := range p.Exits() {
+		if !o.Open() {
+			continue
+		}
+		order, err := p.Broker.CancelOrder(o.Symbol, o.Id)
+		if err != nil {
+			return fmt.Errorf("CancelExits: %s", err)
+		}
+		p.updateOrder(order)
+		// cancelling one order of an oco cancels the other
+		if err := p.SyncExits(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
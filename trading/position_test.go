@@ -1,6 +1,10 @@
 package trading
 
-import "testing"
+import (
+	"errors"
+	"github.com/rkjdid/gocx/ts"
+	"testing"
+)
 
 func TestTransaction_Cost(t *testing.T) {
 	tx := Transaction{
@@ -29,6 +33,34 @@ func TestPosition_Net(t *testing.T) {
 	}
 }
 
+func TestPosition_Value(t *testing.T) {
+	margin := DefaultMargin
+	margin.InterestRate = 0
+	pt := &PaperTrading{FeesRate: 0.001, Margin: &margin}
+	pt.Update(ts.OHLCV{Close: 100})
+	long := NewPosition(pt, "ABC", "BTC", Long)
+	short := NewPosition(pt, "ABC", "BTC", Short)
+	_ = long.MarketBuy(2)
+	_ = short.MarketSell(2)
+
+	// half sold at 110, proceeds are on account, the rest is worth 110
+	tick := ts.OHLCV{Close: 110}
+	long.SetTick(tick)
+	short.SetTick(tick)
+	_ = long.Reduce(1)
+	_ = short.Reduce(1)
+	if v := long.Value(); !almostEq(v, 110*(1-0.001)) {
+		t.Errorf("expected long value of 1 at 110 net of fees, got %f", v)
+	}
+	if v := short.Value(); !almostEq(v, -110*(1+0.001)) {
+		t.Errorf("expected short to buy back 1 at 110 with fees, got %f", v)
+	}
+	_ = long.Close()
+	if v := long.Value(); v != 0 {
+		t.Errorf("expected closed position value of 0, got %f", v)
+	}
+}
+
 func TestPosition_AddTransaction(t *testing.T) {
 	broker := &PaperTrading{
 		FeesRate: DefaultFees,
@@ -84,3 +116,83 @@ func TestPosition_AddTransaction(t *testing.T) {
 		t.Errorf("unexpected net worth, got %f", p.Net())
 	}
 }
+
+func TestPosition_Audit(t *testing.T) {
+	broker := &PaperTrading{Price: 10}
+	p := NewPosition(broker, "ABC", "BTC", Long)
+	_ = p.MarketBuy(2)
+	_ = p.MarketSell(1)
+	if len(p.Orders) != 2 || p.Orders[0].ClientId == "" {
+		t.Fatalf("expected 2 orders with client ids, got %d", len(p.Orders))
+	}
+	for _, tx := range p.Transactions {
+		if p.Order(tx.OrderId) == nil {
+			t.Errorf("transaction not linked to an order of position")
+		}
+	}
+	if _, err := p.Audit(); err != nil {
+		t.Error(err)
+	}
+	p.Total = 3
+	if _, err := p.Audit(); err == nil {
+		t.Errorf("expected audit to catch total not matching orders")
+	}
+}
+
+// scriptedBroker fails PlaceOrder calls following steps, then behaves like PaperTrading.
+type scriptedBroker struct {
+	*PaperTrading
+	steps  []string
+	placed int
+}
+
+func (b *scriptedBroker) PlaceOrder(o Order) (*Order, error) {
+	step := "ok"
+	if len(b.steps) > 0 {
+		step, b.steps = b.steps[0], b.steps[1:]
+	}
+	switch step {
+	case "fail":
+		return nil, errors.New("rejected")
+	case "transient":
+		return nil, TransientError{errors.New("too many requests")}
+	case "timeout":
+		// order reaches the exchange, but the response is lost
+		b.placed++
+		_, _ = b.PaperTrading.PlaceOrder(o)
+		return nil, TransientError{errors.New("timeout")}
+	}
+	b.placed++
+	return b.PaperTrading.PlaceOrder(o)
+}
+
+func TestPosition_MarketOrderRetries(t *testing.T) {
+	retryDelay = 0
+	for _, tc := range []struct {
+		steps  []string
+		ok     bool
+		placed int
+	}{
+		{nil, true, 1},
+		{[]string{"fail"}, false, 0},
+		{[]string{"transient", "ok"}, true, 1},
+		{[]string{"timeout"}, true, 1},
+		{[]string{"transient", "timeout"}, true, 1},
+		{[]string{"transient", "transient", "transient"}, false, 0},
+		{[]string{"transient", "transient", "timeout"}, true, 1},
+		{[]string{"transient", "fail"}, false, 0},
+	} {
+		b := &scriptedBroker{PaperTrading: &PaperTrading{Price: 10}, steps: tc.steps}
+		p := NewPosition(b, "ABC", "BTC", Long)
+		err := p.MarketBuy(1)
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: expected ok %v, got %v", tc.steps, tc.ok, err)
+		}
+		if b.placed != tc.placed {
+			t.Errorf("%v: expected %d orders executed, got %d", tc.steps, tc.placed, b.placed)
+		}
+		if p.Total != float64(tc.placed) {
+			t.Errorf("%v: expected position total %d, got %f", tc.steps, tc.placed, p.Total)
+		}
+	}
+}
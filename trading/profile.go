@@ -1,6 +1,195 @@
 package trading
 
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile holds exit rules of positions. TakeProfit & StopLoss are fractions
+// of entry price, other rules are disabled when zero.
 type Profile struct {
 	TakeProfit float64
 	StopLoss   float64
+
+	// TrailingStop trails the stop at this fraction from the best price since entry.
+	TrailingStop float64
+	// TrailingATR trails the stop at this many ATR(ATRPeriod) from the best price.
+	TrailingATR float64
+	ATRPeriod   int
+	// Ladder takes profits in steps, in place of TakeProfit.
+	Ladder Ladder
+	// BreakEven moves the stop to entry price once gain reaches it.
+	BreakEven float64
+	// MaxHolding closes positions held longer.
+	MaxHolding time.Duration
+}
+
+// Rules describes exit rules of p besides TakeProfit & StopLoss, empty if none.
+func (p Profile) Rules() string {
+	var rules []string
+	if p.TrailingStop > 0 {
+		rules = append(rules, fmt.Sprintf("trail %.1f%%", p.TrailingStop*100))
+	}
+	if p.TrailingATR > 0 {
+		rules = append(rules, fmt.Sprintf("trail %.1f atr(%d)", p.TrailingATR, p.ATRPeriod))
+	}
+	if len(p.Ladder) > 0 {
+		rules = append(rules, "ladder "+p.Ladder.String())
+	}
+	if p.BreakEven > 0 {
+		rules = append(rules, fmt.Sprintf("break-even %.1f%%", p.BreakEven*100))
+	}
+	if p.MaxHolding > 0 {
+		rules = append(rules, fmt.Sprintf("max %s", p.MaxHolding))
+	}
+	return strings.Join(rules, " ")
+}
+
+// Step of a take profit Ladder closes Fraction of initial quantity at Gain.
+type Step struct {
+	Gain     float64
+	Fraction float64
+}
+
+// Ladder steps, by increasing Gain. The last step closes what is left.
+type Ladder []Step
+
+// ParseLadder parses steps formatted as gain:fraction, comma separated,
+// e.g. "0.05:0.5,0.1:0.5".
+func ParseLadder(s string) (Ladder, error) {
+	var l Ladder
+	if s == "" {
+		return l, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(v), ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid step \"%s\", expected gain:fraction", v)
+		}
+		gain, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step gain: %s", err)
+		}
+		fraction, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step fraction: %s", err)
+		}
+		if fraction <= 0 || fraction > 1 {
+			return nil, fmt.Errorf("step fraction %f out of (0, 1]", fraction)
+		}
+		if len(l) > 0 && gain <= l[len(l)-1].Gain {
+			return nil, fmt.Errorf("step gains must increase")
+		}
+		l = append(l, Step{gain, fraction})
+	}
+	return l, nil
+}
+
+func (l Ladder) String() string {
+	var steps []string
+	for _, s := range l {
+		steps = append(steps, fmt.Sprintf("%g:%g", s.Gain, s.Fraction))
+	}
+	return strings.Join(steps, ",")
+}
+
+// Tracker applies a Profile to a position: it follows prices since entry to
+// move the stop, and gives levels of the next exits.
+type Tracker struct {
+	Profile
+	Direction Direction
+	Entry     float64
+	OpenTime  time.Time
+	// Best is the best price reached since entry.
+	Best float64
+	// Stop is the current stop loss price.
+	Stop float64
+	// Steps is the number of Ladder steps taken.
+	Steps int
+}
+
+func NewTracker(profile Profile, direction Direction, entry float64, openTime time.Time) *Tracker {
+	t := &Tracker{
+		Profile: profile, Direction: direction, Entry: entry, OpenTime: openTime, Best: entry,
+	}
+	t.Stop = t.level(-profile.StopLoss)
+	return t
+}
+
+// level returns the price at gain from entry, in t direction.
+func (t Tracker) level(gain float64) float64 {
+	if t.Direction == Short {
+		return t.Entry * (1 - gain)
+	}
+	return t.Entry * (1 + gain)
+}
+
+// better returns true if price a is better than b in t direction.
+func (t Tracker) better(a, b float64) bool {
+	if t.Direction == Short {
+		return a < b
+	}
+	return a > b
+}
+
+// Target returns the price of the next take profit, and the fraction of
+// initial quantity it closes, 1 for all that is left.
+func (t Tracker) Target() (price, fraction float64) {
+	if len(t.Ladder) == 0 {
+		return t.level(t.TakeProfit), 1
+	}
+	if t.Steps >= len(t.Ladder)-1 {
+		return t.level(t.Ladder[len(t.Ladder)-1].Gain), 1
+	}
+	step := t.Ladder[t.Steps]
+	return t.level(step.Gain), step.Fraction
+}
+
+// Reached returns true if price is at or beyond take profit level tp.
+func (t Tracker) Reached(price, tp float64) bool {
+	return !t.better(tp, price)
+}
+
+// Stopped returns true if price is at or beyond the stop.
+func (t Tracker) Stopped(price float64) bool {
+	return !t.better(price, t.Stop)
+}
+
+// Expired returns true if the position is held longer than MaxHolding at now.
+func (t Tracker) Expired(now time.Time) bool {
+	return t.MaxHolding > 0 && now.Sub(t.OpenTime) >= t.MaxHolding
+}
+
+// Update follows bar o once exits were checked against it, atr is the market ATR
+// used by TrailingATR. The stop only moves in favor of the position.
+func (t *Tracker) Update(o ts.OHLCV, atr float64) {
+	best := o.High
+	if t.Direction == Short {
+		best = o.Low
+	}
+	if t.better(best, t.Best) {
+		t.Best = best
+	}
+	sign := 1.0
+	if t.Direction == Short {
+		sign = -1
+	}
+	stops := []float64{t.Stop}
+	if t.TrailingStop > 0 {
+		stops = append(stops, t.Best*(1-sign*t.TrailingStop))
+	}
+	if t.TrailingATR > 0 && atr > 0 {
+		stops = append(stops, t.Best-sign*t.TrailingATR*atr)
+	}
+	if t.BreakEven > 0 && !t.better(t.level(t.BreakEven), t.Best) {
+		stops = append(stops, t.Entry)
+	}
+	for _, s := range stops {
+		if t.better(s, t.Stop) {
+			t.Stop = s
+		}
+	}
 }
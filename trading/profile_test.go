@@ -0,0 +1,80 @@
+package trading
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLadder(t *testing.T) {
+	l, err := ParseLadder("0.05:0.5, 0.1:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 || l[0] != (Step{0.05, 0.5}) || l[1] != (Step{0.1, 0.5}) {
+		t.Errorf("unexpected ladder: %v", l)
+	}
+	if l.String() != "0.05:0.5,0.1:0.5" {
+		t.Errorf("unexpected ladder string: %s", l)
+	}
+	for _, s := range []string{"0.05", "0.05:0", "0.05:1.5", "0.1:0.5,0.05:0.5", "a:0.5"} {
+		if _, err := ParseLadder(s); err == nil {
+			t.Errorf("expected %s to fail", s)
+		}
+	}
+}
+
+func TestTracker_Trailing(t *testing.T) {
+	tr := NewTracker(Profile{StopLoss: 0.1, TrailingStop: 0.05}, Long, 10, dayBar(0, 10).Timestamp.T())
+	if !almostEq(tr.Stop, 9) {
+		t.Errorf("expected initial stop at 9, got %f", tr.Stop)
+	}
+	tr.Update(dayBar(1, 12), 0)
+	if !almostEq(tr.Stop, 11.4) {
+		t.Errorf("expected stop trailing at 11.4, got %f", tr.Stop)
+	}
+	// stop only tightens
+	tr.Update(dayBar(2, 11.5), 0)
+	if !almostEq(tr.Stop, 11.4) || !tr.Stopped(11.3) || tr.Stopped(11.5) {
+		t.Errorf("expected stop to stay at 11.4, got %f", tr.Stop)
+	}
+
+	tr = NewTracker(Profile{StopLoss: 0.1, TrailingATR: 2}, Short, 10, time.Time{})
+	tr.Update(dayBar(1, 8), 0.5)
+	if !almostEq(tr.Stop, 9) {
+		t.Errorf("expected short stop 2 atr above 8, got %f", tr.Stop)
+	}
+}
+
+func TestTracker_BreakEven(t *testing.T) {
+	tr := NewTracker(Profile{StopLoss: 0.1, BreakEven: 0.05}, Short, 10, time.Time{})
+	tr.Update(dayBar(1, 9.6), 0)
+	if !almostEq(tr.Stop, 11) {
+		t.Errorf("expected stop unchanged below break-even gain, got %f", tr.Stop)
+	}
+	tr.Update(dayBar(2, 9.5), 0)
+	if !almostEq(tr.Stop, 10) {
+		t.Errorf("expected stop at entry, got %f", tr.Stop)
+	}
+}
+
+func TestTracker_Target(t *testing.T) {
+	tr := NewTracker(Profile{TakeProfit: 0.2, Ladder: Ladder{{0.05, 0.5}, {0.1, 0.3}, {0.2, 0.2}}}, Long, 10, time.Time{})
+	for i, expected := range []Step{{10.5, 0.5}, {11, 0.3}, {12, 1}, {12, 1}} {
+		price, fraction := tr.Target()
+		if !almostEq(price, expected.Gain) || !almostEq(fraction, expected.Fraction) {
+			t.Errorf("step %d: expected %v, got %f %f", i, expected, price, fraction)
+		}
+		tr.Steps++
+	}
+	if !tr.Reached(12, 12) || tr.Reached(11.9, 12) {
+		t.Errorf("unexpected take profit reached")
+	}
+
+	tr = NewTracker(Profile{TakeProfit: 0.2, MaxHolding: 48 * time.Hour}, Short, 10, dayBar(0, 10).Timestamp.T())
+	if price, fraction := tr.Target(); !almostEq(price, 8) || fraction != 1 {
+		t.Errorf("expected short take profit at 8, got %f %f", price, fraction)
+	}
+	if tr.Expired(dayBar(1, 10).Timestamp.T()) || !tr.Expired(dayBar(2, 10).Timestamp.T()) {
+		t.Errorf("expected position to expire after 2 days")
+	}
+}
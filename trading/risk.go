@@ -0,0 +1,300 @@
+package trading
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRiskLimit is returned by RiskManager for orders breaching a limit, or
+// placed once its kill switch tripped.
+var ErrRiskLimit = errors.New("risk limit")
+
+// Names of RiskLimits, as found in Breach.Limit.
+const (
+	LimitDailyLoss     = "daily-loss"
+	LimitDrawdown      = "drawdown"
+	LimitAssetExposure = "asset-exposure"
+	LimitExposure      = "exposure"
+	LimitOrderSize     = "order-size"
+	LimitOrderRate     = "order-rate"
+)
+
+// RiskLimits of a RiskManager, limits are disabled when zero. Loss & exposure
+// limits are fractions of equity.
+type RiskLimits struct {
+	// MaxDailyLoss is the loss from equity at the start of the UTC day, and
+	// MaxDrawdown the loss from peak equity, that trip the kill switch.
+	MaxDailyLoss float64
+	MaxDrawdown  float64
+	// MaxAssetExposure & MaxExposure cap holdings value, of an asset and in total.
+	MaxAssetExposure float64
+	MaxExposure      float64
+	// MaxOrderSize caps the value of an order, in quote.
+	MaxOrderSize       float64
+	MaxOrdersPerMinute int
+	// Flatten closes tracked positions when the kill switch trips.
+	Flatten bool
+}
+
+// Breach of a limit, Value is what exceeded Max.
+type Breach struct {
+	Time  time.Time
+	Limit string
+	Value float64
+	Max   float64
+}
+
+func (b Breach) String() string {
+	return fmt.Sprintf("%s %s breached: %f > %f", b.Time.Format(time.RFC3339), b.Limit, b.Value, b.Max)
+}
+
+// RiskManager is a Broker enforcing RiskLimits on orders sent to the Broker it wraps.
+// Orders breaching a limit are rejected. Loss limits trip a kill switch blocking
+// every order but those reducing holdings, until Reset.
+//
+// Equity & exposure are valued on Account holdings net of loans, at prices given
+// to Update, in Quote.
+type RiskManager struct {
+	Broker
+	Limits  RiskLimits
+	Account *Account
+	Quote   string
+	// OnBreach, when set, is called on every breach after it is logged, it must
+	// not call methods of the RiskManager. Loss limits breaches trip the kill switch.
+	OnBreach func(Breach)
+
+	mu sync.Mutex
+	// assets of symbols, see Symbol
+	assets    map[string]string
+	prices    map[string]float64
+	positions []*Position
+	orders    []time.Time
+	time      time.Time
+	day       time.Time
+	dayStart  float64
+	peak      float64
+	tripped   *Breach
+}
+
+func NewRiskManager(b Broker, limits RiskLimits, account *Account, quote string) *RiskManager {
+	return &RiskManager{
+		Broker: b, Limits: limits, Account: account, Quote: quote,
+		assets: map[string]string{}, prices: map[string]float64{},
+	}
+}
+
+// Symbol returns the symbol of base & quote on the wrapped broker, and remembers
+// base as the asset traded by orders on it.
+func (r *RiskManager) Symbol(base, quote string) string {
+	sym := r.Broker.Symbol(base, quote)
+	r.mu.Lock()
+	r.assets[sym] = base
+	r.mu.Unlock()
+	return sym
+}
+
+// Track adds p to positions closed when the kill switch trips, if Limits.Flatten is set.
+func (r *RiskManager) Track(p *Position) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.positions = append(r.positions, p)
+}
+
+// Tripped returns the breach that tripped the kill switch, nil if it did not.
+func (r *RiskManager) Tripped() *Breach {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tripped
+}
+
+// Reset re-enables orders after the kill switch tripped, loss limits
+// start over from current equity.
+func (r *RiskManager) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tripped = nil
+	r.peak = r.equity()
+	r.dayStart = r.peak
+}
+
+// Update sets the price of asset at t, and checks loss limits.
+func (r *RiskManager) Update(t time.Time, asset string, price float64) {
+	r.mu.Lock()
+	r.prices[asset] = price
+	if t.After(r.time) {
+		r.time = t
+	}
+	equity := r.equity()
+	if day := r.time.UTC().Truncate(24 * time.Hour); !day.Equal(r.day) {
+		r.day, r.dayStart = day, equity
+	}
+	if equity > r.peak {
+		r.peak = equity
+	}
+	var breach *Breach
+	if r.tripped == nil {
+		if loss := 1 - equity/r.dayStart; r.Limits.MaxDailyLoss > 0 && r.dayStart > 0 && loss > r.Limits.MaxDailyLoss {
+			breach = r.breach(LimitDailyLoss, loss, r.Limits.MaxDailyLoss)
+		} else if dd := 1 - equity/r.peak; r.Limits.MaxDrawdown > 0 && r.peak > 0 && dd > r.Limits.MaxDrawdown {
+			breach = r.breach(LimitDrawdown, dd, r.Limits.MaxDrawdown)
+		}
+		r.tripped = breach
+	}
+	var positions []*Position
+	if breach != nil && r.Limits.Flatten {
+		positions = r.positions
+		r.positions = nil
+	}
+	r.mu.Unlock()
+
+	// unlocked, closing orders go through r
+	for _, p := range positions {
+		if !p.Active() {
+			continue
+		}
+		if err := p.Close(); err != nil {
+			log.Printf("risk: flatten %s%s: %s", p.Base, p.Quote, err)
+		}
+	}
+}
+
+// equity returns Account value in Quote, assets without price are left out.
+func (r *RiskManager) equity() float64 {
+	equity := r.Account.Total(r.Quote) - r.Account.Debt(r.Quote)
+	for asset, price := range r.prices {
+		if asset != r.Quote {
+			equity += r.holding(asset) * price
+		}
+	}
+	return equity
+}
+
+// holding returns Account holding of asset net of its loan, negative when short.
+func (r *RiskManager) holding(asset string) float64 {
+	return r.Account.Total(asset) - r.Account.Debt(asset)
+}
+
+// breach logs & reports a breach of limit at the current time.
+func (r *RiskManager) breach(limit string, value, max float64) *Breach {
+	b := &Breach{Time: r.now(), Limit: limit, Value: value, Max: max}
+	log.Printf("risk: %s", b)
+	if r.OnBreach != nil {
+		r.OnBreach(*b)
+	}
+	return b
+}
+
+// now is the time of the last Update, or wall time without updates.
+func (r *RiskManager) now() time.Time {
+	if r.time.IsZero() {
+		return time.Now()
+	}
+	return r.time
+}
+
+// check returns ErrRiskLimit if an order of q asset at price breaches a limit,
+// orders reducing holdings always pass.
+func (r *RiskManager) check(asset string, direction Direction, q, price float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.holding(asset)
+	if (direction == Buy) != (h > 0) && h != 0 && q <= math.Abs(h)*(1+1e-9) {
+		return nil
+	}
+	if r.tripped != nil {
+		return fmt.Errorf("%s: kill switch tripped by %s", ErrRiskLimit, r.tripped.Limit)
+	}
+	if price == 0 {
+		price = r.prices[asset]
+	}
+	sized := r.Limits.MaxOrderSize > 0 || r.Limits.MaxAssetExposure > 0 || r.Limits.MaxExposure > 0
+	if sized && price == 0 {
+		// unknown notional, size & exposure limits cannot be checked
+		return fmt.Errorf("%s: no known price of %s", ErrRiskLimit, asset)
+	}
+	now := r.now()
+	notional := q * price
+	var breach *Breach
+	if r.Limits.MaxOrderSize > 0 && notional > r.Limits.MaxOrderSize {
+		breach = r.breach(LimitOrderSize, notional, r.Limits.MaxOrderSize)
+	}
+	if max := r.Limits.MaxOrdersPerMinute; breach == nil && max > 0 {
+		// drop orders older than a minute
+		i := 0
+		for i < len(r.orders) && now.Sub(r.orders[i]) >= time.Minute {
+			i++
+		}
+		r.orders = r.orders[i:]
+		if len(r.orders) >= max {
+			breach = r.breach(LimitOrderRate, float64(len(r.orders)+1), float64(max))
+		}
+	}
+	if breach == nil && (r.Limits.MaxAssetExposure > 0 || r.Limits.MaxExposure > 0) {
+		equity := r.equity()
+		exposure := math.Abs(h)*r.prices[asset] + notional
+		var total float64
+		for a, p := range r.prices {
+			if a != r.Quote && a != asset {
+				total += math.Abs(r.holding(a)) * p
+			}
+		}
+		total += exposure
+		if max := r.Limits.MaxAssetExposure * equity; r.Limits.MaxAssetExposure > 0 && exposure > max {
+			breach = r.breach(LimitAssetExposure, exposure, max)
+		} else if max := r.Limits.MaxExposure * equity; r.Limits.MaxExposure > 0 && total > max {
+			breach = r.breach(LimitExposure, total, max)
+		}
+	}
+	if breach != nil {
+		return fmt.Errorf("%s: %s", ErrRiskLimit, breach)
+	}
+	r.orders = append(r.orders, now)
+	return nil
+}
+
+// asset returns the asset traded on sym, as seen by Symbol, or o.Base if set.
+func (r *RiskManager) asset(sym, base string) string {
+	if base != "" {
+		return base
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.assets[sym]
+}
+
+func (r *RiskManager) MarketBuy(sym string, q float64) ([]*Transaction, error) {
+	if err := r.check(r.asset(sym, ""), Buy, q, 0); err != nil {
+		return nil, err
+	}
+	return r.Broker.MarketBuy(sym, q)
+}
+
+func (r *RiskManager) MarketSell(sym string, q float64) ([]*Transaction, error) {
+	if err := r.check(r.asset(sym, ""), Sell, q, 0); err != nil {
+		return nil, err
+	}
+	return r.Broker.MarketSell(sym, q)
+}
+
+func (r *RiskManager) PlaceOrder(o Order) (*Order, error) {
+	if err := r.check(r.asset(o.Symbol, o.Base), o.Direction, o.Quantity, o.Price); err != nil {
+		return nil, err
+	}
+	return r.Broker.PlaceOrder(o)
+}
+
+// PlaceOCO checks limit, the stop of a pair exits the same quantity.
+func (r *RiskManager) PlaceOCO(limit, stop Order) ([]*Order, error) {
+	if err := r.check(r.asset(limit.Symbol, limit.Base), limit.Direction, limit.Quantity, limit.Price); err != nil {
+		return nil, err
+	}
+	return r.Broker.PlaceOCO(limit, stop)
+}
+
+func (r *RiskManager) Name() string {
+	return r.Broker.Name() + "+risk"
+}
@@ -0,0 +1,104 @@
+package trading
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRiskManager(limits RiskLimits) (*RiskManager, *PaperTrading) {
+	a := NewAccount()
+	a.Deposit("BTC", 1)
+	pt := &PaperTrading{Account: a}
+	pt.Update(dayBar(0, 10))
+	r := NewRiskManager(pt, limits, a, "BTC")
+	r.Update(dayBar(0, 10).Timestamp.T(), "ABC", 10)
+	return r, pt
+}
+
+func TestRiskManager_Orders(t *testing.T) {
+	r, _ := testRiskManager(RiskLimits{MaxOrderSize: 0.5, MaxAssetExposure: 0.6, MaxOrdersPerMinute: 2})
+	var breaches []string
+	r.OnBreach = func(b Breach) {
+		breaches = append(breaches, b.Limit)
+	}
+	p := NewPosition(r, "ABC", "BTC", Long)
+	if err := p.MarketBuy(0.06); err == nil || !strings.Contains(err.Error(), ErrRiskLimit.Error()) {
+		t.Errorf("expected order size limit, got %v", err)
+	}
+	if err := p.MarketBuy(0.04); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MarketBuy(0.03); err == nil {
+		t.Errorf("expected asset exposure limit")
+	}
+	if err := p.MarketBuy(0.01); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MarketBuy(0.01); err == nil {
+		t.Errorf("expected order rate limit")
+	}
+	// reducing holdings always passes
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// size of orders on assets without price is unknown
+	if err := NewPosition(r, "XYZ", "BTC", Long).MarketBuy(1); err == nil || !strings.Contains(err.Error(), "no known price") {
+		t.Errorf("expected order without price to be rejected, got %v", err)
+	}
+	expected := []string{LimitOrderSize, LimitAssetExposure, LimitOrderRate}
+	if strings.Join(breaches, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected breaches %v, got %v", expected, breaches)
+	}
+}
+
+func TestRiskManager_KillSwitch(t *testing.T) {
+	r, pt := testRiskManager(RiskLimits{MaxDrawdown: 0.2, Flatten: true})
+	p := NewPosition(r, "ABC", "BTC", Long)
+	if err := p.MarketBuy(0.05); err != nil {
+		t.Fatal(err)
+	}
+	r.Track(p)
+
+	// equity 1 -> 1.25 -> 0.9
+	for i, price := range []float64{15, 8} {
+		pt.Update(dayBar(i+1, price))
+		r.Update(dayBar(i+1, price).Timestamp.T(), "ABC", price)
+	}
+	b := r.Tripped()
+	if b == nil || b.Limit != LimitDrawdown || !almostEq(b.Value, 0.28) {
+		t.Fatalf("expected drawdown to trip, got %v", b)
+	}
+	if p.State != Closed || !almostEq(r.Account.Total("ABC"), 0) {
+		t.Errorf("expected position to be flattened, got %s", p)
+	}
+	p = NewPosition(r, "ABC", "BTC", Long)
+	if err := p.MarketBuy(0.01); err == nil {
+		t.Errorf("expected orders to be blocked")
+	}
+	r.Reset()
+	if err := p.MarketBuy(0.01); err != nil {
+		t.Errorf("expected orders after reset, got %s", err)
+	}
+}
+
+func TestRiskManager_DailyLoss(t *testing.T) {
+	r, pt := testRiskManager(RiskLimits{MaxDailyLoss: 0.1})
+	p := NewPosition(r, "ABC", "BTC", Long)
+	if err := p.MarketBuy(0.1); err != nil {
+		t.Fatal(err)
+	}
+	// -8% on day 0, day 1 starts at 0.92
+	for i, price := range []float64{9.2, 9.2, 8.4} {
+		pt.Update(dayBar((i+1)/2, price))
+		r.Update(dayBar((i+1)/2, price).Timestamp.T().Add(time.Hour*time.Duration(i)), "ABC", price)
+		if r.Tripped() != nil {
+			t.Fatalf("unexpected breach on update %d: %s", i, r.Tripped())
+		}
+	}
+	pt.Update(dayBar(1, 8.2))
+	r.Update(dayBar(1, 8.2).Timestamp.T().Add(time.Hour*3), "ABC", 8.2)
+	if b := r.Tripped(); b == nil || b.Limit != LimitDailyLoss {
+		t.Errorf("expected daily loss to trip, got %v", b)
+	}
+}
@@ -0,0 +1,59 @@
+package trading
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"math"
+)
+
+// Slippage models the price of market orders filled by PaperTrading.
+type Slippage interface {
+	// Slip returns the fill price of a market order for q units in direction
+	// dir, given reference price and the bar o during which it executes.
+	Slip(dir Direction, q, price float64, o ts.OHLCV) float64
+}
+
+// FixedBps slips every order by a fixed amount of basis points.
+type FixedBps float64
+
+func (bps FixedBps) Slip(dir Direction, q, price float64, o ts.OHLCV) float64 {
+	return adverse(dir, price, float64(bps)/1e4)
+}
+
+// HalfSpread is the bid/ask spread as a fraction of price, market
+// orders are assumed to cross half of it.
+type HalfSpread float64
+
+func (s HalfSpread) Slip(dir Direction, q, price float64, o ts.OHLCV) float64 {
+	return adverse(dir, price, float64(s)/2)
+}
+
+// VolumeParticipation slips orders by Impact * sqrt(q / o.Volume), i.e.
+// the more of the bar volume an order takes, the worse its price.
+// Orders on bars without volume get the full Impact.
+type VolumeParticipation float64
+
+func (impact VolumeParticipation) Slip(dir Direction, q, price float64, o ts.OHLCV) float64 {
+	participation := 1.0
+	if o.Volume > 0 {
+		participation = math.Min(q/o.Volume, 1)
+	}
+	return adverse(dir, price, float64(impact)*math.Sqrt(participation))
+}
+
+// Slippages combines several models, applied in order.
+type Slippages []Slippage
+
+func (ss Slippages) Slip(dir Direction, q, price float64, o ts.OHLCV) float64 {
+	for _, s := range ss {
+		price = s.Slip(dir, q, price, o)
+	}
+	return price
+}
+
+// adverse moves price by frac against an order in direction dir.
+func adverse(dir Direction, price, frac float64) float64 {
+	if dir == Buy {
+		return price * (1 + frac)
+	}
+	return price * (1 - frac)
+}
@@ -0,0 +1,40 @@
+package trading
+
+import (
+	"github.com/rkjdid/gocx/ts"
+	"math"
+	"testing"
+)
+
+func TestSlippage(t *testing.T) {
+	almostEq := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+	bar := ts.OHLCV{Close: 100, Volume: 400}
+	for _, tc := range []struct {
+		name      string
+		s         Slippage
+		buy, sell float64
+	}{
+		{"bps", FixedBps(10), 100.1, 99.9},
+		{"spread", HalfSpread(0.002), 100.1, 99.9},
+		{"volume", VolumeParticipation(0.01), 100.5, 99.5},
+		{"combined", Slippages{HalfSpread(0.002), FixedBps(10)}, 100.1 * 1.001, 99.9 * 0.999},
+	} {
+		if p := tc.s.Slip(Buy, 100, 100, bar); !almostEq(p, tc.buy) {
+			t.Errorf("%s: expected buy at %f, got %f", tc.name, tc.buy, p)
+		}
+		if p := tc.s.Slip(Sell, 100, 100, bar); !almostEq(p, tc.sell) {
+			t.Errorf("%s: expected sell at %f, got %f", tc.name, tc.sell, p)
+		}
+	}
+}
+
+func TestPaperTrading_Slippage(t *testing.T) {
+	pt := &PaperTrading{Slippage: FixedBps(100)}
+	pt.Update(ts.OHLCV{Close: 10, Volume: 1})
+	txs, _ := pt.MarketBuy("ABCBTC", 1)
+	if txs[0].Price != 10.1 {
+		t.Errorf("expected fill at 10.1, got %f", txs[0].Price)
+	}
+}
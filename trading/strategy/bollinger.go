@@ -0,0 +1,126 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+)
+
+type BollingerOpts struct {
+	Period int
+	// Dev is the number of standard deviations of the bands from their middle.
+	Dev float64
+	// Breakout follows closes crossing out of the bands, in place of
+	// trading their return inside.
+	Breakout  bool
+	Timeframe ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "bollinger",
+		Prefix:  "bollinger",
+		Options: func() Options { return &BollingerOpts{Period: 20, Dev: 2} },
+	})
+}
+
+func (opts BollingerOpts) NewBollinger() *Bollinger {
+	return &Bollinger{
+		BollingerOpts: opts,
+		bands:         indicator.NewBollinger(opts.Period, opts.Dev, opts.Dev),
+	}
+}
+
+func (opts BollingerOpts) New() Strategy {
+	return opts.NewBollinger()
+}
+
+func (opts BollingerOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts BollingerOpts) Move() Options {
+	opts.Period += util.RandRange(-1, 1)
+	opts.Dev += util.RandRangeF(-0.1, 0.1)
+	util.FixRangeLinear(&opts.Period, 5, 89)
+	util.FixRangeLinearF(&opts.Dev, 0.5, 4)
+	return &opts
+}
+
+// Validate checks opts are of a positive period & deviation.
+func (opts BollingerOpts) Validate() error {
+	if opts.Period <= 0 {
+		return fmt.Errorf("invalid period %d", opts.Period)
+	}
+	if opts.Dev <= 0 {
+		return fmt.Errorf("invalid deviation %g", opts.Dev)
+	}
+	return nil
+}
+
+func (opts BollingerOpts) String() string {
+	mode := "reversion"
+	if opts.Breakout {
+		mode = "breakout"
+	}
+	return fmt.Sprintf("%d, %g, %s", opts.Period, opts.Dev, mode)
+}
+
+// Bollinger trades closes against Bollinger bands. On breakout, it buys a
+// close above the upper band and sells one below the lower band. On
+// reversion, it buys a close back above the lower band and sells one back
+// below the upper band.
+type Bollinger struct {
+	BollingerOpts
+	Data                          ts.OHLCVs
+	OutUpper, OutMiddle, OutLower []float64
+	LastSignal                    Signal
+	bands                         *indicator.Bollinger
+	history
+}
+
+func (b *Bollinger) AddTick(x trading.Tick) {
+	b.Data = b.keepBars(append(b.Data, x.OHLCV), 2)
+	b.bands.Add(x.OHLCV)
+	if !b.bands.Ready() {
+		return
+	}
+	b.OutUpper = b.keep(append(b.OutUpper, b.bands.Upper()), 2)
+	b.OutMiddle = b.keep(append(b.OutMiddle, b.bands.Value()), 2)
+	b.OutLower = b.keep(append(b.OutLower, b.bands.Lower()), 2)
+	n := len(b.OutMiddle)
+	if n < 2 {
+		return
+	}
+	c0, c := b.Data[len(b.Data)-2].Close, x.Close
+	up0, up := b.OutUpper[n-2], b.OutUpper[n-1]
+	low0, low := b.OutLower[n-2], b.OutLower[n-1]
+	switch {
+	case b.Breakout && c0 <= up0 && c > up:
+		b.LastSignal = newSignal(Buy, x.OHLCV)
+	case b.Breakout && c0 >= low0 && c < low:
+		b.LastSignal = newSignal(Sell, x.OHLCV)
+	case !b.Breakout && c0 < low0 && c >= low:
+		b.LastSignal = newSignal(Buy, x.OHLCV)
+	case !b.Breakout && c0 > up0 && c <= up:
+		b.LastSignal = newSignal(Sell, x.OHLCV)
+	}
+}
+
+func (b *Bollinger) Signal() Signal {
+	return b.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (b *Bollinger) WarmUp() int {
+	return b.bands.WarmUp() + 1
+}
+
+func (b *Bollinger) Draw() error {
+	chart.AddLines([]string{"upper", "middle", "lower"},
+		b.Data.ToXYerSlice(b.OutUpper, b.OutMiddle, b.OutLower)...)
+	return nil
+}
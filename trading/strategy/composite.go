@@ -0,0 +1,382 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+	"log"
+	"strings"
+)
+
+// Mode is how a Composite combines signals of its children.
+type Mode int
+
+const (
+	// All requires every child to agree.
+	All = Mode(iota)
+	// Any follows children as long as they do not disagree.
+	Any
+	// Majority follows more than half of the children.
+	Majority
+	// Weighted follows the weighted mean of children strengths, signed by
+	// their action, once it reaches Threshold, or else the weighted mean of
+	// strengths of exits of a side.
+	Weighted
+)
+
+var modes = map[Mode]string{
+	All:      "and",
+	Any:      "or",
+	Majority: "vote",
+	Weighted: "weighted",
+}
+
+func (m Mode) String() string {
+	return modes[m]
+}
+
+// ParseMode parses the name of a Mode: and, or, vote or weighted.
+func ParseMode(s string) (Mode, error) {
+	for m, name := range modes {
+		if strings.ToLower(s) == name {
+			return m, nil
+		}
+	}
+	return All, fmt.Errorf("unknown mode %q", s)
+}
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Mode) UnmarshalText(text []byte) (err error) {
+	*m, err = ParseMode(string(text))
+	return err
+}
+
+// Child is a strategy of a Composite, fed with ticks of Timeframe only, or
+// all ticks if it is zero. Weight is used in Weighted mode, 1 if unset.
+type Child struct {
+	Strategy
+	Timeframe ts.Timeframe
+	Weight    float64
+
+	last Signal
+	// age is the number of ticks fed since last changed
+	age int
+}
+
+type CompositeOpts struct {
+	Mode Mode
+	// Threshold of Weighted mode, in [0, 1].
+	Threshold float64
+	// Within, if set, only counts signals of children emitted within their
+	// last Within ticks, so that they confirm each other within Within bars.
+	Within int
+}
+
+func (opts CompositeOpts) NewComposite(children ...Child) *Composite {
+	return &Composite{CompositeOpts: opts, Children: children}
+}
+
+func (opts CompositeOpts) String() string {
+	s := opts.Mode.String()
+	if opts.Mode == Weighted {
+		s += fmt.Sprintf(" %g", opts.Threshold)
+	}
+	if opts.Within > 0 {
+		s += fmt.Sprintf(" within %d", opts.Within)
+	}
+	return s
+}
+
+func init() {
+	Register(Registration{
+		Name:    "composite",
+		Prefix:  "composite",
+		Options: func() Options { return &CompositeConfig{} },
+	})
+}
+
+// CompositeConfig are options of a registered composite, holding configs of
+// its children. Children of a single timeframe are fed with its ticks only.
+type CompositeConfig struct {
+	CompositeOpts
+	Children []ChildConfig
+}
+
+// ChildConfig is the config of a composite child, of Weight in Weighted mode.
+type ChildConfig struct {
+	Config
+	Weight float64
+}
+
+func (cc *ChildConfig) UnmarshalJSON(data []byte) error {
+	var w struct{ Weight float64 }
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	cc.Weight = w.Weight
+	return json.Unmarshal(data, &cc.Config)
+}
+
+func (cc ChildConfig) String() string {
+	if cc.Weight != 0 {
+		return fmt.Sprintf("%g*%s", cc.Weight, cc.Config)
+	}
+	return cc.Config.String()
+}
+
+func (opts CompositeConfig) New() Strategy {
+	var children []Child
+	for _, cc := range opts.Children {
+		ch := Child{Strategy: cc.New(), Weight: cc.Weight}
+		if tfs := cc.Options.Timeframes(); len(tfs) == 1 {
+			ch.Timeframe = tfs[0]
+		}
+		children = append(children, ch)
+	}
+	return opts.NewComposite(children...)
+}
+
+// Timeframes returns distinct timeframes of children.
+func (opts CompositeConfig) Timeframes() []ts.Timeframe {
+	var tfs []ts.Timeframe
+	for _, cc := range opts.Children {
+	next:
+		for _, tf := range cc.Options.Timeframes() {
+			for _, seen := range tfs {
+				if tf.Equals(seen) {
+					continue next
+				}
+			}
+			tfs = append(tfs, tf)
+		}
+	}
+	return tfs
+}
+
+// Move moves options of every child, and Threshold & Within when used.
+func (opts CompositeConfig) Move() Options {
+	children := make([]ChildConfig, len(opts.Children))
+	for i, cc := range opts.Children {
+		children[i] = ChildConfig{cc.Config.Move(), cc.Weight}
+	}
+	opts.Children = children
+	if opts.Mode == Weighted {
+		opts.Threshold += util.RandRangeF(-0.05, 0.05)
+		util.FixRangeLinearF(&opts.Threshold, 0, 1)
+	}
+	if opts.Within > 0 {
+		opts.Within += util.RandRange(-1, 1)
+		util.FixRangeLinear(&opts.Within, 1, 21)
+	}
+	return &opts
+}
+
+// Validate checks Threshold is in [0, 1], Within & weights are not negative,
+// and configs of children are valid.
+func (opts CompositeConfig) Validate() error {
+	if len(opts.Children) == 0 {
+		return fmt.Errorf("no children")
+	}
+	if opts.Threshold < 0 || opts.Threshold > 1 {
+		return fmt.Errorf("threshold %g out of [0, 1]", opts.Threshold)
+	}
+	if opts.Within < 0 {
+		return fmt.Errorf("invalid within %d", opts.Within)
+	}
+	for _, cc := range opts.Children {
+		if cc.Weight < 0 {
+			return fmt.Errorf("%s: invalid weight %g", cc.Name, cc.Weight)
+		}
+		if err := cc.Options.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", cc.Name, err)
+		}
+	}
+	return nil
+}
+
+func (opts CompositeConfig) String() string {
+	s := opts.CompositeOpts.String()
+	for _, cc := range opts.Children {
+		s += ", " + cc.String()
+	}
+	return s
+}
+
+// Composite combines signals of its Children according to Mode. Its signal
+// is the latest of children agreeing, with their mean strength, or the
+// weighted mean in Weighted mode. Exits are combined the same way as entries,
+// which take precedence. It is NoSignal when children do not agree.
+type Composite struct {
+	CompositeOpts
+	Children   []Child
+	LastSignal Signal
+}
+
+func (c *Composite) AddTick(x trading.Tick) {
+	fed := false
+	for i := range c.Children {
+		ch := &c.Children[i]
+		if ch.Timeframe != (ts.Timeframe{}) && !x.Timeframe.Equals(ch.Timeframe) {
+			continue
+		}
+		fed = true
+		ch.AddTick(x)
+		if sig := ch.Signal(); sig.Action != ch.last.Action || !sig.Time.Equal(ch.last.Time) {
+			ch.last, ch.age = sig, 0
+		} else {
+			ch.age++
+		}
+	}
+	if !fed {
+		log.Printf("bad timeframe: %s", x.Timeframe)
+		return
+	}
+	c.LastSignal = c.combine()
+}
+
+// active returns the signal of ch, NoSignal if older than Within.
+func (c *Composite) active(ch Child) Signal {
+	if c.Within > 0 && ch.age >= c.Within {
+		return NoSignal
+	}
+	return ch.last
+}
+
+func (c *Composite) combine() Signal {
+	if len(c.Children) == 0 {
+		return NoSignal
+	}
+	var (
+		buys, sells, exitLongs, exitShorts []Signal
+		score, totalWeight                 float64
+		// exit scores of longs & shorts in Weighted mode
+		exitLong, exitShort float64
+	)
+	for _, ch := range c.Children {
+		weight := ch.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		totalWeight += weight
+		switch sig := c.active(ch); sig.Action {
+		case Buy:
+			buys = append(buys, sig)
+			score += weight * sig.Strength
+		case Sell:
+			sells = append(sells, sig)
+			score -= weight * sig.Strength
+		case ExitLong:
+			exitLongs = append(exitLongs, sig)
+			exitLong += weight * sig.Strength
+		case ExitShort:
+			exitShorts = append(exitShorts, sig)
+			exitShort += weight * sig.Strength
+		}
+	}
+	n := len(c.Children)
+	switch c.Mode {
+	case All:
+		if len(buys) == n {
+			return agree(Buy, buys)
+		} else if len(sells) == n {
+			return agree(Sell, sells)
+		} else if len(exitLongs) == n {
+			return agree(ExitLong, exitLongs)
+		} else if len(exitShorts) == n {
+			return agree(ExitShort, exitShorts)
+		}
+	case Any:
+		// exiting a side disagrees with entering it
+		if len(buys) > 0 && len(sells) == 0 && len(exitLongs) == 0 {
+			return agree(Buy, buys)
+		} else if len(sells) > 0 && len(buys) == 0 && len(exitShorts) == 0 {
+			return agree(Sell, sells)
+		} else if len(exitLongs) > 0 && len(buys) == 0 && len(exitShorts) == 0 {
+			return agree(ExitLong, exitLongs)
+		} else if len(exitShorts) > 0 && len(sells) == 0 && len(exitLongs) == 0 {
+			return agree(ExitShort, exitShorts)
+		}
+	case Majority:
+		if 2*len(buys) > n {
+			return agree(Buy, buys)
+		} else if 2*len(sells) > n {
+			return agree(Sell, sells)
+		} else if 2*len(exitLongs) > n {
+			return agree(ExitLong, exitLongs)
+		} else if 2*len(exitShorts) > n {
+			return agree(ExitShort, exitShorts)
+		}
+	case Weighted:
+		if totalWeight == 0 {
+			break
+		}
+		score /= totalWeight
+		exitLong /= totalWeight
+		exitShort /= totalWeight
+		var sig Signal
+		if score > 0 && score >= c.Threshold {
+			sig = agree(Buy, buys)
+		} else if score < 0 && -score >= c.Threshold {
+			sig = agree(Sell, sells)
+			score = -score
+		} else if exitLong > 0 && exitLong >= c.Threshold && exitLong >= exitShort {
+			sig, score = agree(ExitLong, exitLongs), exitLong
+		} else if exitShort > 0 && exitShort >= c.Threshold {
+			sig, score = agree(ExitShort, exitShorts), exitShort
+		} else {
+			break
+		}
+		sig.Strength = score
+		return sig
+	}
+	return NoSignal
+}
+
+// agree returns a signal of action at the latest time of sigs, with their
+// mean strength.
+func agree(action Action, sigs []Signal) Signal {
+	combined := Signal{Action: action}
+	for _, sig := range sigs {
+		if sig.Time.After(combined.Time) {
+			combined.Time = sig.Time
+		}
+		combined.Strength += sig.Strength / float64(len(sigs))
+	}
+	return combined
+}
+
+func (c *Composite) Signal() Signal {
+	return c.LastSignal
+}
+
+// Record records children able to draw.
+func (c *Composite) Record(on bool) {
+	for _, ch := range c.Children {
+		if d, ok := ch.Strategy.(Drawer); ok {
+			d.Record(on)
+		}
+	}
+}
+
+// Draw draws children able to, each with its own line theme.
+func (c *Composite) Draw() error {
+	for i, ch := range c.Children {
+		d, ok := ch.Strategy.(Drawer)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			chart.NextLineTheme()
+		}
+		if err := d.Draw(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package strategy
+
+import (
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"testing"
+	"time"
+)
+
+// script signals b (Buy), s (Sell), x (ExitLong) or y (ExitShort) of
+// strength 0.5 on ticks of its actions, the last signal holds on '.'.
+type script struct {
+	actions    string
+	i          int
+	LastSignal Signal
+}
+
+var scriptActions = map[byte]Action{'b': Buy, 's': Sell, 'x': ExitLong, 'y': ExitShort}
+
+func (s *script) AddTick(x trading.Tick) {
+	if a, ok := scriptActions[s.actions[s.i]]; ok {
+		s.LastSignal = Signal{Action: a, Time: x.Timestamp.T(), Strength: 0.5}
+	}
+	s.i++
+}
+
+func (s *script) Signal() Signal {
+	return s.LastSignal
+}
+
+// run feeds n hourly ticks to a composite of opts over scripts, and returns
+// its actions as b, s, x, y or '.'.
+func run(opts CompositeOpts, weights []float64, scripts ...string) string {
+	var children []Child
+	for i, actions := range scripts {
+		ch := Child{Strategy: &script{actions: actions}}
+		if i < len(weights) {
+			ch.Weight = weights[i]
+		}
+		children = append(children, ch)
+	}
+	c := opts.NewComposite(children...)
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var out []byte
+	for i := range scripts[0] {
+		c.AddTick(trading.Tick{Timeframe: hourly, OHLCV: ts.OHLCV{Timestamp: util.JSONTime(t0.Add(time.Hour * time.Duration(i)))}})
+		out = append(out, map[Action]byte{None: '.', Buy: 'b', Sell: 's', ExitLong: 'x', ExitShort: 'y'}[c.Signal().Action])
+	}
+	return string(out)
+}
+
+func TestComposite(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		opts    CompositeOpts
+		weights []float64
+		scripts []string
+		want    string
+	}{
+		{"and", CompositeOpts{Mode: All},
+			nil, []string{"b...s...", "..b....s"}, "..bb...s"},
+		{"or", CompositeOpts{Mode: Any},
+			nil, []string{"b...s...", "..b....s"}, "bbbb...s"},
+		{"vote", CompositeOpts{Mode: Majority},
+			nil, []string{"b..s....", ".b......", "..s..b.."}, ".bbssbbb"},
+		{"weighted", CompositeOpts{Mode: Weighted, Threshold: 0.3},
+			[]float64{3, 1}, []string{"b...s...", "..s....."}, "bb..ssss"},
+		{"and within", CompositeOpts{Mode: All, Within: 2},
+			nil, []string{"b...s...", "..b..s.."}, ".....s.."},
+		{"or within", CompositeOpts{Mode: Any, Within: 2},
+			nil, []string{"b.......", "....s..."}, "bb..ss.."},
+		{"and exits", CompositeOpts{Mode: All},
+			nil, []string{"b.x.....", "b..x...."}, "bb.xxxxx"},
+		{"or exits", CompositeOpts{Mode: Any},
+			nil, []string{"b.x..y..", "......s."}, "bbxxxy.."},
+		{"or exit disagrees", CompositeOpts{Mode: Any},
+			nil, []string{"b...", "..x."}, "bb.."},
+		{"vote exits", CompositeOpts{Mode: Majority},
+			nil, []string{"x.......", ".x..b...", "..y....."}, ".xxx...."},
+		{"weighted exits", CompositeOpts{Mode: Weighted, Threshold: 0.3},
+			[]float64{3, 1}, []string{"x...b...", "y......."}, "xxxxbbbb"},
+	} {
+		if got := run(c.opts, c.weights, c.scripts...); got != c.want {
+			t.Errorf("%s (%s): expected %s, got %s", c.name, c.opts, c.want, got)
+		}
+	}
+}
+
+func TestComposite_Weighted(t *testing.T) {
+	c := CompositeOpts{Mode: Weighted}.NewComposite(
+		Child{Strategy: &script{actions: "b"}, Weight: 3},
+		Child{Strategy: &script{actions: "s"}},
+	)
+	c.AddTick(trading.Tick{Timeframe: hourly})
+	// (3*0.5 - 1*0.5) / 4
+	if sig := c.Signal(); sig.Action != Buy || sig.Strength != 0.25 {
+		t.Errorf("expected a buy of strength 0.25, got %s", sig)
+	}
+}
+
+func TestComposite_WeightedExits(t *testing.T) {
+	c := CompositeOpts{Mode: Weighted, Threshold: 0.3}.NewComposite(
+		Child{Strategy: &script{actions: "y"}, Weight: 3},
+		Child{Strategy: &script{actions: "x"}},
+	)
+	c.AddTick(trading.Tick{Timeframe: hourly})
+	// 3*0.5 / 4 of exits of shorts
+	if sig := c.Signal(); sig.Action != ExitShort || sig.Strength != 0.375 {
+		t.Errorf("expected an exit short of strength 0.375, got %s", sig)
+	}
+}
+
+func TestComposite_Timeframes(t *testing.T) {
+	h4 := ts.Timeframe{N: 4, Unit: ts.TfHour}
+	fast, slow := &script{actions: "b.."}, &script{actions: "b"}
+	c := CompositeOpts{Mode: All}.NewComposite(Child{Strategy: fast, Timeframe: hourly}, Child{Strategy: slow, Timeframe: h4})
+	c.AddTick(trading.Tick{Timeframe: hourly})
+	c.AddTick(trading.Tick{Timeframe: hourly})
+	if fast.i != 2 || slow.i != 0 || c.Signal().Action != None {
+		t.Fatalf("expected hourly ticks fed to fast only, got %d, %d", fast.i, slow.i)
+	}
+	c.AddTick(trading.Tick{Timeframe: h4})
+	if fast.i != 2 || slow.i != 1 || c.Signal().Action != Buy {
+		t.Errorf("expected a buy once 4h tick fed to slow, got %s", c.Signal())
+	}
+}
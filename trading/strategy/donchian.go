@@ -0,0 +1,94 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+)
+
+type DonchianOpts struct {
+	Period    int
+	Timeframe ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "donchian",
+		Prefix:  "donchian",
+		Options: func() Options { return &DonchianOpts{Period: 20} },
+	})
+}
+
+func (opts DonchianOpts) NewDonchian() *Donchian {
+	return &Donchian{DonchianOpts: opts, channel: indicator.NewDonchian(opts.Period)}
+}
+
+func (opts DonchianOpts) New() Strategy {
+	return opts.NewDonchian()
+}
+
+func (opts DonchianOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts DonchianOpts) Move() Options {
+	opts.Period += util.RandRange(-1, 1)
+	util.FixRangeLinear(&opts.Period, 5, 89)
+	return &opts
+}
+
+// Validate checks opts are of a positive period.
+func (opts DonchianOpts) Validate() error {
+	if opts.Period <= 0 {
+		return fmt.Errorf("invalid period %d", opts.Period)
+	}
+	return nil
+}
+
+func (opts DonchianOpts) String() string {
+	return fmt.Sprintf("%d", opts.Period)
+}
+
+// Donchian is the turtle breakout: it buys a close above the highest high of
+// the previous Period bars, and sells one below their lowest low.
+type Donchian struct {
+	DonchianOpts
+	Data ts.OHLCVs
+	// OutUpper & OutLower are the channel of previous bars, broken out of.
+	OutUpper, OutLower []float64
+	LastSignal         Signal
+	channel            *indicator.Donchian
+	history
+}
+
+func (d *Donchian) AddTick(x trading.Tick) {
+	d.Data = d.keepBars(append(d.Data, x.OHLCV), 2)
+	if d.channel.Ready() {
+		upper, lower := d.channel.Upper(), d.channel.Lower()
+		d.OutUpper = d.keep(append(d.OutUpper, upper), 2)
+		d.OutLower = d.keep(append(d.OutLower, lower), 2)
+		if x.Close > upper {
+			d.LastSignal = newSignal(Buy, x.OHLCV)
+		} else if x.Close < lower {
+			d.LastSignal = newSignal(Sell, x.OHLCV)
+		}
+	}
+	d.channel.Add(x.OHLCV)
+}
+
+func (d *Donchian) Signal() Signal {
+	return d.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (d *Donchian) WarmUp() int {
+	return d.channel.WarmUp() + 1
+}
+
+func (d *Donchian) Draw() error {
+	chart.AddLines([]string{"upper", "lower"}, d.Data.ToXYerSlice(d.OutUpper, d.OutLower)...)
+	return nil
+}
@@ -0,0 +1,118 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+)
+
+type EMACrossOpts struct {
+	Fast, Slow int
+	Timeframe  ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "emacross",
+		Prefix:  "emacross",
+		Options: func() Options { return &EMACrossOpts{Fast: 9, Slow: 21} },
+	})
+}
+
+func (opts EMACrossOpts) NewEMACross() *EMACross {
+	return &EMACross{
+		EMACrossOpts: opts,
+		fast:         indicator.NewEMA(opts.Fast),
+		slow:         indicator.NewEMA(opts.Slow),
+	}
+}
+
+func (opts EMACrossOpts) New() Strategy {
+	return opts.NewEMACross()
+}
+
+func (opts EMACrossOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts EMACrossOpts) Move() Options {
+	opts.Fast += util.RandRange(-1, 1)
+	opts.Slow += util.RandRange(-1, 1)
+	util.FixRangeLinear(&opts.Fast, 2, 55)
+	util.FixRangeLinear(&opts.Slow, 5, 200)
+	if opts.Fast > opts.Slow {
+		opts.Fast, opts.Slow = opts.Slow, opts.Fast
+	}
+	if opts.Fast == opts.Slow {
+		opts.Slow++
+	}
+	return &opts
+}
+
+// Validate checks opts are of positive periods, Fast below Slow.
+func (opts EMACrossOpts) Validate() error {
+	if opts.Fast <= 0 || opts.Slow <= 0 {
+		return fmt.Errorf("invalid periods %d, %d", opts.Fast, opts.Slow)
+	}
+	if opts.Fast >= opts.Slow {
+		return fmt.Errorf("fast period %d not below slow %d", opts.Fast, opts.Slow)
+	}
+	return nil
+}
+
+func (opts EMACrossOpts) String() string {
+	return fmt.Sprintf("%d, %d", opts.Fast, opts.Slow)
+}
+
+// EMACross buys when the fast EMA of closes crosses above the slow one,
+// and sells when it crosses below.
+type EMACross struct {
+	EMACrossOpts
+	Data             ts.OHLCVs
+	OutFast, OutSlow []float64
+	LastSignal       Signal
+	fast, slow       *indicator.EMA
+	history
+}
+
+func (e *EMACross) AddTick(x trading.Tick) {
+	e.Data = e.keepBars(append(e.Data, x.OHLCV), 2)
+	e.fast.Add(x.OHLCV)
+	e.slow.Add(x.OHLCV)
+	if !e.fast.Ready() || !e.slow.Ready() {
+		return
+	}
+	e.OutFast = e.keep(append(e.OutFast, e.fast.Value()), 2)
+	e.OutSlow = e.keep(append(e.OutSlow, e.slow.Value()), 2)
+	n := len(e.OutFast)
+	if n < 2 {
+		return
+	}
+	d0, d := e.OutFast[n-2]-e.OutSlow[n-2], e.OutFast[n-1]-e.OutSlow[n-1]
+	if d0 <= 0 && d > 0 {
+		e.LastSignal = newSignal(Buy, x.OHLCV)
+	} else if d0 >= 0 && d < 0 {
+		e.LastSignal = newSignal(Sell, x.OHLCV)
+	}
+}
+
+func (e *EMACross) Signal() Signal {
+	return e.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (e *EMACross) WarmUp() int {
+	n := e.fast.WarmUp()
+	if e.slow.WarmUp() > n {
+		n = e.slow.WarmUp()
+	}
+	return n + 1
+}
+
+func (e *EMACross) Draw() error {
+	chart.AddLines([]string{"fast ema", "slow ema"}, e.Data.ToXYerSlice(e.OutFast, e.OutSlow)...)
+	return nil
+}
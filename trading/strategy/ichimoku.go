@@ -0,0 +1,112 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+	"math"
+)
+
+type IchimokuOpts struct {
+	Tenkan, Kijun, Senkou int
+	Timeframe             ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "ichimoku",
+		Prefix:  "ichimoku",
+		Options: func() Options { return &IchimokuOpts{Tenkan: 9, Kijun: 26, Senkou: 52} },
+	})
+}
+
+func (opts IchimokuOpts) NewIchimoku() *Ichimoku {
+	return &Ichimoku{
+		IchimokuOpts: opts,
+		cloud:        indicator.NewIchimoku(opts.Tenkan, opts.Kijun, opts.Senkou),
+	}
+}
+
+func (opts IchimokuOpts) New() Strategy {
+	return opts.NewIchimoku()
+}
+
+func (opts IchimokuOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts IchimokuOpts) Move() Options {
+	opts.Tenkan += util.RandRange(-1, 1)
+	opts.Kijun += util.RandRange(-1, 1)
+	opts.Senkou += util.RandRange(-2, 2)
+	util.FixRangeLinear(&opts.Tenkan, 5, 21)
+	util.FixRangeLinear(&opts.Kijun, 13, 55)
+	util.FixRangeLinear(&opts.Senkou, 34, 120)
+	return &opts
+}
+
+// Validate checks opts are of positive periods.
+func (opts IchimokuOpts) Validate() error {
+	if opts.Tenkan <= 0 || opts.Kijun <= 0 || opts.Senkou <= 0 {
+		return fmt.Errorf("invalid periods %d, %d, %d", opts.Tenkan, opts.Kijun, opts.Senkou)
+	}
+	return nil
+}
+
+func (opts IchimokuOpts) String() string {
+	return fmt.Sprintf("%d, %d, %d", opts.Tenkan, opts.Kijun, opts.Senkou)
+}
+
+// Ichimoku buys when a close is above the cloud with the tenkan above the
+// kijun, and sells when a close is below the cloud with the tenkan below the
+// kijun. A signal is emitted on the first bar of either state.
+type Ichimoku struct {
+	IchimokuOpts
+	Data                                    ts.OHLCVs
+	OutTenkan, OutKijun, OutSpanA, OutSpanB []float64
+	LastSignal                              Signal
+	cloud                                   *indicator.Ichimoku
+	history
+}
+
+func (ic *Ichimoku) AddTick(x trading.Tick) {
+	ic.Data = ic.keepBars(append(ic.Data, x.OHLCV), 2)
+	ic.cloud.Add(x.OHLCV)
+	if !ic.cloud.Ready() {
+		return
+	}
+	tenkan, kijun := ic.cloud.TenkanSen(), ic.cloud.KijunSen()
+	a, b := ic.cloud.SpanA(), ic.cloud.SpanB()
+	ic.OutTenkan = ic.keep(append(ic.OutTenkan, tenkan), 2)
+	ic.OutKijun = ic.keep(append(ic.OutKijun, kijun), 2)
+	ic.OutSpanA = ic.keep(append(ic.OutSpanA, a), 2)
+	ic.OutSpanB = ic.keep(append(ic.OutSpanB, b), 2)
+
+	action := None
+	if x.Close > math.Max(a, b) && tenkan > kijun {
+		action = Buy
+	} else if x.Close < math.Min(a, b) && tenkan < kijun {
+		action = Sell
+	}
+	if action != None && action != ic.LastSignal.Action {
+		ic.LastSignal = newSignal(action, x.OHLCV)
+	}
+}
+
+func (ic *Ichimoku) Signal() Signal {
+	return ic.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (ic *Ichimoku) WarmUp() int {
+	return ic.cloud.WarmUp()
+}
+
+func (ic *Ichimoku) Draw() error {
+	chart.AddLines([]string{"tenkan", "kijun", "span a", "span b"},
+		ic.Data.ToXYerSlice(ic.OutTenkan, ic.OutKijun, ic.OutSpanA, ic.OutSpanB)...)
+	return nil
+}
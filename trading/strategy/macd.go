@@ -2,16 +2,28 @@ package strategy
 
 import (
 	"fmt"
-	"github.com/markcheno/go-talib"
 	"github.com/rkjdid/gocx/chart"
 	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
 	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
 	"time"
 )
 
 type MACDOpts struct {
 	Fast, Slow, SignalPeriod int
 	Timeframe                ts.Timeframe
+	// Exits makes MACDCross emit ExitLong on bearish crosses in place of
+	// Sell, so that they close longs rather than open shorts.
+	Exits bool
+}
+
+func init() {
+	Register(Registration{
+		Name:    "macd",
+		Prefix:  "macd",
+		Options: func() Options { return &MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9} },
+	})
 }
 
 func (opts MACDOpts) NewMACD() *MACD {
@@ -19,11 +31,55 @@ func (opts MACDOpts) NewMACD() *MACD {
 }
 
 func (opts MACDOpts) NewMACDCross() *MACDCross {
-	return NewMACDCross(opts.Fast, opts.Slow, opts.SignalPeriod, opts.Timeframe)
+	mc := NewMACDCross(opts.Fast, opts.Slow, opts.SignalPeriod, opts.Timeframe)
+	mc.Exits = opts.Exits
+	return mc
+}
+
+// New returns a MACDCross of opts.
+func (opts MACDOpts) New() Strategy {
+	return opts.NewMACDCross()
+}
+
+func (opts MACDOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts MACDOpts) Move() Options {
+	opts.Fast += util.RandRange(-1, 1)
+	opts.Slow += util.RandRange(-1, 1)
+	opts.SignalPeriod += util.RandRange(-1, 1)
+	util.FixRangeLinear(&opts.Fast, 2, 21)
+	util.FixRangeLinear(&opts.Slow, 13, 89)
+	util.FixRangeLinear(&opts.SignalPeriod, 2, 34)
+
+	// swap values that crossed for macd.slow/fast
+	if opts.Fast > opts.Slow {
+		opts.Fast, opts.Slow = opts.Slow, opts.Fast
+	}
+	if opts.Fast == opts.Slow {
+		opts.Slow++
+	}
+	return &opts
+}
+
+// Validate checks opts are of positive periods, Fast below Slow.
+func (opts MACDOpts) Validate() error {
+	if opts.Fast <= 0 || opts.Slow <= 0 || opts.SignalPeriod <= 0 {
+		return fmt.Errorf("invalid periods %d, %d, %d", opts.Fast, opts.Slow, opts.SignalPeriod)
+	}
+	if opts.Fast >= opts.Slow {
+		return fmt.Errorf("fast period %d not below slow %d", opts.Fast, opts.Slow)
+	}
+	return nil
 }
 
 func (opts MACDOpts) String() string {
-	return fmt.Sprintf("%d, %d, %d", opts.Fast, opts.Slow, opts.SignalPeriod)
+	s := fmt.Sprintf("%d, %d, %d", opts.Fast, opts.Slow, opts.SignalPeriod)
+	if opts.Exits {
+		s += ", exits"
+	}
+	return s
 }
 
 type MACD struct {
@@ -31,6 +87,8 @@ type MACD struct {
 	Data                        ts.OHLCVs
 	LastOHLCV                   ts.OHLCV
 	OutMACD, OutSignal, OutHist []float64
+	macd                        *indicator.MACD
+	history
 }
 
 func NewMACD(f, s, signalPeriod int, tf ts.Timeframe) *MACD {
@@ -41,37 +99,25 @@ func NewMACD(f, s, signalPeriod int, tf ts.Timeframe) *MACD {
 			SignalPeriod: signalPeriod,
 			Timeframe:    tf,
 		},
-		Data: make(ts.OHLCVs, 0, s),
+		macd: indicator.NewMACD(f, s, signalPeriod),
 	}
 }
 
 func (m *MACD) AddTick(x trading.Tick) {
 	m.LastOHLCV = x.OHLCV
-	m.Data = append(m.Data, x.OHLCV)
-	if len(m.Data) <= m.Slow {
+	m.Data = m.keepBars(append(m.Data, x.OHLCV), 2)
+	m.macd.Add(x.OHLCV)
+	if !m.macd.Ready() {
 		return
-		// this value of 5*m.Slow was chosen because it
-		// it yields the same results on the same input data.
-		// Really not sure what to think of it
-	} else if len(m.Data) > 5*m.Slow {
-		m.Data = m.Data[1:]
 	}
-	v, s, h := m.ComputeLast()
-	m.OutMACD = append(m.OutMACD, v)
-	m.OutSignal = append(m.OutSignal, s)
-	m.OutHist = append(m.OutHist, h)
+	m.OutMACD = m.keep(append(m.OutMACD, m.macd.Value()), 2)
+	m.OutSignal = m.keep(append(m.OutSignal, m.macd.Signal()), 2)
+	m.OutHist = m.keep(append(m.OutHist, m.macd.Hist()), 2)
 }
 
-func (m *MACD) Compute() ([]float64, []float64, []float64) {
-	return talib.Macd(m.Data.Close(), m.Fast, m.Slow, m.SignalPeriod)
-}
-
-func (m *MACD) ComputeLast() (float64, float64, float64) {
-	a, b, c := m.Compute()
-	if len(a) == 0 || len(b) == 0 || len(c) == 0 {
-		panic("bad length")
-	}
-	return a[len(a)-1], b[len(b)-1], c[len(c)-1]
+// WarmUp is the number of ticks fed before a first MACD value.
+func (m *MACD) WarmUp() int {
+	return m.macd.WarmUp()
 }
 
 func (m *MACD) Draw() error {
@@ -99,8 +145,12 @@ func (m *MACDCross) AddTick(t trading.Tick) {
 	}
 	x0, x := m.OutHist[len(m.OutHist)-2], m.OutHist[len(m.OutHist)-1]
 	if x0 > 0 && x < 0 {
+		action := Sell
+		if m.Exits {
+			action = ExitLong
+		}
 		m.LastSignal = Signal{
-			Action:   Sell,
+			Action:   action,
 			Time:     time.Time(m.LastOHLCV.Timestamp),
 			Strength: 1,
 		}
@@ -116,3 +166,8 @@ func (m *MACDCross) AddTick(t trading.Tick) {
 func (m *MACDCross) Signal() Signal {
 	return m.LastSignal
 }
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (m *MACDCross) WarmUp() int {
+	return m.MACD.WarmUp() + 1
+}
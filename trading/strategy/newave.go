@@ -1,23 +1,86 @@
 package strategy
 
 import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
 	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
 	"log"
 )
 
 type NewaveOpts struct {
 	Slow, Fast MACDOpts
+	// Short emits Sell when both MACDs are red.
+	Short bool
+	// Exits emits ExitLong once MACDs stop being green after a Buy, and
+	// ExitShort once they stop being red after a Sell.
+	Exits bool
+}
+
+func init() {
+	Register(Registration{
+		Name:   "newave",
+		Prefix: "newave",
+		Options: func() Options {
+			return &NewaveOpts{
+				Slow: MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: ts.Timeframe{N: 4, Unit: ts.TfDay}},
+				Fast: MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: ts.Timeframe{N: 1, Unit: ts.TfDay}},
+			}
+		},
+	})
 }
 
 func (opts NewaveOpts) NewNewave() *Newave {
 	return &Newave{
-		Slow: opts.Slow.NewMACDCross(),
-		Fast: opts.Fast.NewMACDCross(),
+		Slow:  opts.Slow.NewMACDCross(),
+		Fast:  opts.Fast.NewMACDCross(),
+		Short: opts.Short,
+		Exits: opts.Exits,
+	}
+}
+
+func (opts NewaveOpts) New() Strategy {
+	return opts.NewNewave()
+}
+
+func (opts NewaveOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Fast.Timeframe, opts.Slow.Timeframe}
+}
+
+// Move moves both MACDs, timeframes are kept.
+func (opts NewaveOpts) Move() Options {
+	opts.Fast = *opts.Fast.Move().(*MACDOpts)
+	opts.Slow = *opts.Slow.Move().(*MACDOpts)
+	return &opts
+}
+
+// Validate checks options of both MACDs.
+func (opts NewaveOpts) Validate() error {
+	if err := opts.Fast.Validate(); err != nil {
+		return fmt.Errorf("fast macd: %s", err)
 	}
+	if err := opts.Slow.Validate(); err != nil {
+		return fmt.Errorf("slow macd: %s", err)
+	}
+	return nil
+}
+
+func (opts NewaveOpts) String() string {
+	s := fmt.Sprintf("macd(%s, %s) & macd(%s, %s)",
+		opts.Fast.Timeframe, opts.Fast, opts.Slow.Timeframe, opts.Slow)
+	if opts.Short {
+		s += ", short"
+	}
+	if opts.Exits {
+		s += ", exits"
+	}
+	return s
 }
 
 type Newave struct {
 	Slow, Fast *MACDCross
+	Short      bool
+	Exits      bool
 	LastSignal Signal
 }
 
@@ -30,18 +93,36 @@ func (nw *Newave) AddTick(x trading.Tick) {
 		log.Printf("bad timeframe: %s", x.Timeframe)
 	}
 
-	if nw.Fast.LastSignal.Action == nw.Slow.LastSignal.Action &&
-		nw.Fast.LastSignal.Action == Buy {
+	action := nw.Fast.LastSignal.Action
+	if action == nw.Slow.LastSignal.Action && (action == Buy || action == Sell && nw.Short) {
 		nw.LastSignal = Signal{
-			Action:   Buy,
+			Action:   action,
 			Time:     nw.Fast.LastSignal.Time,
 			Strength: 1,
 		}
-	} else {
+	} else if !nw.Exits {
 		nw.LastSignal = NoSignal
+	} else if nw.LastSignal.Action == Buy {
+		nw.LastSignal = newSignal(ExitLong, x.OHLCV)
+	} else if nw.LastSignal.Action == Sell {
+		nw.LastSignal = newSignal(ExitShort, x.OHLCV)
 	}
 }
 
 func (nw *Newave) Signal() Signal {
 	return nw.LastSignal
 }
+
+func (nw *Newave) Record(on bool) {
+	nw.Fast.Record(on)
+	nw.Slow.Record(on)
+}
+
+func (nw *Newave) Draw() error {
+	err := nw.Fast.Draw()
+	if err != nil {
+		return err
+	}
+	chart.NextLineTheme()
+	return nw.Slow.Draw()
+}
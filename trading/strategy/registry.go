@@ -0,0 +1,186 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rkjdid/gocx/ts"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Options are the parameters of a registered strategy.
+type Options interface {
+	fmt.Stringer
+	// New returns a strategy of these options.
+	New() Strategy
+	// Timeframes returns timeframes the strategy is fed with, zero ones
+	// standing for the timeframe of the source it is run on.
+	Timeframes() []ts.Timeframe
+	// Move returns options randomly moved within their search space.
+	Move() Options
+	// Validate checks options make a working strategy.
+	Validate() error
+}
+
+// Registration is a strategy known by Name. Results of its backtests are
+// stored under keys prefixed with Prefix.
+type Registration struct {
+	Name, Prefix string
+	// Options returns a pointer to default options, to decode configs in.
+	Options func() Options
+}
+
+var registry = map[string]Registration{}
+
+// Register registers r, it panics if r.Name or r.Prefix is already registered.
+func Register(r Registration) {
+	for _, other := range registry {
+		if other.Name == r.Name || other.Prefix == r.Prefix {
+			panic(fmt.Sprintf("strategy %s: %s already registered", r.Name, other.Name))
+		}
+	}
+	registry[r.Name] = r
+}
+
+// Lookup returns the strategy registered as name.
+func Lookup(name string) (Registration, error) {
+	r, ok := registry[name]
+	if !ok {
+		return r, fmt.Errorf("unknown strategy %q", name)
+	}
+	return r, nil
+}
+
+// LookupPrefix returns the strategy registered with the prefix of key,
+// the part before its first ':'.
+func LookupPrefix(key string) (Registration, error) {
+	prefix := strings.SplitN(key, ":", 2)[0]
+	for _, r := range registry {
+		if r.Prefix == prefix {
+			return r, nil
+		}
+	}
+	return Registration{}, fmt.Errorf("unsupported hash prefix: %s", prefix)
+}
+
+// Registered returns names of registered strategies, sorted.
+func Registered() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config is a registered strategy Name with its Options, decoded from JSON
+// or YAML such as:
+//
+//	name: rsi
+//	options:
+//	  period: 14
+//	  oversold: 30
+//	  overbought: 70
+//	  timeframe: 4h
+//
+// Options not set keep their default value, and decoded options are
+// validated, see Options.Validate.
+type Config struct {
+	Name    string
+	Options Options
+}
+
+// NewConfig returns the config of name with default options.
+func NewConfig(name string) (Config, error) {
+	r, err := Lookup(name)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{Name: name, Options: r.Options()}, nil
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name    string
+		Options json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	cfg, err := NewConfig(raw.Name)
+	if err != nil {
+		return err
+	}
+	if len(raw.Options) > 0 {
+		if err = json.Unmarshal(raw.Options, cfg.Options); err != nil {
+			return fmt.Errorf("%s options: %s", raw.Name, err)
+		}
+	}
+	if err = cfg.Options.Validate(); err != nil {
+		return fmt.Errorf("%s options: %s", raw.Name, err)
+	}
+	*c = cfg
+	return nil
+}
+
+// Move returns c with options moved, see Options.Move.
+func (c Config) Move() Config {
+	c.Options = c.Options.Move()
+	return c
+}
+
+func (c Config) New() Strategy {
+	return c.Options.New()
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("%s(%s)", c.Name, c.Options)
+}
+
+// ParseConfig decodes a Config from JSON or YAML data.
+func ParseConfig(data []byte) (Config, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return Config{}, err
+	}
+	data, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return Config{}, err
+	}
+	var c Config
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// LoadConfig decodes a Config from JSON or YAML file at path.
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	c, err := ParseConfig(data)
+	if err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// jsonValue converts maps of v decoded from YAML to maps of string keys,
+// which encoding/json requires.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			m[fmt.Sprint(k)] = jsonValue(x)
+		}
+		return m
+	case []interface{}:
+		for i, x := range v {
+			v[i] = jsonValue(x)
+		}
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package strategy
+
+import (
+	"encoding/json"
+	"github.com/rkjdid/gocx/ts"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	expected := []string{"bollinger", "composite", "donchian", "emacross", "ichimoku",
+		"macd", "newave", "rsi", "supertrend"}
+	if names := Registered(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	for _, name := range expected {
+		r, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Options().New() == nil {
+			t.Errorf("%s: nil strategy", name)
+		}
+		if r2, err := LookupPrefix(r.Prefix + ":BTCUSDT:abc"); err != nil || r2.Name != name {
+			t.Errorf("%s: LookupPrefix: %v %s", name, err, r2.Name)
+		}
+	}
+	if _, err := Lookup("nope"); err == nil {
+		t.Error("expected unknown strategy error")
+	}
+	if _, err := LookupPrefix("nope:BTCUSDT"); err == nil {
+		t.Error("expected unsupported prefix error")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+name: composite
+options:
+  mode: weighted
+  threshold: 0.5
+  children:
+    - name: rsi
+      weight: 2
+      options:
+        oversold: 25
+        timeframe: 4h
+    - name: emacross
+      options: {fast: 5, slow: 13}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h4 := ts.Timeframe{N: 4, Unit: ts.TfHour}
+	expected := Config{
+		Name: "composite",
+		Options: &CompositeConfig{
+			CompositeOpts: CompositeOpts{Mode: Weighted, Threshold: 0.5},
+			Children: []ChildConfig{
+				{Config{"rsi", &RSIOpts{Period: 14, Oversold: 25, Overbought: 70, Timeframe: h4}}, 2},
+				{Config{"emacross", &EMACrossOpts{Fast: 5, Slow: 13}}, 0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("expected %s, got %s", expected, cfg)
+	}
+	if s := cfg.String(); s != "composite(weighted 0.5, 2*rsi(14, 25, 70), emacross(5, 13))" {
+		t.Errorf("unexpected string %s", s)
+	}
+
+	// json encoding round trips, and is a valid config
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg2, err := ParseConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg2, expected) {
+		t.Errorf("expected %s, got %s from %s", expected, cfg2, data)
+	}
+
+	c := cfg.New().(*Composite)
+	if len(c.Children) != 2 || !c.Children[0].Timeframe.Equals(h4) || c.Children[1].Timeframe != (ts.Timeframe{}) {
+		t.Errorf("unexpected children %+v", c.Children)
+	}
+	if tfs := cfg.Options.Timeframes(); len(tfs) != 2 {
+		t.Errorf("expected h4 & zero timeframes, got %v", tfs)
+	}
+
+	for _, data := range []string{
+		`name: nope`,
+		`{"name": "rsi", "options": {"period": "x"}}`,
+		`{"name": "composite", "options": {"mode": "nope"}}`,
+		`{"name": "composite", "options": {"children": [{"name": "nope"}]}}`,
+		`{"name": "bollinger", "options": {"period": 0}}`,
+		`{"name": "donchian", "options": {"period": 0}}`,
+		`{"name": "macd", "options": {"fast": 26, "slow": 12}}`,
+		`{"name": "rsi", "options": {"oversold": 80}}`,
+		`{"name": "rsi", "options": {"period": 1}}`,
+		`{"name": "supertrend", "options": {"multiplier": -1}}`,
+		`{"name": "newave", "options": {"slow": {"signalperiod": 0}}}`,
+		`{"name": "composite", "options": {}}`,
+		`{"name": "composite", "options": {"threshold": 2, "children": [{"name": "rsi"}]}}`,
+		`{"name": "composite", "options": {"children": [{"name": "ichimoku", "options": {"kijun": 0}}]}}`,
+	} {
+		if _, err := ParseConfig([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+}
+
+func TestConfig_Move(t *testing.T) {
+	cfg, err := NewConfig("newave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		next := cfg.Move()
+		if err := next.Options.Validate(); err != nil {
+			t.Fatalf("moved to invalid %s: %s", next, err)
+		}
+		opts := next.Options.(*NewaveOpts)
+		for _, m := range []MACDOpts{opts.Fast, opts.Slow} {
+			if m.Fast > m.Slow || m.Fast < 2 || m.Slow > 89 || m.SignalPeriod < 2 {
+				t.Fatalf("out of range %s", m)
+			}
+		}
+		if !opts.Slow.Timeframe.Equals(cfg.Options.(*NewaveOpts).Slow.Timeframe) {
+			t.Fatal("timeframes should not move")
+		}
+		cfg = next
+	}
+}
@@ -0,0 +1,107 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+)
+
+type RSIOpts struct {
+	Period               int
+	Oversold, Overbought float64
+	Timeframe            ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "rsi",
+		Prefix:  "rsi",
+		Options: func() Options { return &RSIOpts{Period: 14, Oversold: 30, Overbought: 70} },
+	})
+}
+
+func (opts RSIOpts) NewRSIReversion() *RSIReversion {
+	return &RSIReversion{RSIOpts: opts, rsi: indicator.NewRSI(opts.Period)}
+}
+
+func (opts RSIOpts) New() Strategy {
+	return opts.NewRSIReversion()
+}
+
+func (opts RSIOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts RSIOpts) Move() Options {
+	opts.Period += util.RandRange(-1, 1)
+	opts.Oversold += util.RandRangeF(-2, 2)
+	opts.Overbought += util.RandRangeF(-2, 2)
+	util.FixRangeLinear(&opts.Period, 2, 34)
+	util.FixRangeLinearF(&opts.Oversold, 5, 45)
+	util.FixRangeLinearF(&opts.Overbought, 55, 95)
+	return &opts
+}
+
+// Validate checks opts are of a period of at least 2, with 0 < Oversold <
+// Overbought < 100.
+func (opts RSIOpts) Validate() error {
+	if opts.Period < 2 {
+		return fmt.Errorf("invalid period %d", opts.Period)
+	}
+	if opts.Oversold <= 0 || opts.Oversold >= opts.Overbought || opts.Overbought >= 100 {
+		return fmt.Errorf("invalid oversold %g & overbought %g", opts.Oversold, opts.Overbought)
+	}
+	return nil
+}
+
+func (opts RSIOpts) String() string {
+	return fmt.Sprintf("%d, %g, %g", opts.Period, opts.Oversold, opts.Overbought)
+}
+
+// RSIReversion is a mean reversion strategy, it buys when RSI rises back
+// above Oversold and sells when it falls back below Overbought.
+type RSIReversion struct {
+	RSIOpts
+	Data       ts.OHLCVs
+	OutRSI     []float64
+	LastSignal Signal
+	rsi        *indicator.RSI
+	history
+}
+
+func (r *RSIReversion) AddTick(x trading.Tick) {
+	r.Data = r.keepBars(append(r.Data, x.OHLCV), 2)
+	r.rsi.Add(x.OHLCV)
+	if !r.rsi.Ready() {
+		return
+	}
+	r.OutRSI = r.keep(append(r.OutRSI, r.rsi.Value()), 2)
+	if len(r.OutRSI) < 2 {
+		return
+	}
+	v0, v := r.OutRSI[len(r.OutRSI)-2], r.OutRSI[len(r.OutRSI)-1]
+	if v0 < r.Oversold && v >= r.Oversold {
+		r.LastSignal = newSignal(Buy, x.OHLCV)
+	} else if v0 > r.Overbought && v <= r.Overbought {
+		r.LastSignal = newSignal(Sell, x.OHLCV)
+	}
+}
+
+func (r *RSIReversion) Signal() Signal {
+	return r.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (r *RSIReversion) WarmUp() int {
+	return r.rsi.WarmUp() + 1
+}
+
+func (r *RSIReversion) Draw() error {
+	chart.AddLines([]string{"rsi"}, r.Data.ToXYerSlice(r.OutRSI)...)
+	chart.AddHorizontalFromTo(r.Oversold, r.Data.X0(), r.Data.XN(), "oversold")
+	chart.AddHorizontalFromTo(r.Overbought, r.Data.X0(), r.Data.XN(), "overbought")
+	return nil
+}
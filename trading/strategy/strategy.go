@@ -3,6 +3,7 @@ package strategy
 import (
 	"fmt"
 	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
 	"github.com/rkjdid/util"
 	"time"
 )
@@ -12,6 +13,39 @@ type Strategy interface {
 	Signal() Signal
 }
 
+// Drawer is implemented by strategies able to draw their indicators on chart.
+// They keep the history of their indicators to draw only once recording, and
+// otherwise only their last values, so they do not grow when run live.
+type Drawer interface {
+	Record(bool)
+	Draw() error
+}
+
+// history is embedded by drawers to trim their series when not recording.
+type history struct {
+	recording bool
+}
+
+func (h *history) Record(on bool) {
+	h.recording = on
+}
+
+// keep returns s, or only its last n values when not recording.
+func (h history) keep(s []float64, n int) []float64 {
+	if h.recording || len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// keepBars is keep for bars.
+func (h history) keepBars(bars ts.OHLCVs, n int) ts.OHLCVs {
+	if h.recording || len(bars) <= n {
+		return bars
+	}
+	return bars[len(bars)-n:]
+}
+
 type Signal struct {
 	Time     time.Time
 	Action   Action
@@ -21,6 +55,11 @@ type Signal struct {
 
 var NoSignal Signal
 
+// newSignal returns a full strength signal of a at bar o.
+func newSignal(a Action, o ts.OHLCV) Signal {
+	return Signal{Action: a, Time: o.Timestamp.T(), Strength: 1}
+}
+
 func (sig Signal) String() string {
 	return fmt.Sprintf("%4s %s (%f)",
 		sig.Action, util.ParisTime(sig.Time), sig.Strength)
@@ -30,8 +69,17 @@ type Action int
 
 const (
 	None = Action(iota)
-	Buy
-	Sell
+	// EntryLong opens long positions, and EntryShort short ones.
+	EntryLong
+	EntryShort
+	// ExitLong closes long positions, and ExitShort short ones.
+	ExitLong
+	ExitShort
+)
+
+const (
+	Buy  = EntryLong
+	Sell = EntryShort
 )
 
 func (a Action) String() string {
@@ -42,6 +90,19 @@ func (a Action) String() string {
 		return "BUY"
 	case Sell:
 		return "SELL"
+	case ExitLong:
+		return "EXIT LONG"
+	case ExitShort:
+		return "EXIT SHORT"
 	}
 	return ""
 }
+
+// Closes reports whether a closes positions of direction d. Exits of d do,
+// and so do entries of the opposite direction when reversing.
+func (a Action) Closes(d trading.Direction, reverse bool) bool {
+	if d == trading.Long {
+		return a == ExitLong || reverse && a == EntryShort
+	}
+	return a == ExitShort || reverse && a == EntryLong
+}
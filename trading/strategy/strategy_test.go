@@ -0,0 +1,163 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/ccxt/ccxt/go/util"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/ts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files of testdata")
+
+var hourly = ts.Timeframe{N: 1, Unit: ts.TfHour}
+
+// fixture returns bars of testdata/ohlcv.csv.
+func fixture(t *testing.T) ts.OHLCVs {
+	f, err := os.Open(filepath.Join("testdata", "ohlcv.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data ts.OHLCVs
+	for _, r := range records[1:] {
+		var v [6]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(r[i], 64); err != nil {
+				t.Fatalf("bad fixture record %v: %s", r, err)
+			}
+		}
+		data = append(data, ts.OHLCV{
+			Timestamp: util.JSONTime(time.Unix(int64(v[0]), 0).UTC()),
+			Open:      v[1], High: v[2], Low: v[3], Close: v[4], Volume: v[5],
+		})
+	}
+	return data
+}
+
+// golden compares got to testdata/name.golden, or writes it with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s:\n%s", name, path, got)
+	}
+}
+
+// signals feeds data to s, and lists signals it emits.
+func signals(s Strategy, data ts.OHLCVs) []byte {
+	var buf bytes.Buffer
+	var last Signal
+	for _, o := range data {
+		s.AddTick(trading.Tick{Timeframe: hourly, OHLCV: o})
+		if sig := s.Signal(); sig != last {
+			last = sig
+			fmt.Fprintf(&buf, "%s %-4s %.2f\n", sig.Time.UTC().Format(time.RFC3339), sig.Action, sig.Strength)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestStrategies_Golden(t *testing.T) {
+	data := fixture(t)
+	for _, c := range []struct {
+		name     string
+		strategy Strategy
+	}{
+		{"macdcross", MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: hourly}.NewMACDCross()},
+		{"macdcross_exits", MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: hourly, Exits: true}.NewMACDCross()},
+		{"rsi", RSIOpts{14, 30, 70, hourly}.NewRSIReversion()},
+		{"bollinger_breakout", BollingerOpts{20, 2, true, hourly}.NewBollinger()},
+		{"bollinger_reversion", BollingerOpts{20, 2, false, hourly}.NewBollinger()},
+		{"emacross", EMACrossOpts{9, 21, hourly}.NewEMACross()},
+		{"donchian", DonchianOpts{20, hourly}.NewDonchian()},
+		{"ichimoku", IchimokuOpts{9, 26, 52, hourly}.NewIchimoku()},
+		{"supertrend", SupertrendOpts{10, 3, hourly}.NewSupertrend()},
+		{"composite_vote", CompositeOpts{Mode: Majority}.NewComposite(
+			Child{Strategy: EMACrossOpts{9, 21, hourly}.NewEMACross()},
+			Child{Strategy: SupertrendOpts{10, 3, hourly}.NewSupertrend()},
+			Child{Strategy: MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: hourly}.NewMACDCross()},
+		)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, ok := c.strategy.(Drawer)
+			if !ok {
+				t.Fatalf("%s is not a Drawer", c.name)
+			}
+			d.Record(true)
+			golden(t, c.name, signals(c.strategy, data))
+			chart.Reset()
+			chart.AddOHLCVs(data)
+			if err := d.Draw(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDrawer_Record(t *testing.T) {
+	data := fixture(t)
+	opts := MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: hourly}
+	recorded, trimmed := opts.NewMACDCross(), opts.NewMACDCross()
+	recorded.Record(true)
+	if a, b := signals(recorded, data), signals(trimmed, data); !bytes.Equal(a, b) {
+		t.Errorf("signals differ when recording:\n%s\n%s", a, b)
+	}
+	if len(recorded.Data) != len(data) || len(recorded.OutHist) < len(data)/2 {
+		t.Errorf("expected whole history recorded, got %d bars & %d values",
+			len(recorded.Data), len(recorded.OutHist))
+	}
+	if len(trimmed.Data) != 2 || len(trimmed.OutMACD) != 2 || len(trimmed.OutSignal) != 2 || len(trimmed.OutHist) != 2 {
+		t.Errorf("expected last 2 values kept, got %d bars & %d values",
+			len(trimmed.Data), len(trimmed.OutHist))
+	}
+}
+
+func TestNewave_Exits(t *testing.T) {
+	h4 := ts.Timeframe{N: 4, Unit: ts.TfHour}
+	nw := NewaveOpts{
+		Fast:  MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: hourly},
+		Slow:  MACDOpts{Fast: 12, Slow: 26, SignalPeriod: 9, Timeframe: h4},
+		Exits: true,
+	}.NewNewave()
+	var actions []Action
+	for _, o := range fixture(t) {
+		// same bars on both timeframes, so that macds agree
+		nw.AddTick(trading.Tick{Timeframe: hourly, OHLCV: o})
+		nw.AddTick(trading.Tick{Timeframe: h4, OHLCV: o})
+		if a := nw.Signal().Action; len(actions) == 0 || a != actions[len(actions)-1] {
+			actions = append(actions, a)
+		}
+	}
+	if len(actions) < 4 {
+		t.Fatalf("expected a few signals, got %v", actions)
+	}
+	for i, a := range actions[1:] {
+		if expected := []Action{Buy, ExitLong}[i%2]; a != expected {
+			t.Fatalf("expected buys & exits to alternate, got %v", actions)
+		}
+	}
+}
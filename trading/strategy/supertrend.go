@@ -0,0 +1,104 @@
+package strategy
+
+import (
+	"fmt"
+	"github.com/rkjdid/gocx/chart"
+	"github.com/rkjdid/gocx/trading"
+	"github.com/rkjdid/gocx/trading/indicator"
+	"github.com/rkjdid/gocx/ts"
+	"github.com/rkjdid/gocx/util"
+)
+
+type SupertrendOpts struct {
+	Period     int
+	Multiplier float64
+	Timeframe  ts.Timeframe
+}
+
+func init() {
+	Register(Registration{
+		Name:    "supertrend",
+		Prefix:  "supertrend",
+		Options: func() Options { return &SupertrendOpts{Period: 10, Multiplier: 3} },
+	})
+}
+
+func (opts SupertrendOpts) NewSupertrend() *Supertrend {
+	return &Supertrend{
+		SupertrendOpts: opts,
+		trend:          indicator.NewSupertrend(opts.Period, opts.Multiplier),
+	}
+}
+
+func (opts SupertrendOpts) New() Strategy {
+	return opts.NewSupertrend()
+}
+
+func (opts SupertrendOpts) Timeframes() []ts.Timeframe {
+	return []ts.Timeframe{opts.Timeframe}
+}
+
+func (opts SupertrendOpts) Move() Options {
+	opts.Period += util.RandRange(-1, 1)
+	opts.Multiplier += util.RandRangeF(-0.1, 0.1)
+	util.FixRangeLinear(&opts.Period, 5, 55)
+	util.FixRangeLinearF(&opts.Multiplier, 1, 6)
+	return &opts
+}
+
+// Validate checks opts are of a positive period & multiplier.
+func (opts SupertrendOpts) Validate() error {
+	if opts.Period <= 0 {
+		return fmt.Errorf("invalid period %d", opts.Period)
+	}
+	if opts.Multiplier <= 0 {
+		return fmt.Errorf("invalid multiplier %g", opts.Multiplier)
+	}
+	return nil
+}
+
+func (opts SupertrendOpts) String() string {
+	return fmt.Sprintf("%d, %g", opts.Period, opts.Multiplier)
+}
+
+// Supertrend buys when the supertrend turns up, and sells when it turns down.
+type Supertrend struct {
+	SupertrendOpts
+	Data          ts.OHLCVs
+	OutSupertrend []float64
+	LastSignal    Signal
+	trend         *indicator.Supertrend
+	history
+}
+
+func (s *Supertrend) AddTick(x trading.Tick) {
+	s.Data = s.keepBars(append(s.Data, x.OHLCV), 2)
+	ready, up := s.trend.Ready(), s.trend.Up()
+	s.trend.Add(x.OHLCV)
+	if !s.trend.Ready() {
+		return
+	}
+	s.OutSupertrend = s.keep(append(s.OutSupertrend, s.trend.Value()), 2)
+	if !ready || up == s.trend.Up() {
+		return
+	}
+	if s.trend.Up() {
+		s.LastSignal = newSignal(Buy, x.OHLCV)
+	} else {
+		s.LastSignal = newSignal(Sell, x.OHLCV)
+	}
+}
+
+func (s *Supertrend) Signal() Signal {
+	return s.LastSignal
+}
+
+// WarmUp is the number of ticks fed before a first signal may be emitted.
+func (s *Supertrend) WarmUp() int {
+	return s.trend.WarmUp() + 1
+}
+
+func (s *Supertrend) Draw() error {
+	chart.AddLines([]string{"supertrend"}, s.Data.ToXYerSlice(s.OutSupertrend)...)
+	return nil
+}
@@ -0,0 +1,99 @@
+package trading
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SymbolInfo holds exchange trading rules of a symbol. Zero values disable a rule.
+type SymbolInfo struct {
+	Symbol      string
+	Base, Quote string
+
+	// StepSize is the quantity increment, MinQty & MaxQty its bounds.
+	StepSize float64
+	MinQty   float64
+	MaxQty   float64
+	// TickSize is the price increment.
+	TickSize float64
+	// MinNotional is the minimum price * quantity of an order.
+	MinNotional float64
+}
+
+// Symbols is a registry of SymbolInfo by symbol.
+type Symbols map[string]SymbolInfo
+
+// Get returns info of sym, ok is false for unknown symbols.
+func (ss Symbols) Get(sym string) (info SymbolInfo, ok bool) {
+	info, ok = ss[sym]
+	return info, ok
+}
+
+// RoundQuantity rounds q down to s step size, so that orders never exceed
+// available funds.
+func (s SymbolInfo) RoundQuantity(q float64) float64 {
+	return roundStep(q, s.StepSize, math.Floor)
+}
+
+// RoundPrice rounds price to the nearest s tick.
+func (s SymbolInfo) RoundPrice(price float64) float64 {
+	return roundStep(price, s.TickSize, math.Round)
+}
+
+// FormatQuantity formats q with as many decimals as s step size.
+func (s SymbolInfo) FormatQuantity(q float64) string {
+	return formatStep(q, s.StepSize)
+}
+
+// FormatPrice formats price with as many decimals as s tick size.
+func (s SymbolInfo) FormatPrice(price float64) string {
+	return formatStep(price, s.TickSize)
+}
+
+// Apply returns o with quantity & prices rounded to s increments, or an error if
+// the result breaks s bounds. price is the reference price of market orders.
+func (s SymbolInfo) Apply(o Order, price float64) (Order, error) {
+	o.Quantity = s.RoundQuantity(o.Quantity)
+	o.Price = s.RoundPrice(o.Price)
+	o.StopPrice = s.RoundPrice(o.StopPrice)
+	if o.Quantity <= 0 || o.Quantity < s.MinQty {
+		return o, fmt.Errorf("%s: quantity %s below minimum %s",
+			s.Symbol, s.FormatQuantity(o.Quantity), s.FormatQuantity(s.MinQty))
+	}
+	if s.MaxQty > 0 && o.Quantity > s.MaxQty {
+		return o, fmt.Errorf("%s: quantity %s above maximum %s",
+			s.Symbol, s.FormatQuantity(o.Quantity), s.FormatQuantity(s.MaxQty))
+	}
+	if o.Price > 0 {
+		price = o.Price
+	} else if o.StopPrice > 0 {
+		price = o.StopPrice
+	}
+	if price > 0 && o.Quantity*price < s.MinNotional {
+		return o, fmt.Errorf("%s: notional %f below minimum %f", s.Symbol, o.Quantity*price, s.MinNotional)
+	}
+	return o, nil
+}
+
+func roundStep(x, step float64, round func(float64) float64) float64 {
+	if step <= 0 || x == 0 {
+		return x
+	}
+	// tolerate float noise, e.g. 0.3 / 0.1 = 2.9999999999999996
+	n := round(x/step + 1e-9)
+	v, _ := strconv.ParseFloat(formatStep(n*step, step), 64)
+	return v
+}
+
+func formatStep(x, step float64) string {
+	if step <= 0 {
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	decimals := 0
+	if s := strconv.FormatFloat(step, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+	return strconv.FormatFloat(x, 'f', decimals, 64)
+}
@@ -0,0 +1,52 @@
+package trading
+
+import "testing"
+
+func TestSymbolInfo_Apply(t *testing.T) {
+	info := SymbolInfo{Symbol: "ABCBTC", StepSize: 0.01, MinQty: 0.1, MaxQty: 100, TickSize: 0.0001, MinNotional: 0.001}
+	if q := info.RoundQuantity(0.3); q != 0.3 {
+		t.Errorf("expected 0.3 to be left as is, got %v", q)
+	}
+	if s := info.FormatQuantity(1.23999); s != "1.24" {
+		t.Errorf("expected 1.24, got %s", s)
+	}
+	for _, tc := range []struct {
+		name   string
+		o      Order
+		price  float64
+		q, p   float64
+		wantOk bool
+	}{
+		{"rounds down", Order{Type: MarketOrder, Quantity: 1.239}, 0.01, 1.23, 0, true},
+		{"rounds price", Order{Type: LimitOrder, Quantity: 1, Price: 0.012345}, 0, 1, 0.0123, true},
+		{"min qty", Order{Type: MarketOrder, Quantity: 0.099}, 0.01, 0.09, 0, false},
+		{"max qty", Order{Type: MarketOrder, Quantity: 101}, 0.01, 101, 0, false},
+		{"min notional", Order{Type: MarketOrder, Quantity: 0.5}, 0.001, 0.5, 0, false},
+		{"limit notional", Order{Type: LimitOrder, Quantity: 0.5, Price: 0.001}, 10, 0.5, 0.001, false},
+	} {
+		o, err := info.Apply(tc.o, tc.price)
+		if (err == nil) != tc.wantOk {
+			t.Errorf("%s: expected ok %v, got %v", tc.name, tc.wantOk, err)
+		}
+		if o.Quantity != tc.q || o.Price != tc.p {
+			t.Errorf("%s: expected %v @ %v, got %v @ %v", tc.name, tc.q, tc.p, o.Quantity, o.Price)
+		}
+	}
+}
+
+func TestPaperTrading_Symbols(t *testing.T) {
+	pt := &PaperTrading{
+		Price:   0.01,
+		Symbols: Symbols{"ABCBTC": {Symbol: "ABCBTC", StepSize: 1, MinNotional: 0.1}},
+	}
+	txs, err := pt.MarketBuy("ABCBTC", 12.7)
+	if err != nil || txs[0].Quantity != 12 {
+		t.Errorf("expected quantity rounded to 12, got %v", err)
+	}
+	if _, err := pt.PlaceOrder(Order{Symbol: "ABCBTC", Direction: Buy, Type: MarketOrder, Quantity: 9}); err == nil {
+		t.Errorf("expected order below min notional to be rejected")
+	}
+	if _, err := pt.MarketBuy("XYZBTC", 0.5); err != nil {
+		t.Errorf("expected symbols without rules to be left as is, got %v", err)
+	}
+}
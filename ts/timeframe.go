@@ -1,6 +1,7 @@
 package ts
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -52,6 +53,21 @@ func (tf Timeframe) Lt(tf2 Timeframe) bool {
 	return tf.Diff(tf2) < 0
 }
 
+// UnmarshalJSON decodes tf from its fields, or from a string such as "4h".
+func (tf *Timeframe) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := ParseTf(s)
+		if err != nil {
+			return err
+		}
+		*tf = v
+		return nil
+	}
+	type fields Timeframe
+	return json.Unmarshal(data, (*fields)(tf))
+}
+
 func ParseTf(tf string) (Timeframe, error) {
 	ttf := Timeframe{
 		N: 1, // default
@@ -1,6 +1,7 @@
 package ts
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 )
@@ -54,3 +55,20 @@ func TestDurationToTf(t *testing.T) {
 		}
 	}
 }
+
+func TestTimeframe_UnmarshalJSON(t *testing.T) {
+	var tfs []Timeframe
+	err := json.Unmarshal([]byte(`[{"N": 2, "Unit": "hour"}, "4h", "1day"]`), &tfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Timeframe{{2, TfHour}, {4, TfHour}, {1, TfDay}}
+	for i, tf := range expected {
+		if !tfs[i].Equals(tf) {
+			t.Errorf("%d: expected %s, got %s", i, tf, tfs[i])
+		}
+	}
+	if err = json.Unmarshal([]byte(`"4x"`), &tfs[0]); err == nil {
+		t.Error("expected error on invalid unit")
+	}
+}